@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"fmt"
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// error policies for a sequence of commands
+const (
+	onErrorStop     = "stop"     // stop at first error
+	onErrorContinue = "continue" // execute all commands and report errors at the end
+	onErrorRollback = "rollback" // stop at first error and execute rollback commands
+)
+
+const defaultRetryDelay = 500 * time.Millisecond
+
+// compileCommands validates commands from configuration and converts them in
+// their runtime representation
+func (kc *keypadsControllerData) compileCommands(commands []keybindingCommandItem) ([]keybindingRuntimeItem, error) {
+	runtimecommands := make([]keybindingRuntimeItem, len(commands))
+
+	for index, command := range commands {
+		item := &runtimecommands[index]
+
+		if command.When != "" {
+			condition, err := parseExpression(command.When)
+
+			if err != nil {
+				return nil, err
+			}
+
+			kc.conditions = append(kc.conditions, condition)
+			item.Condition = condition
+
+			item.Then, err = kc.compileCommands(command.Then)
+
+			if err != nil {
+				return nil, err
+			}
+
+			item.Else, err = kc.compileCommands(command.Else)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if command.Command == "" && len(command.Then) == 0 && len(command.Else) == 0 {
+				return nil, fmt.Errorf("Condition %s has no commands", command.When)
+			}
+		} else if len(command.Then) != 0 || len(command.Else) != 0 {
+			return nil, fmt.Errorf("Then/else commands can be used only with a when condition")
+		}
+
+		var err error
+
+		item.Rollback, err = kc.compileCommands(command.Rollback)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(command.Parallel) != 0 {
+			if command.Command != "" {
+				return nil, fmt.Errorf("Command %s can't have a parallel block", command.Command)
+			}
+
+			item.Parallel, err = kc.compileCommands(command.Parallel)
+
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if command.Command == "" {
+			if command.When == "" {
+				return nil, fmt.Errorf("Missing command name")
+			}
+			continue
+		}
+
+		if command.Timeout != "" {
+			item.Timeout, err = time.ParseDuration(command.Timeout)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid timeout for command %s: %v", command.Command, err)
+			}
+		}
+
+		if command.Retries < 0 {
+			return nil, fmt.Errorf("Invalid retries count for command %s", command.Command)
+		}
+
+		item.Retries = command.Retries
+		item.RetryDelay = defaultRetryDelay
+
+		if command.RetryDelay != "" {
+			item.RetryDelay, err = time.ParseDuration(command.RetryDelay)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid retry delay for command %s: %v", command.Command, err)
+			}
+		}
+
+		cmdparts := strings.SplitN(command.Command, ".", 2)
+
+		if len(cmdparts) != 2 {
+			return nil, fmt.Errorf("Invalid command %s, use <target>.<command>", command.Command)
+		}
+
+		parameters, templated, err := compileParameters(command.Parameters)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if cmdparts[0] == macroTarget {
+			item.Macro, err = kc.compileMacro(cmdparts[1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(command.Parameters) != len(item.Macro.Parameters) {
+				return nil, fmt.Errorf("Macro %s requires %d parameters", cmdparts[1], len(item.Macro.Parameters))
+			}
+
+			item.Command = cmdparts[1]
+			item.Parameters = parameters
+			item.Templated = templated
+			continue
+		}
+
+		target := kc.targets[cmdparts[0]]
+
+		if target == nil {
+			return nil, fmt.Errorf("Invalid command target %s", cmdparts[0])
+		}
+
+		// values of templated parameters are checked after expansion, at execution time
+		err = target.CheckCommand(cmdparts[1], templatePlaceholders(parameters).([]interface{}))
+
+		if err != nil {
+			return nil, err
+		}
+
+		item.Target = target
+		item.Command = cmdparts[1]
+		item.Parameters = parameters
+		item.Templated = templated
+	}
+
+	return runtimecommands, nil
+}
+
+// sequenceRunner executes the commands triggered by a single key event, it must
+// own the dispatcher turn, that is released while waiting for targets (see dispatcher.go)
+type sequenceRunner struct {
+	kc       *keypadsControllerData
+	event    keypad.Event
+	onError  string
+	rollback []rollbackItem // rollback commands of completed items, in execution order
+	errors   []error        // errors collected by continue policy
+	mutex    sync.Mutex     // protects rollback and errors when running parallel blocks
+}
+
+type rollbackItem struct {
+	items []keybindingRuntimeItem
+	args  map[string]interface{}
+}
+
+// executeSequence runs the commands associated with a key, applying its error policy
+func (kc *keypadsControllerData) executeSequence(sequence *keybindingSequence, event keypad.Event) error {
+	runner := sequenceRunner{kc: kc, event: event, onError: sequence.OnError}
+
+	err := runner.runItems(sequence.Items, nil)
+
+	if err != nil {
+		if runner.onError == onErrorRollback {
+			runner.runRollback()
+		}
+		return err
+	}
+
+	if len(runner.errors) == 1 {
+		return runner.errors[0]
+	}
+
+	if len(runner.errors) > 1 {
+		return fmt.Errorf("%d commands failed, first error: %v", len(runner.errors), runner.errors[0])
+	}
+	return nil
+}
+
+// runItems executes a list of commands, args are the parameters of the macro that
+// contains them (nil for bindings)
+func (r *sequenceRunner) runItems(items []keybindingRuntimeItem, args map[string]interface{}) error {
+	for _, item := range items {
+		err := r.runItem(item, args)
+
+		if err != nil {
+			if r.onError != onErrorContinue {
+				return err
+			}
+
+			log.Printf("Error %v processing key bindings for %s.%s, continuing", err, r.event.Source, r.event.Key)
+
+			r.mutex.Lock()
+			r.errors = append(r.errors, err)
+			r.mutex.Unlock()
+		}
+	}
+	return nil
+}
+
+func (r *sequenceRunner) runItem(item keybindingRuntimeItem, args map[string]interface{}) error {
+	if item.Condition != nil {
+		result, err := item.Condition.evaluate(r.kc.targets)
+
+		if err != nil {
+			return err
+		}
+
+		if !result {
+			return r.runItems(item.Else, args)
+		}
+	}
+
+	if len(item.Parallel) != 0 {
+		err := r.runParallel(item.Parallel, args)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if item.Macro != nil {
+		err := r.runMacro(item, args)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if item.Target != nil {
+		err := r.runCommand(item, args)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.runItems(item.Then, args)
+
+	if err != nil {
+		return err
+	}
+
+	if len(item.Rollback) != 0 {
+		r.mutex.Lock()
+		r.rollback = append(r.rollback, rollbackItem{items: item.Rollback, args: args})
+		r.mutex.Unlock()
+	}
+	return nil
+}
+
+// runParallel starts all the items at the same time and waits for their completion
+func (r *sequenceRunner) runParallel(items []keybindingRuntimeItem, args map[string]interface{}) error {
+	errors := make([]error, len(items))
+
+	var wg sync.WaitGroup
+
+	// each item runs as a separate job, waiting for its turn
+	for index, item := range items {
+		wg.Add(1)
+
+		go func(index int, item keybindingRuntimeItem) {
+			defer wg.Done()
+
+			r.kc.acquireTurn()
+			defer r.kc.releaseTurn()
+
+			errors[index] = r.runItem(item, args)
+		}(index, item)
+	}
+
+	r.kc.waitOutsideTurn(wg.Wait)
+
+	var failed []string
+
+	for _, err := range errors {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("Parallel block failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// runMacro executes the commands of a macro, with its own parameters
+func (r *sequenceRunner) runMacro(item keybindingRuntimeItem, args map[string]interface{}) error {
+	parameters := item.Parameters
+
+	if item.Templated {
+		var err error
+
+		parameters, err = expandParameters(item.Parameters, r.kc.templateData(r.event, args))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.runItems(item.Macro.Items, macroArgs(item.Macro, parameters))
+}
+
+// runCommand executes a command, retrying it if required
+func (r *sequenceRunner) runCommand(item keybindingRuntimeItem, args map[string]interface{}) error {
+	err := r.runCommandWithTimeout(item, args)
+
+	for retry := 0; err != nil && retry < item.Retries; retry++ {
+		log.Printf("Error %v executing %s, retrying", err, item.Command)
+
+		r.kc.waitOutsideTurn(func() { time.Sleep(item.RetryDelay) })
+		err = r.runCommandWithTimeout(item, args)
+	}
+	return err
+}
+
+func (r *sequenceRunner) runCommandWithTimeout(item keybindingRuntimeItem, args map[string]interface{}) error {
+	if item.Timeout == 0 {
+		return r.kc.executeCommand(item, r.event, args)
+	}
+
+	result := make(chan error, 1)
+	cancelled := false
+	var mutex sync.Mutex
+
+	// command is executed as a separate job, it's skipped if it gets its turn after timeout
+	go func() {
+		r.kc.acquireTurn()
+		defer r.kc.releaseTurn()
+
+		mutex.Lock()
+		skip := cancelled
+		mutex.Unlock()
+
+		if skip {
+			result <- nil
+			return
+		}
+
+		result <- r.kc.executeCommand(item, r.event, args)
+	}()
+
+	var err error
+
+	r.kc.waitOutsideTurn(func() {
+		select {
+		case err = <-result:
+		case <-time.After(item.Timeout):
+			mutex.Lock()
+			cancelled = true
+			mutex.Unlock()
+
+			err = fmt.Errorf("Timeout executing command %s", item.Command)
+		}
+	})
+	return err
+}
+
+// runRollback executes rollback commands of completed items, in reverse order
+func (r *sequenceRunner) runRollback() {
+	rollback := sequenceRunner{kc: r.kc, event: r.event, onError: onErrorContinue}
+
+	for index := len(r.rollback) - 1; index >= 0; index-- {
+		rollback.runItems(r.rollback[index].items, r.rollback[index].args)
+	}
+
+	if len(rollback.errors) != 0 {
+		log.Printf("%d errors executing rollback commands for %s.%s", len(rollback.errors), r.event.Source, r.event.Key)
+	}
+}
+
+func (kc *keypadsControllerData) executeCommand(item keybindingRuntimeItem, event keypad.Event, args map[string]interface{}) error {
+	parameters := item.Parameters
+
+	if item.Templated {
+		var err error
+
+		parameters, err = expandParameters(item.Parameters, kc.templateData(event, args))
+
+		if err != nil {
+			return err
+		}
+
+		err = item.Target.CheckCommand(item.Command, parameters)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return kc.executeTargetCommand(item.Target, item.Command, parameters)
+}
+
+// executeTargetCommand executes a command owning the turn only if it changes the state of the
+// controller (ex: bindings, variables), other targets are called outside the turn, so a slow
+// target does not delay other keys
+func (kc *keypadsControllerData) executeTargetCommand(target targets.CommandTarget, command string, parameters []interface{}) error {
+	if kc.inTurn[target] {
+		return target.ExecuteCommand(command, parameters)
+	}
+
+	var err error
+
+	if queue, ok := kc.queues[target]; ok {
+		result := queue.submit(command, parameters)
+
+		kc.waitOutsideTurn(func() { err = <-result })
+		return err
+	}
+
+	kc.waitOutsideTurn(func() { err = target.ExecuteCommand(command, parameters) })
+	return err
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"strings"
+	"testing"
+)
+
+// TestTemplatedCommandsCheck verifies that commands with templated parameters are checked
+// when they are loaded, except for the values of the templated parameters
+func TestTemplatedCommandsCheck(t *testing.T) {
+	target, err := targets.CreateCommand("testtarget")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target.Init(nil)
+
+	kc := keypadsControllerData{targets: map[string]targets.CommandTarget{"slow": target}}
+
+	tests := []struct {
+		command    string
+		parameters []interface{}
+		err        string
+	}{
+		{"slow.work", []interface{}{"{{.Value}}"}, ""},
+		{"slow.wrok", []interface{}{"{{.Value}}"}, "Invalid command wrok"},
+		{"slow.work", []interface{}{"{{.Value}}", 10}, "Invalid parameters count"},
+		{"slow.work", []interface{}{}, "Invalid parameters count"},
+		{"slow.work", []interface{}{"ten"}, "Invalid ms parameter"},
+	}
+
+	for _, test := range tests {
+		_, err := kc.compileCommands([]keybindingCommandItem{{Command: test.command, Parameters: test.parameters}})
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s %v: unexpected error %v", test.command, test.parameters, err)
+		}
+
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s %v: expected error %q, got %v", test.command, test.parameters, test.err, err)
+		}
+	}
+}
+
+// TestTemplatedExecCheck verifies that the executable of a run command is checked against the
+// allow list after template expansion, a command that can't be checked when it's loaded must
+// not run an executable that is not allowed
+func TestTemplatedExecCheck(t *testing.T) {
+	vars, keyevents := startTestController(t, `
+keypads:
+  - keypadtype: testkeypad
+    name: test
+targets:
+  - targettype: exec
+    config:
+      allow: ["true"]
+macros:
+  - name: launch
+    parameters: [program]
+    commands:
+      - command: exec.run
+        parameters: [["{{.Args.program}}"]]
+keybindings:
+  - bindings:
+      - keys: [denied]
+        commands:
+          - command: macro.launch
+            parameters: [echo]
+          - command: vars.set
+            parameters: [denied, ran]
+      - keys: [allowed]
+        commands:
+          - command: macro.launch
+            parameters: ["true"]
+          - command: vars.set
+            parameters: [allowed, ran]
+`)
+
+	keyevents <- keypad.Event{Source: "test", Key: "denied"}
+	keyevents <- keypad.Event{Source: "test", Key: "allowed"}
+
+	waitForVariables(t, vars, map[string]string{"allowed": "ran"})
+
+	// keys are processed in order and the check fails without releasing the turn
+	if value, ok := vars.GetState()["denied"]; ok {
+		t.Errorf("Command after a denied executable has been executed: %v", value)
+	}
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"keypad/targets"
+	"sort"
+	"strings"
+)
+
+// getCommands returns the commands that can be used in a configuration, by target name.
+// If a configuration file is specified, names of targets and macros are read from it,
+// otherwise each target type is described using its type as name. Commands are nil for
+// targets that declare them only when they are initialized (ex: external drivers)
+func getCommands(configfile string, profile string) (map[string]map[string]targets.CommandDefinition, []macroItem, error) {
+	commands := make(map[string]map[string]targets.CommandDefinition)
+	var macros []macroItem
+
+	if configfile != "" {
+		config, err := loadConfiguration(configfile, profile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, target := range config.Targets {
+			name := target.Name
+
+			if name == "" {
+				name = target.TargetType
+			}
+
+			commands[name] = targets.GetCommands(target.TargetType)
+		}
+
+		for _, group := range config.Groups {
+			commands[group.Name] = groupCommands(commands, group.Targets)
+		}
+
+		macros = config.Macros
+	} else {
+		for _, targettype := range targets.TargetTypes() {
+			commands[targettype] = targets.GetCommands(targettype)
+		}
+	}
+
+	// internal targets
+	commands["bindings"] = commandsMap
+	commands["control"] = controlCommands
+
+	if _, ok := commands["vars"]; !ok {
+		commands["vars"] = targets.GetCommands("vars")
+	}
+
+	return commands, macros, nil
+}
+
+// groupCommands returns the commands supported by all the targets of a group, nil if they
+// are not known for some of the targets
+func groupCommands(commands map[string]map[string]targets.CommandDefinition, members []string) map[string]targets.CommandDefinition {
+	common := make(map[string]targets.CommandDefinition)
+
+	if len(members) == 0 {
+		return common
+	}
+
+	for _, member := range members {
+		if commands[member] == nil {
+			return nil
+		}
+	}
+
+	for name, definition := range commands[members[0]] {
+		supported := true
+
+		for _, member := range members[1:] {
+			if _, ok := commands[member][name]; !ok {
+				supported = false
+			}
+		}
+
+		if supported {
+			common[name] = definition
+		}
+	}
+	return common
+}
+
+func sortedKeys(commands map[string]targets.CommandDefinition) []string {
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// WriteCommands writes a description of all the commands and their parameters
+func WriteCommands(w io.Writer, configfile string, profile string) error {
+	commands, macros, err := getCommands(configfile, profile)
+
+	if err != nil {
+		return err
+	}
+
+	targetnames := make([]string, 0, len(commands))
+
+	for name := range commands {
+		targetnames = append(targetnames, name)
+	}
+
+	sort.Strings(targetnames)
+
+	for _, targetname := range targetnames {
+		if commands[targetname] == nil {
+			fmt.Fprintf(w, "%s.*\n    Commands declared by the target when it's initialized\n", targetname)
+			continue
+		}
+
+		for _, commandname := range sortedKeys(commands[targetname]) {
+			definition := commands[targetname][commandname]
+
+			fmt.Fprintf(w, "%s.%s\n    %s\n", targetname, commandname, definition.Description)
+
+			for _, parameter := range definition.Parameters {
+				fmt.Fprintf(w, "    - %s (%s): %s%s\n", parameter.Name, parameter.Type, parameter.Description, parameterNotes(parameter))
+			}
+		}
+	}
+
+	for _, macro := range macros {
+		fmt.Fprintf(w, "%s.%s\n    Macro\n", macroTarget, macro.Name)
+
+		for _, parameter := range macro.Parameters {
+			fmt.Fprintf(w, "    - %s\n", parameter)
+		}
+	}
+	return nil
+}
+
+func parameterNotes(parameter targets.ParameterDefinition) string {
+	var notes []string
+
+	if parameter.Optional {
+		notes = append(notes, "optional")
+	}
+
+	if parameter.Variadic {
+		notes = append(notes, "repeatable")
+	}
+
+	if len(parameter.Enum) != 0 {
+		notes = append(notes, "one of "+strings.Join(parameter.Enum, ", "))
+	}
+
+	if parameter.Min != nil {
+		notes = append(notes, fmt.Sprintf("min %v", *parameter.Min))
+	}
+
+	if parameter.Max != nil {
+		notes = append(notes, fmt.Sprintf("max %v", *parameter.Max))
+	}
+
+	if len(notes) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(notes, ", ") + "]"
+}
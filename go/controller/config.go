@@ -0,0 +1,426 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configurationFile is the content of a single configuration file, items are kept
+// as nodes to report their location when merging multiple files
+type configurationFile struct {
+	Include     []string
+	Profiles    map[string][]string
+	Keypads     []yaml.Node
+	Targets     []yaml.Node
+	Groups      []yaml.Node
+	KeyBindings []keybindingDefinitionNode
+	Macros      []yaml.Node
+}
+
+type keybindingDefinitionNode struct {
+	Name     string
+	Bindings []yaml.Node
+}
+
+// configurationLoader merges multiple files in a single configuration
+type configurationLoader struct {
+	config    keypadConfiguration
+	loaded    map[string]bool              // files already loaded (absolute path)
+	profiles  map[string][]string          // profiles, with paths relative to current dir
+	locations map[string]map[string]string // item type -> name -> file:line where it's defined
+}
+
+var substitutionRegexp = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// loadConfiguration reads a configuration file, with the files it includes and those
+// listed in the selected profile
+func loadConfiguration(configfile string, profile string) (*keypadConfiguration, error) {
+	loader := configurationLoader{
+		loaded:    make(map[string]bool),
+		profiles:  make(map[string][]string),
+		locations: make(map[string]map[string]string),
+	}
+
+	err := loader.loadFile(configfile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == "" {
+		if _, ok := loader.profiles["default"]; !ok {
+			return &loader.config, nil
+		}
+		profile = "default"
+	}
+
+	files, ok := loader.profiles[profile]
+
+	if !ok {
+		return nil, fmt.Errorf("Profile %s is not defined in configuration", profile)
+	}
+
+	err = loader.loadPatterns(files)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &loader.config, nil
+}
+
+func (loader *configurationLoader) loadPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+
+		if err != nil {
+			return fmt.Errorf("Invalid file pattern %s: %v", pattern, err)
+		}
+
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("Included file %s does not exist", pattern)
+		}
+
+		for _, file := range files {
+			err = loader.loadFile(file)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relativeTo converts a path relative to a configuration file in a path relative to current dir
+func relativeTo(configfile string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configfile), path)
+}
+
+func (loader *configurationLoader) loadFile(configfile string) error {
+	abspath, err := filepath.Abs(configfile)
+
+	if err != nil {
+		return err
+	}
+
+	// the same file may be included by multiple files
+	if loader.loaded[abspath] {
+		return nil
+	}
+
+	loader.loaded[abspath] = true
+
+	data, err := ioutil.ReadFile(configfile)
+
+	if err != nil {
+		return fmt.Errorf("Error %v reading configuration from %s", err, configfile)
+	}
+
+	root, err := parseConfigurationFile(configfile, data)
+
+	if err != nil {
+		return fmt.Errorf("Error %v parsing configuration from %s", err, configfile)
+	}
+
+	err = substituteValues(root, configfile)
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", configfile, err)
+	}
+
+	var file configurationFile
+
+	err = root.Decode(&file)
+
+	if err != nil {
+		return fmt.Errorf("Error %v parsing configuration from %s", err, configfile)
+	}
+
+	err = loader.merge(configfile, &file)
+
+	if err != nil {
+		return err
+	}
+
+	for name, patterns := range file.Profiles {
+		if _, ok := loader.profiles[name]; ok {
+			return fmt.Errorf("Profile %s defined in %s is already defined", name, configfile)
+		}
+
+		for _, pattern := range patterns {
+			loader.profiles[name] = append(loader.profiles[name], relativeTo(configfile, pattern))
+		}
+	}
+
+	includes := make([]string, len(file.Include))
+
+	for index, pattern := range file.Include {
+		includes[index] = relativeTo(configfile, pattern)
+	}
+
+	return loader.loadPatterns(includes)
+}
+
+// parseConfigurationFile converts a configuration file to a yaml tree, format is
+// detected from file extension (JSON is parsed as YAML, since YAML is a superset of JSON)
+func parseConfigurationFile(configfile string, data []byte) (*yaml.Node, error) {
+	var root yaml.Node
+
+	switch strings.ToLower(filepath.Ext(configfile)) {
+	case ".toml":
+		var values map[string]interface{}
+
+		err := toml.Unmarshal(data, &values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// TOML values are converted to yaml nodes, losing their location
+		err = root.Encode(values)
+
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err := yaml.Unmarshal(data, &root)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &root, nil
+}
+
+// define records where an item is defined and reports conflicts
+func (loader *configurationLoader) define(itemtype string, name string, location string) error {
+	if loader.locations[itemtype] == nil {
+		loader.locations[itemtype] = make(map[string]string)
+	}
+
+	if previous, ok := loader.locations[itemtype][name]; ok {
+		return fmt.Errorf("%s %s defined in %s is already defined in %s", itemtype, name, location, previous)
+	}
+
+	loader.locations[itemtype][name] = location
+	return nil
+}
+
+// location returns file:line of a node, nodes converted from TOML have no line number
+func location(configfile string, node *yaml.Node) string {
+	if node.Line == 0 {
+		return configfile
+	}
+	return fmt.Sprintf("%s:%d", configfile, node.Line)
+}
+
+func (loader *configurationLoader) merge(configfile string, file *configurationFile) error {
+	for index := range file.Keypads {
+		var item keypadItem
+
+		err := file.Keypads[index].Decode(&item)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", location(configfile, &file.Keypads[index]), err)
+		}
+
+		name := item.Name
+
+		if name == "" {
+			name = item.KeypadType
+		}
+
+		err = loader.define("Keypad", name, location(configfile, &file.Keypads[index]))
+
+		if err != nil {
+			return err
+		}
+
+		loader.config.Keypads = append(loader.config.Keypads, item)
+	}
+
+	for index := range file.Targets {
+		var item commandtargetItem
+
+		err := file.Targets[index].Decode(&item)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", location(configfile, &file.Targets[index]), err)
+		}
+
+		name := item.Name
+
+		if name == "" {
+			name = item.TargetType
+		}
+
+		err = loader.define("Target", name, location(configfile, &file.Targets[index]))
+
+		if err != nil {
+			return err
+		}
+
+		loader.config.Targets = append(loader.config.Targets, item)
+	}
+
+	// groups share names with targets, since they are used in the same way
+	for index := range file.Groups {
+		var item targetGroupItem
+
+		err := file.Groups[index].Decode(&item)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", location(configfile, &file.Groups[index]), err)
+		}
+
+		err = loader.define("Target", item.Name, location(configfile, &file.Groups[index]))
+
+		if err != nil {
+			return err
+		}
+
+		loader.config.Groups = append(loader.config.Groups, item)
+	}
+
+	for index := range file.Macros {
+		var item macroItem
+
+		err := file.Macros[index].Decode(&item)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", location(configfile, &file.Macros[index]), err)
+		}
+
+		err = loader.define("Macro", item.Name, location(configfile, &file.Macros[index]))
+
+		if err != nil {
+			return err
+		}
+
+		loader.config.Macros = append(loader.config.Macros, item)
+	}
+
+	// sets of bindings with the same name are merged, the same key can't be bound twice
+	for _, definition := range file.KeyBindings {
+		name := definition.Name
+
+		if name == "" {
+			name = "default"
+		}
+
+		var target *keybindingDefinition
+
+		for index := range loader.config.KeyBindings {
+			if loader.config.KeyBindings[index].Name == name {
+				target = &loader.config.KeyBindings[index]
+			}
+		}
+
+		if target == nil {
+			loader.config.KeyBindings = append(loader.config.KeyBindings, keybindingDefinition{Name: name})
+			target = &loader.config.KeyBindings[len(loader.config.KeyBindings)-1]
+		}
+
+		for index := range definition.Bindings {
+			var item keybindingItem
+
+			err := definition.Bindings[index].Decode(&item)
+
+			if err != nil {
+				return fmt.Errorf("%s: %v", location(configfile, &definition.Bindings[index]), err)
+			}
+
+			for _, key := range item.Keys {
+				err = loader.define("Key", name+"/"+key, location(configfile, &definition.Bindings[index]))
+
+				if err != nil {
+					return err
+				}
+			}
+
+			target.Bindings = append(target.Bindings, item)
+		}
+	}
+
+	return nil
+}
+
+// substituteValues replaces ${NAME} with the value of an environment variable and
+// ${file:path} with the content of a file (relative to the configuration file) in all the
+// scalar values, $${ is replaced by ${
+func substituteValues(node *yaml.Node, configfile string) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+
+		var err error
+
+		node.Value = substitutionRegexp.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+
+			reference := match[2 : len(match)-1]
+
+			if strings.HasPrefix(reference, "file:") {
+				data, readerr := ioutil.ReadFile(relativeTo(configfile, strings.TrimPrefix(reference, "file:")))
+
+				if readerr != nil {
+					err = fmt.Errorf("%s%v", lineOf(node), readerr)
+					return match
+				}
+				return strings.TrimRight(string(data), "\r\n")
+			}
+
+			value, ok := os.LookupEnv(reference)
+
+			if !ok {
+				err = fmt.Errorf("%senvironment variable %s is not defined", lineOf(node), reference)
+				return match
+			}
+			return value
+		})
+
+		// type of plain values is resolved again from the new value (ex: a port number),
+		// quoted and tagged values keep their type (ex: "${PIN}" is a string even if it's 0123)
+		if node.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+			node.Style = 0
+		}
+		return err
+	}
+
+	for index, child := range node.Content {
+		// keys of mappings are not changed
+		if node.Kind == yaml.MappingNode && index%2 == 0 {
+			continue
+		}
+
+		err := substituteValues(child, configfile)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineOf returns the line of a node as an error prefix, or nothing for nodes converted from TOML
+func lineOf(node *yaml.Node) string {
+	if node.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("line %d: ", node.Line)
+}
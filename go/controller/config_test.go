@@ -0,0 +1,346 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes files, by path relative to a temporary directory, and returns the directory
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0600)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSubstituteValues(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.yaml": "include: [sub/targets.yaml]\n",
+		"sub/targets.yaml": `targets:
+  - targettype: test
+    config:
+      pin: "${TEST_PIN}"
+      quoted: '${TEST_FLAG}'
+      port: ${TEST_PORT}
+      flag: ${TEST_FLAG}
+      tagged: !!str ${TEST_PORT}
+      password: ${file:secret.txt}
+      text: "$${NAME}"
+`,
+		"sub/secret.txt": "mysecret\n",
+	})
+
+	os.Setenv("TEST_PIN", "0123")
+	os.Setenv("TEST_PORT", "4455")
+	os.Setenv("TEST_FLAG", "true")
+
+	config, err := loadConfiguration(filepath.Join(dir, "main.yaml"), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"pin":      "0123",
+		"quoted":   "true",
+		"port":     4455,
+		"flag":     true,
+		"tagged":   "4455",
+		"password": "mysecret",
+		"text":     "${NAME}",
+	}
+
+	if len(config.Targets) != 1 || !reflect.DeepEqual(config.Targets[0].Config, expected) {
+		t.Errorf("Configuration is %#v, expected %#v", config.Targets, expected)
+	}
+}
+
+// targetNames returns the sorted names of the targets of a configuration
+func targetNames(config *keypadConfiguration) []string {
+	var names []string
+
+	for _, target := range config.Targets {
+		names = append(names, target.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func TestIncludes(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		// main includes left and right, that both include shared and main again
+		"main.yaml":       "include: [left.yaml, sub/right.yaml, \"extra/*.yaml\"]\ntargets:\n  - {targettype: vars, name: main}\n",
+		"left.yaml":       "include: [sub/shared.yaml, main.yaml]\ntargets:\n  - {targettype: vars, name: left}\n",
+		"sub/right.yaml":  "include: [shared.yaml, ../main.yaml]\ntargets:\n  - {targettype: vars, name: right}\n",
+		"sub/shared.yaml": "targets:\n  - {targettype: vars, name: shared}\n",
+		"missing.yaml":    "include: [left.yaml, none.yaml]\n",
+	})
+
+	config, err := loadConfiguration(filepath.Join(dir, "main.yaml"), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := targetNames(config); !reflect.DeepEqual(names, []string{"left", "main", "right", "shared"}) {
+		t.Errorf("Targets loaded are %v", names)
+	}
+
+	_, err = loadConfiguration(filepath.Join(dir, "missing.yaml"), "")
+
+	if err == nil || !strings.Contains(err.Error(), "Included file "+filepath.Join(dir, "none.yaml")+" does not exist") {
+		t.Errorf("Missing include returned %v", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	files := map[string]string{
+		"main.yaml":     "targets:\n  - {targettype: vars, name: main}\nprofiles:\n  studio: [studio/*.yaml]\n  empty: [\"none/*.yaml\"]\n",
+		"default.yaml":  "include: [main.yaml]\nprofiles:\n  default: [home.yaml]\n",
+		"home.yaml":     "targets:\n  - {targettype: vars, name: home}\n",
+		"studio/a.yaml": "targets:\n  - {targettype: vars, name: a}\n",
+		"studio/b.yaml": "targets:\n  - {targettype: vars, name: b}\n",
+		"twice.yaml":    "include: [main.yaml, again.yaml]\n",
+		"again.yaml":    "profiles:\n  studio: [home.yaml]\n",
+	}
+
+	dir := writeConfigFiles(t, files)
+
+	tests := []struct {
+		file     string
+		profile  string
+		expected []string
+		error    string
+	}{
+		{"main.yaml", "", []string{"main"}, ""},
+		{"main.yaml", "studio", []string{"a", "b", "main"}, ""},
+		{"main.yaml", "empty", []string{"main"}, ""},
+		{"main.yaml", "default", nil, "Profile default is not defined"},
+		{"main.yaml", "live", nil, "Profile live is not defined"},
+		{"default.yaml", "", []string{"home", "main"}, ""},
+		{"default.yaml", "studio", []string{"a", "b", "main"}, ""},
+		{"twice.yaml", "", nil, "Profile studio defined in " + filepath.Join(dir, "again.yaml") + " is already defined"},
+	}
+
+	for _, test := range tests {
+		config, err := loadConfiguration(filepath.Join(dir, test.file), test.profile)
+
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("Profile %q of %s returned error %v, expected %q", test.profile, test.file, err, test.error)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Profile %q of %s returned error %v", test.profile, test.file, err)
+			continue
+		}
+
+		if names := targetNames(config); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Profile %q of %s loaded targets %v, expected %v", test.profile, test.file, names, test.expected)
+		}
+	}
+}
+
+func TestDuplicateDefinitions(t *testing.T) {
+	tests := []struct {
+		first  string
+		second string
+		error  string // with locations of the definition in second.yaml and in first.yaml
+	}{
+		{"keypads:\n  - {keypadtype: serial}\n", "\nkeypads:\n  - {keypadtype: serial}\n", "Keypad serial defined in %s:3 is already defined in %s:3"},
+		{"targets:\n  - {targettype: vars, name: obs}\n", "\ntargets:\n  - {targettype: vars, name: obs}\n", "Target obs defined in %s:3 is already defined in %s:3"},
+		{"targets:\n  - {targettype: vars, name: obs}\n", "groups:\n  - {name: obs, targets: [a, b]}\n", "Target obs defined in %s:2 is already defined in %s:3"},
+		{"macros:\n  - {name: live, commands: []}\n", "\n\nmacros:\n  - {name: live, commands: []}\n", "Macro live defined in %s:4 is already defined in %s:3"},
+		{
+			"keybindings:\n  - bindings:\n      - {keys: [serial.1], commands: []}\n",
+			"keybindings:\n  - bindings:\n      - {keys: [serial.2], commands: []}\n      - {keys: [serial.1], commands: []}\n",
+			"Key default/serial.1 defined in %s:4 is already defined in %s:4",
+		},
+	}
+
+	for _, test := range tests {
+		dir := writeConfigFiles(t, map[string]string{
+			"first.yaml":  "include: [second.yaml]\n" + test.first,
+			"second.yaml": test.second,
+		})
+
+		_, err := loadConfiguration(filepath.Join(dir, "first.yaml"), "")
+
+		expected := fmt.Sprintf(test.error, filepath.Join(dir, "second.yaml"), filepath.Join(dir, "first.yaml"))
+
+		if err == nil || err.Error() != expected {
+			t.Errorf("Duplicate definition returned %v, expected %q", err, expected)
+		}
+	}
+}
+
+// the same configuration in the supported formats
+var testFormatsConfig = map[string]string{
+	"config.yaml": `
+keypads:
+  - keypadtype: serial
+    config:
+      port: /dev/ttyACM0
+      baudrate: 9600
+targets:
+  - targettype: obs
+    config:
+      port: 4455
+      protocol: 5
+      offline: queue
+  - targettype: vars
+    name: state
+    queue: true
+groups:
+  - name: all
+    targets: [obs, state]
+    policy: any
+macros:
+  - name: live
+    parameters: [scene]
+    commands:
+      - command: obs.activateScene
+        parameters: ["{{.Args.scene}}"]
+keybindings:
+  - name: main
+    bindings:
+      - keys: [serial.1, serial.2]
+        onerror: continue
+        commands:
+          - command: macro.live
+            parameters: [Camera]
+          - command: state.set
+            parameters: [count, 1.5]
+            when: obs.streaming
+          - parallel:
+              - command: obs.startRecording
+              - command: state.toggle
+                parameters: [recording]
+`,
+	"config.json": `{
+  "keypads": [{"keypadtype": "serial", "config": {"port": "/dev/ttyACM0", "baudrate": 9600}}],
+  "targets": [
+    {"targettype": "obs", "config": {"port": 4455, "protocol": 5, "offline": "queue"}},
+    {"targettype": "vars", "name": "state", "queue": true}
+  ],
+  "groups": [{"name": "all", "targets": ["obs", "state"], "policy": "any"}],
+  "macros": [{"name": "live", "parameters": ["scene"], "commands": [{"command": "obs.activateScene", "parameters": ["{{.Args.scene}}"]}]}],
+  "keybindings": [{"name": "main", "bindings": [{
+    "keys": ["serial.1", "serial.2"],
+    "onerror": "continue",
+    "commands": [
+      {"command": "macro.live", "parameters": ["Camera"]},
+      {"command": "state.set", "parameters": ["count", 1.5], "when": "obs.streaming"},
+      {"parallel": [{"command": "obs.startRecording"}, {"command": "state.toggle", "parameters": ["recording"]}]}
+    ]
+  }]}]
+}`,
+	"config.toml": `
+[[keypads]]
+keypadtype = "serial"
+config = { port = "/dev/ttyACM0", baudrate = 9600 }
+
+[[targets]]
+targettype = "obs"
+config = { port = 4455, protocol = 5, offline = "queue" }
+
+[[targets]]
+targettype = "vars"
+name = "state"
+queue = true
+
+[[groups]]
+name = "all"
+targets = ["obs", "state"]
+policy = "any"
+
+[[macros]]
+name = "live"
+parameters = ["scene"]
+commands = [{ command = "obs.activateScene", parameters = ["{{.Args.scene}}"] }]
+
+[[keybindings]]
+name = "main"
+
+[[keybindings.bindings]]
+keys = ["serial.1", "serial.2"]
+onerror = "continue"
+commands = [
+  { command = "macro.live", parameters = ["Camera"] },
+  { command = "state.set", parameters = ["count", 1.5], when = "obs.streaming" },
+  { parallel = [{ command = "obs.startRecording" }, { command = "state.toggle", parameters = ["recording"] }] },
+]
+`,
+}
+
+func TestConfigurationFormats(t *testing.T) {
+	dir := writeConfigFiles(t, testFormatsConfig)
+
+	expected, err := loadConfiguration(filepath.Join(dir, "config.yaml"), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expected.KeyBindings) != 1 || len(expected.KeyBindings[0].Bindings) != 1 || len(expected.KeyBindings[0].Bindings[0].Commands) != 3 {
+		t.Fatalf("YAML configuration not loaded correctly: %#v", expected)
+	}
+
+	for _, name := range []string{"config.json", "config.toml"} {
+		config, err := loadConfiguration(filepath.Join(dir, name), "")
+
+		if err != nil {
+			t.Errorf("Error loading %s: %v", name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(config, expected) {
+			t.Errorf("Configuration loaded from %s is\n%#v\ninstead of\n%#v", name, config, expected)
+		}
+	}
+}
+
+func TestTOMLLocations(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.toml":  "include = [\"other.toml\"]\n[[targets]]\ntargettype = \"vars\"\n",
+		"other.toml": "[[targets]]\ntargettype = \"vars\"\n",
+		"env.toml":   "[[targets]]\ntargettype = \"vars\"\nconfig = { file = \"${TEST_UNDEFINED_VARIABLE}\" }\n",
+	})
+
+	_, err := loadConfiguration(filepath.Join(dir, "main.toml"), "")
+
+	expected := "Target vars defined in " + filepath.Join(dir, "other.toml") + " is already defined in " + filepath.Join(dir, "main.toml")
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Duplicate definition in TOML returned %v, expected %q", err, expected)
+	}
+
+	_, err = loadConfiguration(filepath.Join(dir, "env.toml"), "")
+
+	expected = filepath.Join(dir, "env.toml") + ": environment variable TEST_UNDEFINED_VARIABLE is not defined"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("Undefined variable in TOML returned %v, expected %q", err, expected)
+	}
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"fmt"
+	"keypad/targets"
+	"sync"
+	"time"
+)
+
+// controlTarget provides commands that control execution of a sequence of commands
+type controlTarget struct {
+	controller  *keypadsControllerData
+	commandsMap *targets.Map
+	conditions  map[string]*expression // parsed conditions used by waitFor
+	mutex       sync.Mutex
+}
+
+const waitForPollInterval = 50 * time.Millisecond
+const waitForDefaultTimeout = 10 * time.Second
+
+var controlCommands = map[string]targets.CommandDefinition{
+	"sleep": {
+		ExecuteFunc: sleepExec,
+		Description: "Waits for the specified time",
+		Parameters: []targets.ParameterDefinition{
+			{Name: "duration", Type: "duration", Description: "Time to wait (ex: 500ms) or number of milliseconds"}}},
+	"waitFor": {
+		CheckFunc:   waitForCheck,
+		ExecuteFunc: waitForExec,
+		Description: "Waits until a condition becomes true",
+		Parameters: []targets.ParameterDefinition{
+			{Name: "condition", Type: "string", Description: "Condition"},
+			{Name: "timeout", Type: "duration", Description: "Maximum waiting time (default is 10s)", Optional: true}}},
+}
+
+func newControlTarget(controller *keypadsControllerData) *controlTarget {
+	control := new(controlTarget)
+	control.controller = controller
+	control.conditions = make(map[string]*expression)
+	control.commandsMap = new(targets.Map)
+	control.commandsMap.Init(control, controlCommands)
+	return control
+}
+
+func sleepExec(target interface{}, parameters []interface{}) error {
+	control := target.(*controlTarget)
+	duration, _ := targets.ParseDuration(parameters[0])
+
+	control.controller.waitOutsideTurn(func() { time.Sleep(duration) })
+	return nil
+}
+
+func waitForCheck(target interface{}, parameters []interface{}) error {
+	control := target.(*controlTarget)
+
+	_, err := control.getCondition(parameters[0].(string))
+	return err
+}
+
+func waitForExec(target interface{}, parameters []interface{}) error {
+	control := target.(*controlTarget)
+
+	condition, err := control.getCondition(parameters[0].(string))
+
+	if err != nil {
+		return err
+	}
+
+	timeout := waitForDefaultTimeout
+
+	if len(parameters) == 2 {
+		timeout, _ = targets.ParseDuration(parameters[1])
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		result, err := condition.evaluate(control.controller.targets)
+
+		if err != nil {
+			return err
+		}
+
+		if result {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for %s", condition.text)
+		}
+
+		control.controller.waitOutsideTurn(func() { time.Sleep(waitForPollInterval) })
+	}
+}
+
+// getCondition returns a parsed condition, conditions parsed for the first time while loading
+// configuration are added to the list of those that must be type-checked by the controller,
+// those parsed later (ex: from templates) are type-checked immediately
+func (control *controlTarget) getCondition(text string) (*expression, error) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if condition, ok := control.conditions[text]; ok {
+		return condition, nil
+	}
+
+	condition, err := parseExpression(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if control.controller.loaded {
+		err = condition.check(&typeChecker{targets: control.controller.targets})
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		control.controller.conditions = append(control.controller.conditions, condition)
+	}
+
+	control.conditions[text] = condition
+	return condition, nil
+}
+
+func (control *controlTarget) Init(configyaml []byte) error {
+	// Init does not need to be implemented
+	return nil
+}
+
+func (control *controlTarget) CheckCommand(command string, parameters []interface{}) error {
+	return control.commandsMap.CheckCommand(command, parameters)
+}
+
+func (control *controlTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return control.commandsMap.ExecuteCommand(command, parameters)
+}
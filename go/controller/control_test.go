@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"keypad/targets"
+	"testing"
+)
+
+// TestRuntimeConditionsCheck verifies that conditions parsed after loading configuration
+// (ex: expanded from templates) are type-checked before being used
+func TestRuntimeConditionsCheck(t *testing.T) {
+	vars, err := targets.CreateCommand("vars")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars.Init(nil)
+
+	kc := keypadsControllerData{targets: map[string]targets.CommandTarget{"vars": vars}}
+	control := newControlTarget(&kc)
+
+	// while loading, conditions are checked at the end
+	err = control.CheckCommand("waitFor", []interface{}{"missing.value == 1"})
+
+	if err != nil || len(kc.conditions) != 1 {
+		t.Errorf("Condition not deferred while loading: %v", err)
+	}
+
+	kc.loaded = true
+
+	for _, condition := range []string{"other.value == 1", "1 && vars.x", "'a' == 1"} {
+		err = control.CheckCommand("waitFor", []interface{}{condition})
+
+		if err == nil {
+			t.Errorf("Invalid condition %q accepted", condition)
+		}
+
+		if _, ok := control.conditions[condition]; ok {
+			t.Errorf("Invalid condition %q cached", condition)
+		}
+	}
+
+	err = control.CheckCommand("waitFor", []interface{}{"1 < 2"})
+
+	if err != nil {
+		t.Errorf("Valid condition not accepted: %v", err)
+	}
+
+	if len(kc.conditions) != 1 {
+		t.Errorf("Conditions parsed after loading added to load-time checks")
+	}
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"log"
+)
+
+// Key events are processed by a single dispatcher loop (see StartProcessing).
+// Commands bound to a key are executed by a job running in its own goroutine, but only
+// the job that owns the "turn" assigned by the dispatcher is allowed to run. This guarantees that:
+// - key events are processed in the order they are received, bindings for a key are
+//   resolved when all the commands triggered by previous keys have been executed or are waiting
+// - commands of a sequence are executed in order and commands changing the state of the
+//   controller (bindings, variables, groups, see inTurn) are never interleaved with commands of
+//   other sequences. The turn is released while the sequence waits (sleep, waitFor, retry
+//   delays, parallel blocks) and while other targets execute commands, so a slow or hung
+//   target never blocks other jobs. When the wait is over the job gets the turn back in FIFO order
+// - commands sent to a queued target are executed by its worker goroutine in the order
+//   they have been submitted, commands sent to other targets by different jobs may overlap
+
+const targetQueueSize = 64
+
+type queuedCommand struct {
+	command    string
+	parameters []interface{}
+	result     chan error
+}
+
+// targetQueue executes commands for a target on a dedicated goroutine
+type targetQueue struct {
+	target   targets.CommandTarget
+	commands chan queuedCommand
+}
+
+func newTargetQueue(target targets.CommandTarget) *targetQueue {
+	queue := &targetQueue{target: target, commands: make(chan queuedCommand, targetQueueSize)}
+
+	go queue.worker()
+	return queue
+}
+
+func (queue *targetQueue) worker() {
+	for cmd := range queue.commands {
+		cmd.result <- queue.target.ExecuteCommand(cmd.command, cmd.parameters)
+	}
+}
+
+// submit adds a command to the queue, result is reported on the returned channel
+func (queue *targetQueue) submit(command string, parameters []interface{}) <-chan error {
+	result := make(chan error, 1)
+
+	queue.commands <- queuedCommand{command: command, parameters: parameters, result: result}
+	return result
+}
+
+// dispatchKeypress resolves bindings for a key and starts a job that owns the turn,
+// returns false if no job has been started
+func (kc *keypadsControllerData) dispatchKeypress(event keypad.Event) bool {
+	activeBindings := kc.getActiveBindings()
+
+	sequence, ok := kc.keybindings[activeBindings][event.Source+"."+event.Key]
+
+	if !ok {
+		sequence, ok = kc.keybindings[activeBindings][event.Key]
+	}
+
+	if !ok {
+		log.Printf("Key %s.%s has no valid bindings", event.Source, event.Key)
+		return false
+	}
+
+	go kc.runJob(sequence, event)
+	return true
+}
+
+func (kc *keypadsControllerData) runJob(sequence *keybindingSequence, event keypad.Event) {
+	defer kc.releaseTurn()
+
+	err := kc.executeSequence(sequence, event)
+
+	if err != nil {
+		log.Printf("Error %v processing key bindings for %s.%s", err, event.Source, event.Key)
+		return
+	}
+
+	log.Printf("Key bindings for %s.%s correctly processed", event.Source, event.Key)
+}
+
+// acquireTurn blocks until the dispatcher assigns the turn to the caller
+func (kc *keypadsControllerData) acquireTurn() {
+	turn := make(chan struct{})
+
+	kc.turns <- turn
+	<-turn
+}
+
+// releaseTurn lets the dispatcher assign the turn to the next job
+func (kc *keypadsControllerData) releaseTurn() {
+	kc.released <- struct{}{}
+}
+
+// waitOutsideTurn releases the turn while executing a blocking function
+func (kc *keypadsControllerData) waitOutsideTurn(wait func()) {
+	kc.releaseTurn()
+	wait()
+	kc.acquireTurn()
+}
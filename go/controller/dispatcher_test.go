@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeypad reports the events sent by the tests
+type testKeypad struct {
+	name string
+}
+
+var testKeyEvents = make(chan chan<- keypad.Event, 1)
+
+func (kp *testKeypad) Init(name string, configyaml []byte) error {
+	kp.name = name
+	return nil
+}
+
+func (kp *testKeypad) Start(keypresses chan<- keypad.Event) error {
+	testKeyEvents <- keypresses
+	return nil
+}
+
+func (kp *testKeypad) Close() {}
+
+func (kp *testKeypad) GetName() string {
+	return kp.name
+}
+
+// testTarget counts the commands running at the same time, hang never returns
+type testTarget struct {
+	commandsMap *targets.Map
+}
+
+var testActive, testMaxActive int
+var testMutex sync.Mutex
+var testHang = make(chan struct{}) // never closed
+
+var testCommands = map[string]targets.CommandDefinition{
+	"work": {
+		ExecuteFunc: testWorkExec,
+		Parameters: []targets.ParameterDefinition{
+			{Name: "ms", Type: "integer"}}},
+	"hang": {
+		ExecuteFunc: func(target interface{}, parameters []interface{}) error {
+			<-testHang
+			return nil
+		}},
+}
+
+func testWorkExec(target interface{}, parameters []interface{}) error {
+	testMutex.Lock()
+	testActive++
+	if testActive > testMaxActive {
+		testMaxActive = testActive
+	}
+	testMutex.Unlock()
+
+	time.Sleep(time.Duration(targets.FloatParameter(parameters[0])) * time.Millisecond)
+
+	testMutex.Lock()
+	testActive--
+	testMutex.Unlock()
+	return nil
+}
+
+func (target *testTarget) Init(configyaml []byte) error {
+	target.commandsMap = new(targets.Map)
+	target.commandsMap.Init(target, testCommands)
+	return nil
+}
+
+func (target *testTarget) CheckCommand(command string, parameters []interface{}) error {
+	return target.commandsMap.CheckCommand(command, parameters)
+}
+
+func (target *testTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return target.commandsMap.ExecuteCommand(command, parameters)
+}
+
+func init() {
+	keypad.Register("testkeypad", func() keypad.Keypad { return new(testKeypad) })
+	targets.Register("testtarget", func() targets.CommandTarget { return new(testTarget) }, testCommands)
+}
+
+const testConfig = `
+keypads:
+  - keypadtype: testkeypad
+    name: test
+targets:
+  - targettype: testtarget
+    name: slow
+keybindings:
+  - bindings:
+      - keys: [parallel]
+        commands:
+          - parallel:
+              - command: slow.work
+                parameters: [30]
+              - command: slow.work
+                parameters: [30]
+              - command: vars.increment
+                parameters: [parallel]
+          - command: vars.increment
+            parameters: [done]
+      - keys: [hang]
+        onerror: continue
+        commands:
+          - command: slow.hang
+            timeout: 50ms
+          - command: vars.increment
+            parameters: [hung]
+      - keys: [work]
+        commands:
+          - command: slow.work
+            parameters: [10]
+          - command: vars.increment
+            parameters: [done]
+`
+
+// createTestController writes a configuration to a temporary file and creates a controller from it
+func createTestController(t *testing.T, config string) (*keypadsControllerData, error) {
+	configfile := filepath.Join(t.TempDir(), "config.yaml")
+
+	err := ioutil.WriteFile(configfile, []byte(config), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller, err := CreateAndInitController(configfile, "")
+
+	if err != nil {
+		return nil, err
+	}
+	return controller.(*keypadsControllerData), nil
+}
+
+// startTestController creates a controller from a configuration and starts processing key events
+func startTestController(t *testing.T, config string) (targets.StateProvider, chan<- keypad.Event) {
+	controller, err := createTestController(t, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go controller.StartProcessing()
+
+	testMutex.Lock()
+	testMaxActive = 0
+	testMutex.Unlock()
+
+	vars := controller.targets["vars"].(targets.StateProvider)
+	return vars, <-testKeyEvents
+}
+
+// waitForVariables waits until variables have the expected values
+func waitForVariables(t *testing.T, vars targets.StateProvider, expected map[string]string) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		state := vars.GetState()
+		completed := true
+
+		for name, value := range expected {
+			if fmt.Sprint(state[name]) != value {
+				completed = false
+			}
+		}
+
+		if completed {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Commands not completed, variables are %v, expected %v", state, expected)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestConcurrentKeys sends many key events at the same time, commands of parallel blocks and
+// of different keys must overlap and a command that never completes must not block other keys
+func TestConcurrentKeys(t *testing.T) {
+	vars, keyevents := startTestController(t, testConfig)
+
+	var wg sync.WaitGroup
+
+	for _, key := range []string{"parallel", "hang", "work"} {
+		for sender := 0; sender < 4; sender++ {
+			wg.Add(1)
+
+			go func(key string) {
+				defer wg.Done()
+
+				for count := 0; count < 10; count++ {
+					keyevents <- keypad.Event{Source: "test", Key: key}
+				}
+			}(key)
+		}
+	}
+
+	wg.Wait()
+
+	waitForVariables(t, vars, map[string]string{"parallel": "40", "done": "80", "hung": "40"})
+
+	testMutex.Lock()
+	defer testMutex.Unlock()
+
+	if testMaxActive < 2 {
+		t.Errorf("Commands have not been executed at the same time")
+	}
+}
+
+// TestHungCommand checks that a command that never returns releases the turn when it times out
+func TestHungCommand(t *testing.T) {
+	vars, keyevents := startTestController(t, testConfig)
+
+	keyevents <- keypad.Event{Source: "test", Key: "hang"}
+
+	waitForVariables(t, vars, map[string]string{"hung": "1"})
+
+	for count := 0; count < 5; count++ {
+		keyevents <- keypad.Event{Source: "test", Key: "work"}
+	}
+
+	waitForVariables(t, vars, map[string]string{"done": "5"})
+}
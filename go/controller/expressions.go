@@ -0,0 +1,746 @@
+package controller
+
+import (
+	"fmt"
+	"keypad/targets"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expressions are used to evaluate conditions on the state of the targets, ex:
+//   obs.recording && !obs.recordingPaused
+//   vars.group == 2 || obs.activeScene != "intro"
+//   "Camera" in obs.sceneSources["Live"]
+//   http.lights.body.state == "on"
+// supported operators are (by precedence): || (or), && (and), ! (not), == != < <= > >= in, [] (index)
+
+type valueType int
+
+const (
+	typeAny valueType = iota
+	typeBool
+	typeNumber
+	typeString
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeBool:
+		return "boolean"
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	}
+	return "any"
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+}
+
+type expressionNode interface {
+	// check validates the expression and returns its type
+	check(tc *typeChecker) (valueType, error)
+	// eval returns the value of the expression
+	eval(state map[string]map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+// referenceNode reads a state value, path contains the fields read from nested objects
+type referenceNode struct {
+	target string
+	key    string
+	path   []string
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+// indexNode reads an element of a list or an object
+type indexNode struct {
+	operand expressionNode
+	index   expressionNode
+}
+
+type binaryNode struct {
+	operator string
+	left     expressionNode
+	right    expressionNode
+}
+
+// expression is a parsed condition, text is kept for error reporting
+type expression struct {
+	text    string
+	root    expressionNode
+	targets []string // targets referenced by the expression
+}
+
+type typeChecker struct {
+	targets map[string]targets.CommandTarget
+}
+
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+
+			for end < len(runes) && runes[end] != c {
+				end++
+			}
+
+			if end >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string in expression %q", text)
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[i : end+1]), value: string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+
+			value, err := strconv.ParseFloat(string(runes[i:end]), 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid number %s in expression %q", string(runes[i:end]), text)
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:end]), value: value})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.' || runes[end] == '-') {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i:end])})
+			i = end
+		default:
+			operator := ""
+
+			for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]"} {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					operator = op
+					break
+				}
+			}
+
+			if operator == "" {
+				return nil, fmt.Errorf("Invalid character %q in expression %q", c, text)
+			}
+
+			tokens = append(tokens, token{kind: tokenOperator, text: operator})
+			i += len(operator)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEnd}), nil
+}
+
+type expressionParser struct {
+	text   string
+	tokens []token
+	pos    int
+	refs   map[string]bool
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() token {
+	t := p.tokens[p.pos]
+
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// isOperator checks if next token is one of the operators, keywords and/or/not
+// are accepted as alternatives to symbols
+func (p *expressionParser) isOperator(operators ...string) bool {
+	t := p.peek()
+
+	for _, op := range operators {
+		if (t.kind == tokenOperator || t.kind == tokenIdentifier) && t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *expressionParser) parseOr() (expressionNode, error) {
+	left, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("||", "or") {
+		p.next()
+
+		right, err := p.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{operator: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expressionNode, error) {
+	left, err := p.parseNot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("&&", "and") {
+		p.next()
+
+		right, err := p.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{operator: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (expressionNode, error) {
+	if p.isOperator("!", "not") {
+		p.next()
+
+		operand, err := p.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (expressionNode, error) {
+	left, err := p.parseIndex()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isOperator("==", "!=", "<", "<=", ">", ">=", "in") {
+		operator := p.next().text
+
+		right, err := p.parseIndex()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &binaryNode{operator: operator, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseIndex() (expressionNode, error) {
+	node, err := p.parsePrimary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("[") {
+		p.next()
+
+		index, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.isOperator("]") {
+			return nil, fmt.Errorf("Missing ] in expression %q", p.text)
+		}
+
+		p.next()
+		node = &indexNode{operand: node, index: index}
+	}
+	return node, nil
+}
+
+func (p *expressionParser) parsePrimary() (expressionNode, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{value: t.value}, nil
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+
+		parts := strings.Split(t.text, ".")
+		valid := len(parts) >= 2
+
+		for _, part := range parts {
+			valid = valid && part != ""
+		}
+
+		if !valid {
+			return nil, fmt.Errorf("Invalid reference %s in expression %q, use <target>.<value>", t.text, p.text)
+		}
+
+		p.refs[parts[0]] = true
+		return &referenceNode{target: parts[0], key: parts[1], path: parts[2:]}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			node, err := p.parseOr()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !p.isOperator(")") {
+				return nil, fmt.Errorf("Missing ) in expression %q", p.text)
+			}
+
+			p.next()
+			return node, nil
+		}
+	}
+
+	if t.kind == tokenEnd {
+		return nil, fmt.Errorf("Unexpected end of expression %q", p.text)
+	}
+	return nil, fmt.Errorf("Unexpected %s in expression %q", t.text, p.text)
+}
+
+// parseExpression parses a condition, types are checked separately, when all
+// the targets are initialized
+func parseExpression(text string) (*expression, error) {
+	tokens, err := tokenize(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := expressionParser{text: text, tokens: tokens, refs: make(map[string]bool)}
+
+	root, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEnd {
+		return nil, fmt.Errorf("Unexpected %s in expression %q", p.peek().text, text)
+	}
+
+	e := &expression{text: text, root: root}
+
+	for target := range p.refs {
+		e.targets = append(e.targets, target)
+	}
+	return e, nil
+}
+
+// check verifies that expression references valid state values and returns a boolean
+func (e *expression) check(tc *typeChecker) error {
+	t, err := e.root.check(tc)
+
+	if err != nil {
+		return fmt.Errorf("%v in expression %q", err, e.text)
+	}
+
+	if t != typeBool && t != typeAny {
+		return fmt.Errorf("Expression %q is not a condition (returns %v)", e.text, t)
+	}
+	return nil
+}
+
+// evaluate checks the condition against current state of the targets
+func (e *expression) evaluate(targetsmap map[string]targets.CommandTarget) (bool, error) {
+	state := make(map[string]map[string]interface{}, len(e.targets))
+
+	for _, name := range e.targets {
+		if provider, ok := targetsmap[name].(targets.StateProvider); ok {
+			state[name] = provider.GetState()
+		}
+	}
+
+	value, err := e.root.eval(state)
+
+	if err != nil {
+		return false, fmt.Errorf("%v evaluating expression %q", err, e.text)
+	}
+
+	result, ok := value.(bool)
+
+	if !ok && value != nil {
+		return false, fmt.Errorf("Expression %q did not return a boolean value", e.text)
+	}
+	return result, nil
+}
+
+func typeOf(value interface{}) valueType {
+	switch value.(type) {
+	case bool:
+		return typeBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return typeNumber
+	case string:
+		return typeString
+	}
+	return typeAny
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (n *literalNode) check(tc *typeChecker) (valueType, error) {
+	return typeOf(n.value), nil
+}
+
+func (n *literalNode) eval(state map[string]map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *referenceNode) check(tc *typeChecker) (valueType, error) {
+	t, err := n.checkValue(tc)
+
+	if err != nil || len(n.path) == 0 {
+		return t, err
+	}
+
+	// fields of objects are known only when the expression is evaluated
+	if t != typeAny {
+		return typeAny, fmt.Errorf("State value %s.%s is a %v, it has no field %s", n.target, n.key, t, n.path[0])
+	}
+	return typeAny, nil
+}
+
+// checkValue returns the type of the state value
+func (n *referenceNode) checkValue(tc *typeChecker) (valueType, error) {
+	target, ok := tc.targets[n.target]
+
+	if !ok {
+		return typeAny, fmt.Errorf("Invalid target %s", n.target)
+	}
+
+	if typesprovider, ok := target.(targets.StateTypesProvider); ok {
+		types := typesprovider.GetStateTypes()
+
+		if t, ok := types[n.key]; ok {
+			return typeOf(t), nil
+		}
+	}
+
+	provider, ok := target.(targets.StateProvider)
+
+	if !ok {
+		return typeAny, fmt.Errorf("Target %s does not provide state information", n.target)
+	}
+
+	value, ok := provider.GetState()[n.key]
+
+	if !ok {
+		return typeAny, fmt.Errorf("Invalid state value %s.%s", n.target, n.key)
+	}
+	return typeOf(value), nil
+}
+
+// eval returns nil if a field of a nested object does not exist
+func (n *referenceNode) eval(state map[string]map[string]interface{}) (interface{}, error) {
+	value := state[n.target][n.key]
+
+	for _, field := range n.path {
+		object, ok := value.(map[string]interface{})
+
+		if !ok {
+			return nil, nil
+		}
+
+		value = object[field]
+	}
+	return value, nil
+}
+
+func (n *notNode) check(tc *typeChecker) (valueType, error) {
+	t, err := n.operand.check(tc)
+
+	if err != nil {
+		return typeAny, err
+	}
+
+	if t != typeBool && t != typeAny {
+		return typeAny, fmt.Errorf("Operator ! can't be applied to %v", t)
+	}
+	return typeBool, nil
+}
+
+func (n *notNode) eval(state map[string]map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(state)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, _ := value.(bool)
+	return !b, nil
+}
+
+func (n *indexNode) check(tc *typeChecker) (valueType, error) {
+	t, err := n.operand.check(tc)
+
+	if err != nil {
+		return typeAny, err
+	}
+
+	if t != typeAny {
+		return typeAny, fmt.Errorf("Operator [] can't be applied to %v", t)
+	}
+
+	_, err = n.index.check(tc)
+
+	// type of elements is known only when the expression is evaluated
+	return typeAny, err
+}
+
+// eval returns nil if the element does not exist
+func (n *indexNode) eval(state map[string]map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(state)
+
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := n.index.eval(state)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		return object[fmt.Sprint(index)], nil
+	}
+
+	position, ok := toFloat(index)
+	list := toList(value)
+
+	if !ok || position < 0 || int(position) >= len(list) {
+		return nil, nil
+	}
+	return list[int(position)], nil
+}
+
+// toList converts lists of any type, it returns nil if value is not a list
+func toList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		list := make([]interface{}, len(v))
+
+		for index, item := range v {
+			list[index] = item
+		}
+		return list
+	}
+	return nil
+}
+
+// contains checks if a list contains an item or an object has a key
+func contains(collection interface{}, item interface{}) bool {
+	if object, ok := collection.(map[string]interface{}); ok {
+		_, ok = object[fmt.Sprint(item)]
+		return ok
+	}
+
+	for _, element := range toList(collection) {
+		if compareEqual(element, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *binaryNode) check(tc *typeChecker) (valueType, error) {
+	left, err := n.left.check(tc)
+
+	if err != nil {
+		return typeAny, err
+	}
+
+	right, err := n.right.check(tc)
+
+	if err != nil {
+		return typeAny, err
+	}
+
+	switch n.operator {
+	case "&&", "||":
+		if (left != typeBool && left != typeAny) || (right != typeBool && right != typeAny) {
+			return typeAny, fmt.Errorf("Operator %s requires boolean operands", n.operator)
+		}
+	case "==", "!=":
+		if left != right && left != typeAny && right != typeAny {
+			return typeAny, fmt.Errorf("Can't compare %v and %v", left, right)
+		}
+	case "in":
+		if right != typeAny {
+			return typeAny, fmt.Errorf("Operator in requires a list or an object, not %v", right)
+		}
+	default:
+		if left == typeBool || right == typeBool || (left != right && left != typeAny && right != typeAny) {
+			return typeAny, fmt.Errorf("Operator %s can't be applied to %v and %v", n.operator, left, right)
+		}
+	}
+	return typeBool, nil
+}
+
+func (n *binaryNode) eval(state map[string]map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(state)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || are evaluated only if required
+	switch n.operator {
+	case "&&":
+		if b, _ := left.(bool); !b {
+			return false, nil
+		}
+	case "||":
+		if b, _ := left.(bool); b {
+			return true, nil
+		}
+	}
+
+	right, err := n.right.eval(state)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.operator {
+	case "&&", "||":
+		b, _ := right.(bool)
+		return b, nil
+	case "==":
+		return compareEqual(left, right), nil
+	case "!=":
+		return !compareEqual(left, right), nil
+	case "in":
+		return contains(right, left), nil
+	}
+
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+
+	if lok && rok {
+		switch n.operator {
+		case "<":
+			return lf < rf, nil
+		case "<=":
+			return lf <= rf, nil
+		case ">":
+			return lf > rf, nil
+		case ">=":
+			return lf >= rf, nil
+		}
+	}
+
+	ls, lok := left.(string)
+	rs, rok := right.(string)
+
+	if lok && rok {
+		switch n.operator {
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		case ">=":
+			return ls >= rs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Operator %s can't be applied to %v and %v", n.operator, left, right)
+}
+
+func compareEqual(left interface{}, right interface{}) bool {
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+
+	if lok && rok {
+		return lf == rf
+	}
+	return fmt.Sprint(left) == fmt.Sprint(right)
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"keypad/targets"
+	"strings"
+	"testing"
+)
+
+// testStateTarget provides a fixed state
+type testStateTarget struct {
+	state map[string]interface{}
+}
+
+func (target *testStateTarget) Init(configyaml []byte) error {
+	return nil
+}
+
+func (target *testStateTarget) CheckCommand(command string, parameters []interface{}) error {
+	return nil
+}
+
+func (target *testStateTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return nil
+}
+
+func (target *testStateTarget) GetState() map[string]interface{} {
+	return target.state
+}
+
+var testExpressionTargets = map[string]targets.CommandTarget{
+	"t": &testStateTarget{state: map[string]interface{}{
+		"on":    true,
+		"off":   false,
+		"count": 3,
+		"name":  "intro",
+		"list":  []interface{}{"a", "b"},
+		"light": map[string]interface{}{"body": map[string]interface{}{"state": "on"}},
+		"body":  nil,
+	}},
+	"nostate": &testTarget{},
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		// && has precedence over ||, ! over &&
+		{"t.on || t.off && t.off", true},
+		{"(t.on || t.off) && t.off", false},
+		{"t.off && t.off || t.on", true},
+		{"!t.off && t.on", true},
+		{"!(t.off || t.on)", false},
+		{"not t.off and t.count > 2 or t.off", true},
+		{"t.count == 3 && t.name != 'outro'", true},
+		{"t.count <= 2.5 || t.name < \"a\"", false},
+		{"\"b\" in t.list && t.list[0] == 'a'", true},
+		{"t.list[5] == 'a'", false},
+		{"t.light.body.state == 'on'", true},
+		{"t.light.body.missing == 'on'", false},
+		{"t.light.missing.state", false},
+		{"t.body.state == 'on'", false},
+	}
+
+	tc := typeChecker{targets: testExpressionTargets}
+
+	for _, test := range tests {
+		e, err := parseExpression(test.text)
+
+		if err == nil {
+			err = e.check(&tc)
+		}
+
+		if err != nil {
+			t.Errorf("Expression %q failed: %v", test.text, err)
+			continue
+		}
+
+		result, err := e.evaluate(testExpressionTargets)
+
+		if err != nil || result != test.expected {
+			t.Errorf("Expression %q returned %v, %v instead of %v", test.text, result, err, test.expected)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		text  string
+		error string
+	}{
+		{"t.name == 'intro", "Unterminated string"},
+		{"(t.on || t.off", "Missing )"},
+		{"t.list[0", "Missing ]"},
+		{"t.on &&", "Unexpected end"},
+		{"t.on t.off", "Unexpected t.off"},
+		{"t.on # t.off", "Invalid character"},
+		{"t", "Invalid reference t"},
+		{"t..name", "Invalid reference t..name"},
+		{"t.name.", "Invalid reference t.name."},
+		{"t.name == 3", "Can't compare string and number"},
+		{"t.count < 'a'", "Operator < can't be applied to number and string"},
+		{"t.on > t.off", "Operator > can't be applied to boolean and boolean"},
+		{"t.count && t.on", "Operator && requires boolean operands"},
+		{"!t.name", "Operator ! can't be applied to string"},
+		{"t.count in t.name", "Operator in requires a list or an object"},
+		{"t.name[0] == 'i'", "Operator [] can't be applied to string"},
+		{"t.count", "is not a condition"},
+		{"x.on", "Invalid target x"},
+		{"nostate.on", "Target nostate does not provide state information"},
+		{"t.missing", "Invalid state value t.missing"},
+		{"t.name.length > 2", "State value t.name is a string, it has no field length"},
+	}
+
+	tc := typeChecker{targets: testExpressionTargets}
+
+	for _, test := range tests {
+		e, err := parseExpression(test.text)
+
+		if err == nil {
+			err = e.check(&tc)
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Expression %q returned error %v, expected %q", test.text, err, test.error)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"fmt"
+	"keypad/targets"
+	"log"
+	"strings"
+	"sync"
+)
+
+const groupPolicyAll = "all"
+const groupPolicyAny = "any"
+
+type targetGroupItem struct {
+	Name    string
+	Targets []string
+	Policy  string // all (default) or any
+}
+
+// groupTarget sends each command to all the targets in the group, at the same time
+type groupTarget struct {
+	controller *keypadsControllerData
+	names      []string
+	members    []targets.CommandTarget
+	policy     string
+}
+
+func newGroupTarget(controller *keypadsControllerData, cfg targetGroupItem) (*groupTarget, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("Group name is missing")
+	}
+
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("Group %s has no targets", cfg.Name)
+	}
+
+	group := &groupTarget{controller: controller, names: cfg.Targets, policy: strings.ToLower(cfg.Policy)}
+
+	if group.policy == "" {
+		group.policy = groupPolicyAll
+	}
+
+	if group.policy != groupPolicyAll && group.policy != groupPolicyAny {
+		return nil, fmt.Errorf("Invalid policy %s for group %s", cfg.Policy, cfg.Name)
+	}
+
+	for _, name := range cfg.Targets {
+		target, ok := controller.targets[name]
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid target %s in group %s", name, cfg.Name)
+		}
+
+		group.members = append(group.members, target)
+	}
+	return group, nil
+}
+
+func (group *groupTarget) Init(configyaml []byte) error {
+	// groups are initialized by newGroupTarget
+	return nil
+}
+
+// CheckCommand succeeds if the command is valid for all the targets, or for at least one of
+// them if the group policy is any
+func (group *groupTarget) CheckCommand(command string, parameters []interface{}) error {
+	errors := make([]error, len(group.members))
+
+	for index, target := range group.members {
+		errors[index] = target.CheckCommand(command, parameters)
+	}
+
+	return group.result(command, errors)
+}
+
+// ExecuteCommand executes the command on all the targets and waits for their completion.
+// Commands changing the state of the controller (ex: variables) are executed first, owning the
+// turn, the other targets are called at the same time, releasing the turn while waiting for them
+func (group *groupTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	errors := make([]error, len(group.members))
+	results := make(map[int]<-chan error)
+
+	for index, target := range group.members {
+		if group.controller.inTurn[target] {
+			errors[index] = target.ExecuteCommand(command, parameters)
+		} else if queue, ok := group.controller.queues[target]; ok {
+			results[index] = queue.submit(command, parameters)
+		}
+	}
+
+	group.controller.waitOutsideTurn(func() {
+		var wg sync.WaitGroup
+
+		for index, target := range group.members {
+			if _, queued := results[index]; queued || group.controller.inTurn[target] {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(index int, target targets.CommandTarget) {
+				defer wg.Done()
+
+				errors[index] = target.ExecuteCommand(command, parameters)
+			}(index, target)
+		}
+
+		for index, result := range results {
+			errors[index] = <-result
+		}
+
+		wg.Wait()
+	})
+
+	return group.result(command, errors)
+}
+
+// result aggregates errors reported by the targets, depending on group policy
+func (group *groupTarget) result(command string, errors []error) error {
+	var failed []string
+
+	for index, err := range errors {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", group.names[index], err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if group.policy == groupPolicyAny && len(failed) < len(errors) {
+		log.Printf("Command %s failed on some targets: %s", command, strings.Join(failed, "; "))
+		return nil
+	}
+	return fmt.Errorf("Command %s failed: %s", command, strings.Join(failed, "; "))
+}
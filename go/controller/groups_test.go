@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"keypad/targets"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testGroupTarget fails checks or commands, depending on its configuration
+type testGroupTarget struct {
+	FailCheck bool `yaml:"failcheck"`
+	FailRun   bool `yaml:"failrun"`
+}
+
+func (target *testGroupTarget) Init(configyaml []byte) error {
+	return yaml.Unmarshal(configyaml, target)
+}
+
+func (target *testGroupTarget) CheckCommand(command string, parameters []interface{}) error {
+	if target.FailCheck {
+		return fmt.Errorf("Invalid command %s", command)
+	}
+	return nil
+}
+
+func (target *testGroupTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	if target.FailRun {
+		return fmt.Errorf("Command %s not executed", command)
+	}
+	return nil
+}
+
+func init() {
+	targets.Register("testgrouptarget", func() targets.CommandTarget { return new(testGroupTarget) }, nil)
+}
+
+const testGroupsConfig = `
+keypads:
+  - keypadtype: testkeypad
+    name: test
+targets:
+  - targettype: testgrouptarget
+    name: good
+  - targettype: testgrouptarget
+    name: failing
+    config:
+      failrun: true
+  - targettype: testgrouptarget
+    name: invalid
+    config:
+      failcheck: true
+      failrun: true
+groups:
+  - name: all
+    targets: [good, failing]
+  - name: any
+    targets: [good, failing]
+    policy: any
+  - name: anyfailing
+    targets: [failing, good, invalid]
+    policy: Any
+  - name: allfailing
+    targets: [failing, invalid]
+    policy: all
+  - name: allinvalid
+    targets: [good, invalid]
+  - name: anyinvalid
+    targets: [invalid, good]
+    policy: any
+keybindings:
+  - bindings:
+      - keys: [run]
+        commands:
+          - command: all.run
+`
+
+func TestGroupPolicies(t *testing.T) {
+	kc, err := createTestController(t, testGroupsConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go kc.StartProcessing()
+	<-testKeyEvents
+
+	tests := []struct {
+		group   string
+		check   string // expected error of CheckCommand, empty if it succeeds
+		execute string // expected error of ExecuteCommand, empty if it succeeds
+		logged  string // failures logged by a command that succeeds
+	}{
+		{"all", "", "Command run failed: failing: Command run not executed", ""},
+		{"any", "", "", "Command run failed on some targets: failing: Command run not executed"},
+		{"anyfailing", "", "", "Command run failed on some targets: failing: Command run not executed; invalid: Command run not executed"},
+		{"allfailing", "Command run failed: invalid: Invalid command run", "Command run failed: failing: Command run not executed; invalid: Command run not executed", ""},
+		{"allinvalid", "Command run failed: invalid: Invalid command run", "Command run failed: invalid: Command run not executed", ""},
+		{"anyinvalid", "", "", "Command run failed on some targets: invalid: Invalid command run"},
+	}
+
+	for _, test := range tests {
+		group := kc.targets[test.group]
+
+		var output bytes.Buffer
+
+		log.SetOutput(&output)
+
+		checkerr := group.CheckCommand("run", nil)
+
+		kc.acquireTurn()
+		executeerr := group.ExecuteCommand("run", nil)
+		kc.releaseTurn()
+
+		log.SetOutput(os.Stderr)
+
+		for _, result := range []struct {
+			err      error
+			expected string
+		}{{checkerr, test.check}, {executeerr, test.execute}} {
+			if result.expected == "" && result.err != nil {
+				t.Errorf("Group %s failed: %v", test.group, result.err)
+			}
+
+			if result.expected != "" && (result.err == nil || result.err.Error() != result.expected) {
+				t.Errorf("Group %s returned %v instead of %q", test.group, result.err, result.expected)
+			}
+		}
+
+		if test.logged != "" && !strings.Contains(output.String(), test.logged) {
+			t.Errorf("Group %s logged %q, expected %q", test.group, output.String(), test.logged)
+		}
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	tests := []struct {
+		groups string
+		error  string
+	}{
+		{"  - targets: [good]", "Group name is missing"},
+		{"  - name: empty", "Group empty has no targets"},
+		{"  - name: some\n    targets: [good]\n    policy: most", "Invalid policy most for group some"},
+		{"  - name: missing\n    targets: [good, other]", "Invalid target other in group missing"},
+		{"  - name: good\n    targets: [good]", "Target good defined in"},
+	}
+
+	for _, test := range tests {
+		_, err := createTestController(t, `
+targets:
+  - targettype: testgrouptarget
+    name: good
+groups:
+`+test.groups)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Groups %q returned error %v, expected %q", test.groups, err, test.error)
+		}
+	}
+}
@@ -2,11 +2,12 @@ package controller
 
 import (
 	"fmt"
-	"io/ioutil"
 	keypad "keypad/keypads"
 	"keypad/targets"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,11 +15,20 @@ import (
 type keybindingCommandItem struct {
 	Command    string
 	Parameters []interface{}
+	When       string                  // optional condition, command is executed only if it's true
+	Then       []keybindingCommandItem // additional commands executed if condition is true
+	Else       []keybindingCommandItem // commands executed if condition is false
+	Parallel   []keybindingCommandItem // commands executed at the same time
+	Rollback   []keybindingCommandItem // commands that undo this one, used by rollback error policy
+	Timeout    string                  // optional maximum execution time
+	Retries    int                     // number of retries if command fails
+	RetryDelay string                  // time between retries
 }
 
 type keybindingItem struct {
 	Keys     []string
 	Commands []keybindingCommandItem
+	OnError  string // stop, continue or rollback
 }
 
 type keybindingDefinition struct {
@@ -35,12 +45,15 @@ type keypadItem struct {
 type keypadConfiguration struct {
 	Keypads     []keypadItem
 	Targets     []commandtargetItem
+	Groups      []targetGroupItem
 	KeyBindings []keybindingDefinition
+	Macros      []macroItem
 }
 
 type commandtargetItem struct {
 	Name       string
 	TargetType string
+	Queue      bool // execute commands on a dedicated goroutine
 	Config     interface{}
 }
 
@@ -48,6 +61,22 @@ type keybindingRuntimeItem struct {
 	Target     targets.CommandTarget
 	Command    string
 	Parameters []interface{}
+	Templated  bool             // parameters contain templates that must be expanded before execution
+	Macro      *keybindingMacro // macro invoked by this item, Parameters are its arguments
+	Condition  *expression      // if not nil command and Then are executed only if it's true, Else otherwise
+	Then       []keybindingRuntimeItem
+	Else       []keybindingRuntimeItem
+	Parallel   []keybindingRuntimeItem
+	Rollback   []keybindingRuntimeItem
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// keybindingSequence is the list of commands associated with a key
+type keybindingSequence struct {
+	Items   []keybindingRuntimeItem
+	OnError string
 }
 
 // KeypadsController links keypad events and commands
@@ -59,41 +88,45 @@ type KeypadsController interface {
 }
 
 type keypadsControllerData struct {
-	keypads        map[string]keypad.Keypad                      // keypads that can trigger key events
-	targets        map[string]targets.CommandTarget              // objects that can execute commands
-	keybindings    map[string]map[string][]keybindingRuntimeItem // bindings between keys and commands
-	bindingsOrder  []string                                      // used to cycle to next/prev binding
-	activeBindings string                                        // currently active bindings
-	keyevents      <-chan keypad.Event                           // channel used to receive key events
-	commandsMap    *targets.Map                                  // used to behave like a target for internal commands
+	keypads        map[string]keypad.Keypad                  // keypads that can trigger key events
+	targets        map[string]targets.CommandTarget          // objects that can execute commands
+	keybindings    map[string]map[string]*keybindingSequence // bindings between keys and commands
+	bindingsOrder  []string                                  // used to cycle to next/prev binding
+	activeBindings string                                    // currently active bindings
+	mutex          sync.RWMutex                              // protects activeBindings
+	queues         map[targets.CommandTarget]*targetQueue    // targets that execute commands on their own goroutine
+	inTurn         map[targets.CommandTarget]bool            // targets that execute commands owning the turn
+	turns          chan chan struct{}                        // jobs waiting for their turn to run
+	released       chan struct{}                             // signaled when a job releases its turn
+	keyevents      <-chan keypad.Event                       // channel used to receive key events
+	commandsMap    *targets.Map                              // used to behave like a target for internal commands
+	conditions     []*expression                             // conditions that must be type-checked after loading
+	loaded         bool                                      // configuration has been loaded and conditions checked
+	macros         map[string]*keybindingMacro               // reusable sequences of commands
+	macroStack     []string                                  // macros being compiled, used to detect recursion
 }
 
-// CreateAndInitController reads configuration file and initializes all the objects
-// inside the controller
-func CreateAndInitController(configfile string) (KeypadsController, error) {
-
-	yamlfile, err := ioutil.ReadFile(configfile)
-
-	if err != nil {
-		log.Printf("Error %v reading configuration from %s", err, configfile)
-		return nil, err
-	}
-
-	var config keypadConfiguration
+// CreateAndInitController reads configuration file (and files included by it or by the
+// selected profile) and initializes all the objects inside the controller
+func CreateAndInitController(configfile string, profile string) (KeypadsController, error) {
 
-	err = yaml.Unmarshal(yamlfile, &config)
+	config, err := loadConfiguration(configfile, profile)
 
 	if err != nil {
-		log.Printf("Error %v parsing configuration from %s", err, configfile)
+		log.Printf("Error loading configuration from %s", configfile)
 		return nil, err
 	}
 
 	controller := new(keypadsControllerData)
 	controller.keypads = make(map[string]keypad.Keypad)
 	controller.targets = make(map[string]targets.CommandTarget)
-	controller.keybindings = make(map[string]map[string][]keybindingRuntimeItem)
+	controller.keybindings = make(map[string]map[string]*keybindingSequence)
 	controller.activeBindings = ""
 	controller.commandsMap = new(targets.Map)
+	controller.queues = make(map[targets.CommandTarget]*targetQueue)
+	controller.inTurn = make(map[targets.CommandTarget]bool)
+	controller.turns = make(chan chan struct{})
+	controller.released = make(chan struct{})
 
 	controller.commandsMap.Init(controller, commandsMap)
 
@@ -147,9 +180,58 @@ func CreateAndInitController(configfile string) (KeypadsController, error) {
 		}
 
 		controller.targets[name] = target
+
+		// variables are used to resolve bindings and conditions, they must be changed in order
+		if targetcfg.TargetType == "vars" {
+			controller.inTurn[target] = true
+		}
+
+		if targetcfg.Queue {
+			controller.queues[target] = newTargetQueue(target)
+		}
+	}
+
+	for _, groupcfg := range config.Groups {
+		group, err := newGroupTarget(controller, groupcfg)
+
+		if err != nil {
+			log.Printf("Error %v initializing group %s", err, groupcfg.Name)
+			return nil, err
+		}
+
+		controller.targets[groupcfg.Name] = group
+		controller.inTurn[group] = true
 	}
 
 	controller.targets["bindings"] = controller
+	controller.targets["control"] = newControlTarget(controller)
+	controller.inTurn[controller] = true
+	controller.inTurn[controller.targets["control"]] = true
+
+	// variables are always available, even if not configured
+	if _, ok := controller.targets["vars"]; !ok {
+		vars, err := targets.CreateCommand("vars")
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = vars.Init(nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		controller.targets["vars"] = vars
+		controller.inTurn[vars] = true
+	}
+
+	err = controller.loadMacros(config.Macros)
+
+	if err != nil {
+		return nil, err
+	}
+
 	controller.bindingsOrder = make([]string, len(config.KeyBindings))
 
 	for index, keybindingdefinition := range config.KeyBindings {
@@ -159,33 +241,29 @@ func CreateAndInitController(configfile string) (KeypadsController, error) {
 			name = keybindingdefinition.Name
 		}
 
-		bindingsmap := make(map[string][]keybindingRuntimeItem)
+		bindingsmap := make(map[string]*keybindingSequence)
 
 		for _, keybinding := range keybindingdefinition.Bindings {
-			runtimecommands := make([]keybindingRuntimeItem, len(keybinding.Commands))
-
-			for index, command := range keybinding.Commands {
-				cmdparts := strings.SplitN(command.Command, ".", 2)
-
-				target := controller.targets[cmdparts[0]]
+			runtimecommands, err := controller.compileCommands(keybinding.Commands)
 
-				if target == nil {
-					return nil, fmt.Errorf("Invalid command target %s", cmdparts[0])
-				}
+			if err != nil {
+				return nil, err
+			}
 
-				err := target.CheckCommand(cmdparts[1], command.Parameters)
+			onerror := strings.ToLower(keybinding.OnError)
 
-				if err != nil {
-					return nil, err
-				}
+			if onerror == "" {
+				onerror = onErrorStop
+			}
 
-				runtimecommands[index].Target = target
-				runtimecommands[index].Command = cmdparts[1]
-				runtimecommands[index].Parameters = command.Parameters
+			if onerror != onErrorStop && onerror != onErrorContinue && onerror != onErrorRollback {
+				return nil, fmt.Errorf("Invalid error policy %s", keybinding.OnError)
 			}
 
+			sequence := &keybindingSequence{Items: runtimecommands, OnError: onerror}
+
 			for _, key := range keybinding.Keys {
-				bindingsmap[key] = runtimecommands
+				bindingsmap[key] = sequence
 			}
 		}
 
@@ -197,6 +275,20 @@ func CreateAndInitController(configfile string) (KeypadsController, error) {
 		}
 	}
 
+	// conditions are checked after all the commands have been validated, because
+	// targets may learn about their state from commands (ex: variables)
+	tc := typeChecker{targets: controller.targets}
+
+	for _, condition := range controller.conditions {
+		err = condition.check(&tc)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	controller.loaded = true
+
 	if len(controller.keypads) == 0 || len(controller.keybindings) == 0 || len(controller.targets) == 0 {
 		return nil, fmt.Errorf("You must configure at least one keypad, one target and one set of key bindings")
 	}
@@ -222,60 +314,43 @@ func (kc *keypadsControllerData) StartProcessing() error {
 		}
 	}
 
+	// dispatcher loop, see dispatcher.go for a description of ordering guarantees
 	for true {
 		select {
 		case keypress := <-kc.keyevents:
-			go kc.processKeypress(keypress.Source, keypress.Key)
+			if keypress.Time.IsZero() {
+				keypress.Time = time.Now()
+			}
+			if kc.dispatchKeypress(keypress) {
+				<-kc.released
+			}
+		case turn := <-kc.turns:
+			close(turn)
+			<-kc.released
 		}
 	}
 
 	return nil
 }
 
-func (kc *keypadsControllerData) processKeypress(source string, keypress string) {
-
-	items, ok := kc.keybindings[kc.activeBindings][source+"."+keypress]
-
-	if !ok {
-		items, ok = kc.keybindings[kc.activeBindings][keypress]
-	}
-
-	if !ok {
-		log.Printf("Key %s.%s has no valid bindings", source, keypress)
-		return
-	}
-
-	for _, item := range items {
-		err := item.Target.ExecuteCommand(item.Command, item.Parameters)
-
-		if err != nil {
-			log.Printf("Error %v processing key bindings for %s.%s", err, source, keypress)
-			return
-		}
-	}
-
-	log.Printf("Key bindings for %s.%s correctly processed", source, keypress)
-}
-
 var commandsMap = map[string]targets.CommandDefinition{
 	"activate": {
 		CheckFunc:   activateBindingsCheck,
-		ExecuteFunc: activateBindingsExec},
+		ExecuteFunc: activateBindingsExec,
+		Description: "Activates a set of key bindings",
+		Parameters: []targets.ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Name of the set of bindings"}}},
 	"next": {
-		CheckFunc:   targets.NoParmsCheck,
-		ExecuteFunc: nextBindingsExec},
+		ExecuteFunc: nextBindingsExec,
+		Description: "Activates the next set of key bindings"},
 	"previous": {
-		CheckFunc:   targets.NoParmsCheck,
-		ExecuteFunc: prevBindingsExec},
+		ExecuteFunc: prevBindingsExec,
+		Description: "Activates the previous set of key bindings"},
 }
 
 func activateBindingsCheck(target interface{}, parameters []interface{}) error {
 	kc := target.(*keypadsControllerData)
 
-	if len(parameters) != 1 {
-		return fmt.Errorf("Invalid number of parameters")
-	}
-
 	bindings := parameters[0].(string)
 
 	if _, ok := kc.keybindings[bindings]; !ok {
@@ -293,6 +368,9 @@ func activateBindingsExec(target interface{}, parameters []interface{}) error {
 
 	bindings := parameters[0].(string)
 
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+
 	kc.activateBindings(bindings)
 	return nil
 }
@@ -300,6 +378,9 @@ func activateBindingsExec(target interface{}, parameters []interface{}) error {
 func nextBindingsExec(target interface{}, parameters []interface{}) error {
 	kc := target.(*keypadsControllerData)
 
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+
 	index := kc.getBindingsPos(kc.activeBindings)
 
 	index = index + 1
@@ -315,6 +396,9 @@ func nextBindingsExec(target interface{}, parameters []interface{}) error {
 func prevBindingsExec(target interface{}, parameters []interface{}) error {
 	kc := target.(*keypadsControllerData)
 
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+
 	index := kc.getBindingsPos(kc.activeBindings)
 
 	index = index - 1
@@ -340,6 +424,12 @@ func (kc *keypadsControllerData) ExecuteCommand(command string, parameters []int
 	return kc.commandsMap.ExecuteCommand(command, parameters)
 }
 
+func (kc *keypadsControllerData) GetState() map[string]interface{} {
+	return map[string]interface{}{
+		"active": kc.getActiveBindings(),
+	}
+}
+
 func (kc *keypadsControllerData) getBindingsPos(bindings string) int {
 	for index, b := range kc.bindingsOrder {
 		if b == bindings {
@@ -349,6 +439,14 @@ func (kc *keypadsControllerData) getBindingsPos(bindings string) int {
 	return -1
 }
 
+func (kc *keypadsControllerData) getActiveBindings() string {
+	kc.mutex.RLock()
+	defer kc.mutex.RUnlock()
+
+	return kc.activeBindings
+}
+
+// activateBindings must be called with mutex locked
 func (kc *keypadsControllerData) activateBindings(bindings string) {
 	if kc.activeBindings != bindings {
 		kc.activeBindings = bindings
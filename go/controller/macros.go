@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// name used as target to invoke macros (ex: macro.goLive)
+const macroTarget = "macro"
+
+type macroItem struct {
+	Name       string
+	Parameters []string // names of the parameters, available as .Args.<name> in templates
+	Commands   []keybindingCommandItem
+}
+
+type keybindingMacro struct {
+	Name       string
+	Parameters []string
+	Items      []keybindingRuntimeItem
+	definition *macroItem
+	compiling  bool
+	compiled   bool
+}
+
+// loadMacros compiles all the macros defined in configuration, invalid or recursive
+// macros are reported even if they are not used by any binding
+func (kc *keypadsControllerData) loadMacros(macros []macroItem) error {
+	kc.macros = make(map[string]*keybindingMacro)
+
+	for index := range macros {
+		definition := &macros[index]
+
+		if definition.Name == "" {
+			return fmt.Errorf("Macro %d has no name", index)
+		}
+
+		if _, ok := kc.macros[definition.Name]; ok {
+			return fmt.Errorf("Macro %s is defined multiple times", definition.Name)
+		}
+
+		kc.macros[definition.Name] = &keybindingMacro{
+			Name:       definition.Name,
+			Parameters: definition.Parameters,
+			definition: definition,
+		}
+	}
+
+	for _, definition := range macros {
+		_, err := kc.compileMacro(definition.Name)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileMacro returns a macro, compiling it the first time it's referenced
+func (kc *keypadsControllerData) compileMacro(name string) (*keybindingMacro, error) {
+	macro, ok := kc.macros[name]
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid macro %s", name)
+	}
+
+	if macro.compiled {
+		return macro, nil
+	}
+
+	kc.macroStack = append(kc.macroStack, name)
+	defer func() { kc.macroStack = kc.macroStack[:len(kc.macroStack)-1] }()
+
+	if macro.compiling {
+		return nil, fmt.Errorf("Recursive macro call %s", strings.Join(kc.macroStack, " -> "))
+	}
+
+	macro.compiling = true
+
+	items, err := kc.compileCommands(macro.definition.Commands)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error in macro %s: %v", name, err)
+	}
+
+	macro.Items = items
+	macro.compiling = false
+	macro.compiled = true
+	return macro, nil
+}
+
+// macroArgs associates parameters passed to a macro to their names
+func macroArgs(macro *keybindingMacro, parameters []interface{}) map[string]interface{} {
+	args := make(map[string]interface{}, len(macro.Parameters))
+
+	for index, name := range macro.Parameters {
+		args[name] = parameters[index]
+	}
+	return args
+}
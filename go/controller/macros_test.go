@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	keypad "keypad/keypads"
+	"strings"
+	"testing"
+)
+
+const testMacrosConfig = `
+keypads:
+  - keypadtype: testkeypad
+    name: test
+macros:
+%s
+keybindings:
+  - bindings:
+      - keys: [macro]
+        commands:
+          - command: macro.%s
+`
+
+func TestMacroArguments(t *testing.T) {
+	vars, keyevents := startTestController(t, fmt.Sprintf(testMacrosConfig, `
+  - name: setPair
+    parameters: [name, value]
+    commands:
+      - command: vars.set
+        parameters: ["{{.Args.name}}", "{{.Args.value}}"]
+  - name: outer
+    parameters: [scene, count]
+    commands:
+      - parallel:
+          - command: macro.setPair
+            parameters: ["{{.Args.scene}}", "{{.Args.scene}}-{{.Args.count}}"]
+          - command: vars.set
+            parameters: [outer, "{{.Args.scene}}"]
+      - command: vars.set
+        parameters: [count, "{{.Args.count}}"]`, `outer
+            parameters: [intro, 2]`))
+
+	keyevents <- keypad.Event{Source: "test", Key: "macro"}
+
+	waitForVariables(t, vars, map[string]string{"intro": "intro-2", "outer": "intro", "count": "2"})
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		macros string
+		invoke string
+		error  string
+	}{
+		{`
+  - name: loop
+    commands:
+      - command: macro.loop`, "loop", "Recursive macro call loop -> loop"},
+		{`
+  - name: first
+    commands:
+      - command: macro.second
+  - name: second
+    commands:
+      - parallel:
+          - command: macro.third
+  - name: third
+    commands:
+      - command: macro.first`, "first", "Recursive macro call first -> second -> third -> first"},
+		{`
+  - name: pair
+    parameters: [name, value]
+    commands:
+      - command: vars.set
+        parameters: ["{{.Args.name}}", "{{.Args.value}}"]`, `pair
+            parameters: [one]`, "Macro pair requires 2 parameters"},
+		{`
+  - name: pair
+    parameters: [name, value]
+    commands:
+      - command: vars.set
+        parameters: ["{{.Args.name}}", "{{.Args.value}}"]`, `pair
+            parameters: [one, two, three]`, "Macro pair requires 2 parameters"},
+		{`
+  - name: empty
+    commands: []`, "missing", "Invalid macro missing"},
+		{`
+  - name: twice
+    commands: []
+  - name: twice
+    commands: []`, "twice", "Macro twice"},
+	}
+
+	for _, test := range tests {
+		_, err := createTestController(t, fmt.Sprintf(testMacrosConfig, test.macros, test.invoke))
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Macros %s returned error %v, expected %q", test.macros, err, test.error)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"encoding/json"
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"regexp"
+	"sort"
+)
+
+type jsonObject map[string]interface{}
+
+// GenerateSchema returns a JSON Schema describing the configuration file, commands
+// are listed as returned by getCommands
+func GenerateSchema(configfile string, profile string) ([]byte, error) {
+	commands, macros, err := getCommands(configfile, profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	schema := jsonObject{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "Keypad configuration",
+		"type":    "object",
+		"properties": jsonObject{
+			"include":  stringArraySchema("Files included by this one (wildcards are supported)"),
+			"profiles": jsonObject{"type": "object", "description": "Files loaded when a profile is selected", "additionalProperties": stringArraySchema("")},
+			"keypads": arraySchema(jsonObject{
+				"type":     "object",
+				"required": []string{"keypadtype"},
+				"properties": jsonObject{
+					"name":       jsonObject{"type": "string", "description": "Keypad name (default is keypadtype)"},
+					"keypadtype": jsonObject{"enum": keypad.KeypadTypes()},
+					"config":     jsonObject{"type": []string{"object", "null"}},
+				},
+				"allOf": []jsonObject{externalDriverSchema("keypadtype", nil)},
+			}),
+			"targets": arraySchema(jsonObject{
+				"type":     "object",
+				"required": []string{"targettype"},
+				"properties": jsonObject{
+					"name":       jsonObject{"type": "string", "description": "Target name (default is targettype)"},
+					"targettype": jsonObject{"enum": targets.TargetTypes()},
+					"queue":      jsonObject{"type": "boolean", "description": "Execute commands on a separate queue"},
+					"config":     jsonObject{"type": []string{"object", "null"}},
+				},
+				"allOf": []jsonObject{externalDriverSchema("targettype", jsonObject{
+					"timeout": jsonObject{"type": []string{"number", "string"}, "description": "Maximum time the driver can take to answer a request"},
+				})},
+			}),
+			"groups": arraySchema(jsonObject{
+				"type":                 "object",
+				"required":             []string{"name", "targets"},
+				"additionalProperties": false,
+				"properties": jsonObject{
+					"name":    jsonObject{"type": "string", "description": "Group name, used like a target name"},
+					"targets": stringArraySchema("Names of the targets in the group"),
+					"policy":  jsonObject{"enum": []string{groupPolicyAll, groupPolicyAny}, "description": "Command succeeds if it succeeds on all (default) or any of the targets"},
+				},
+			}),
+			"keybindings": arraySchema(jsonObject{
+				"type": "object",
+				"properties": jsonObject{
+					"name": jsonObject{"type": "string"},
+					"bindings": arraySchema(jsonObject{
+						"type":                 "object",
+						"required":             []string{"keys", "commands"},
+						"additionalProperties": false,
+						"properties": jsonObject{
+							"keys":     stringArraySchema("Keys, in <key> or <keypad>.<key> format"),
+							"onerror":  jsonObject{"enum": []string{onErrorStop, onErrorContinue, onErrorRollback}},
+							"commands": commandListSchema(),
+						},
+					}),
+				},
+			}),
+			"macros": arraySchema(jsonObject{
+				"type":                 "object",
+				"required":             []string{"name", "commands"},
+				"additionalProperties": false,
+				"properties": jsonObject{
+					"name":       jsonObject{"type": "string"},
+					"parameters": stringArraySchema("Names of the parameters"),
+					"commands":   commandListSchema(),
+				},
+			}),
+		},
+		"definitions": jsonObject{
+			"command": commandSchema(commands, macros),
+		},
+	}
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func arraySchema(items jsonObject) jsonObject {
+	return jsonObject{"type": "array", "items": items}
+}
+
+func stringArraySchema(description string) jsonObject {
+	schema := arraySchema(jsonObject{"type": "string"})
+
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+// externalDriverSchema describes the configuration of external keypads and targets, other
+// configuration values are passed to the driver
+func externalDriverSchema(typeproperty string, properties jsonObject) jsonObject {
+	config := jsonObject{
+		"command": jsonObject{"type": "string", "description": "Executable of the driver"},
+		"args":    stringArraySchema("Command line arguments"),
+	}
+
+	for name, property := range properties {
+		config[name] = property
+	}
+
+	return jsonObject{
+		"if": jsonObject{"required": []string{typeproperty}, "properties": jsonObject{typeproperty: jsonObject{"const": "external"}}},
+		"then": jsonObject{
+			"required":   []string{"config"},
+			"properties": jsonObject{"config": jsonObject{"type": "object", "required": []string{"command"}, "properties": config}},
+		},
+	}
+}
+
+func commandListSchema() jsonObject {
+	return arraySchema(jsonObject{"$ref": "#/definitions/command"})
+}
+
+func parameterSchema(parameter targets.ParameterDefinition) jsonObject {
+	schema := jsonObject{"description": parameter.Description}
+
+	// numbers and booleans can be generated by templates
+	switch parameter.Type {
+	case "number", "integer", "boolean":
+		schema["type"] = []string{parameter.Type, "string"}
+	case "duration":
+		schema["type"] = []string{"number", "string"}
+	case "any", "":
+	default:
+		schema["type"] = parameter.Type
+	}
+
+	if len(parameter.Enum) != 0 {
+		schema["anyOf"] = []jsonObject{{"enum": parameter.Enum}, {"type": "string", "pattern": "\\{\\{"}}
+	}
+
+	if parameter.Min != nil {
+		schema["minimum"] = *parameter.Min
+	}
+
+	if parameter.Max != nil {
+		schema["maximum"] = *parameter.Max
+	}
+	return schema
+}
+
+// parametersSchema describes the parameters array of a command
+func parametersSchema(parameters []targets.ParameterDefinition) jsonObject {
+	items := make([]jsonObject, 0, len(parameters))
+	required := 0
+
+	for _, parameter := range parameters {
+		items = append(items, parameterSchema(parameter))
+
+		if !parameter.Optional {
+			required++
+		}
+	}
+
+	schema := jsonObject{"type": "array", "items": items, "minItems": required}
+
+	if len(parameters) != 0 && parameters[len(parameters)-1].Variadic {
+		schema["additionalItems"] = items[len(items)-1]
+	} else {
+		schema["maxItems"] = len(parameters)
+	}
+	return schema
+}
+
+// commandCondition applies the parameters schema to a command, parameters can be omitted only
+// if none of them is required
+func commandCondition(name string, parameters jsonObject) jsonObject {
+	then := jsonObject{"properties": jsonObject{"parameters": parameters}}
+
+	if parameters["minItems"].(int) > 0 {
+		then["required"] = []string{"parameters"}
+	}
+
+	return jsonObject{
+		"if":   jsonObject{"required": []string{"command"}, "properties": jsonObject{"command": jsonObject{"const": name}}},
+		"then": then,
+	}
+}
+
+func commandSchema(commands map[string]map[string]targets.CommandDefinition, macros []macroItem) jsonObject {
+	var names []jsonObject
+	var conditions []jsonObject
+
+	targetnames := make([]string, 0, len(commands))
+
+	for name := range commands {
+		targetnames = append(targetnames, name)
+	}
+
+	sort.Strings(targetnames)
+
+	for _, targetname := range targetnames {
+		// commands of external drivers are known only when they are started
+		if commands[targetname] == nil {
+			names = append(names, jsonObject{"pattern": "^" + regexp.QuoteMeta(targetname) + "\\.", "description": "Command declared by the target"})
+			continue
+		}
+
+		for _, commandname := range sortedKeys(commands[targetname]) {
+			definition := commands[targetname][commandname]
+			name := targetname + "." + commandname
+
+			parameters := parametersSchema(definition.Parameters)
+
+			names = append(names, jsonObject{"const": name, "description": definition.Description})
+			conditions = append(conditions, commandCondition(name, parameters))
+		}
+	}
+
+	for _, macro := range macros {
+		name := macroTarget + "." + macro.Name
+		count := len(macro.Parameters)
+
+		names = append(names, jsonObject{"const": name, "description": "Macro"})
+		conditions = append(conditions, commandCondition(name, jsonObject{"type": "array", "minItems": count, "maxItems": count}))
+	}
+
+	if len(macros) == 0 {
+		names = append(names, jsonObject{"pattern": "^" + macroTarget + "\\.", "description": "Macro"})
+	}
+
+	commandref := jsonObject{"$ref": "#/definitions/command"}
+
+	return jsonObject{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": jsonObject{
+			"command":    jsonObject{"type": "string", "anyOf": names},
+			"parameters": jsonObject{"type": "array"},
+			"when":       jsonObject{"type": "string", "description": "Condition"},
+			"then":       arraySchema(commandref),
+			"else":       arraySchema(commandref),
+			"parallel":   arraySchema(commandref),
+			"rollback":   arraySchema(commandref),
+			"timeout":    jsonObject{"type": "string"},
+			"retries":    jsonObject{"type": "integer", "minimum": 0},
+			"retrydelay": jsonObject{"type": "string"},
+		},
+		"allOf": conditions,
+	}
+}
@@ -0,0 +1,350 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSchemaExternalDrivers(t *testing.T) {
+	configfile := filepath.Join(t.TempDir(), "config.yaml")
+
+	err := ioutil.WriteFile(configfile, []byte(`
+targets:
+  - name: lights
+    targettype: external
+    config:
+      command: python3
+  - targettype: vars
+groups:
+  - name: all
+    targets: [lights, vars]
+`), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := GenerateSchema(configfile, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Items struct {
+				AllOf []interface{}
+			}
+		}
+		Definitions struct {
+			Command struct {
+				Properties struct {
+					Command struct {
+						AnyOf []map[string]string
+					}
+				}
+			}
+		}
+	}
+
+	err = json.Unmarshal(data, &schema)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patterns := make(map[string]bool)
+
+	for _, name := range schema.Definitions.Command.Properties.Command.AnyOf {
+		if name["pattern"] != "" {
+			patterns[name["pattern"]] = true
+		}
+	}
+
+	for _, target := range []string{"lights", "all"} {
+		if !patterns["^"+target+`\.`] {
+			t.Errorf("Commands of target %s are not accepted by schema, patterns are %v", target, patterns)
+		}
+	}
+
+	if patterns[`^vars\.`] {
+		t.Errorf("All the commands accepted for a target with known commands")
+	}
+
+	for _, section := range []string{"keypads", "targets"} {
+		items := schema.Properties[section].Items
+
+		if len(items.AllOf) == 0 || !strings.Contains(string(mustMarshal(t, items.AllOf)), `"external"`) {
+			t.Errorf("Configuration of external %s not described by schema", section)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, value interface{}) []byte {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// schemaValidator checks values against the subset of JSON Schema used by GenerateSchema
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+func schemaType(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{"null"}
+	case bool:
+		return []string{"boolean"}
+	case float64:
+		if v == float64(int64(v)) {
+			return []string{"number", "integer"}
+		}
+		return []string{"number"}
+	case string:
+		return []string{"string"}
+	case []interface{}:
+		return []string{"array"}
+	}
+	return []string{"object"}
+}
+
+func matchesType(expected interface{}, value interface{}) bool {
+	types, ok := expected.([]interface{})
+
+	if !ok {
+		types = []interface{}{expected}
+	}
+
+	for _, t := range types {
+		for _, actual := range schemaType(value) {
+			if t == actual {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate returns the errors found in value, path is used to report them
+func (v schemaValidator) validate(schema interface{}, value interface{}, path string) []string {
+	s, ok := schema.(map[string]interface{})
+
+	if !ok {
+		if schema == false {
+			return []string{path + ": not allowed"}
+		}
+		return nil
+	}
+
+	var errors []string
+
+	fail := func(format string, args ...interface{}) {
+		errors = append(errors, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		definition := v.root["definitions"].(map[string]interface{})[strings.TrimPrefix(ref, "#/definitions/")]
+		errors = append(errors, v.validate(definition, value, path)...)
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		fail("type is not %v", t)
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+
+		for _, item := range enum {
+			found = found || reflect.DeepEqual(item, value)
+		}
+
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	if constant, ok := s["const"]; ok && !reflect.DeepEqual(constant, value) {
+		fail("%v is not %v", value, constant)
+	}
+
+	if pattern, ok := s["pattern"].(string); ok {
+		if text, ok := value.(string); ok && !regexp.MustCompile(pattern).MatchString(text) {
+			fail("%s does not match %s", text, pattern)
+		}
+	}
+
+	if number, ok := value.(float64); ok {
+		if minimum, ok := s["minimum"].(float64); ok && number < minimum {
+			fail("%v is less than %v", number, minimum)
+		}
+
+		if maximum, ok := s["maximum"].(float64); ok && number > maximum {
+			fail("%v is greater than %v", number, maximum)
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		properties, _ := s["properties"].(map[string]interface{})
+
+		for _, name := range toList(s["required"]) {
+			if _, ok := object[name.(string)]; !ok {
+				fail("%s is required", name)
+			}
+		}
+
+		for name, item := range object {
+			if property, ok := properties[name]; ok {
+				errors = append(errors, v.validate(property, item, path+"."+name)...)
+			} else if additional, ok := s["additionalProperties"]; ok {
+				errors = append(errors, v.validate(additional, item, path+"."+name)...)
+			}
+		}
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		if minimum, ok := s["minItems"].(float64); ok && float64(len(array)) < minimum {
+			fail("less than %v items", minimum)
+		}
+
+		if maximum, ok := s["maxItems"].(float64); ok && float64(len(array)) > maximum {
+			fail("more than %v items", maximum)
+		}
+
+		for index, item := range array {
+			itempath := fmt.Sprintf("%s[%d]", path, index)
+
+			if items, ok := s["items"].([]interface{}); !ok {
+				errors = append(errors, v.validate(s["items"], item, itempath)...)
+			} else if index < len(items) {
+				errors = append(errors, v.validate(items[index], item, itempath)...)
+			} else {
+				errors = append(errors, v.validate(s["additionalItems"], item, itempath)...)
+			}
+		}
+	}
+
+	for _, item := range toList(s["allOf"]) {
+		errors = append(errors, v.validate(item, value, path)...)
+	}
+
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		valid := false
+
+		for _, item := range anyOf {
+			valid = valid || len(v.validate(item, value, path)) == 0
+		}
+
+		if !valid {
+			fail("%v does not match any of the allowed schemas", value)
+		}
+	}
+
+	if condition, ok := s["if"]; ok {
+		if len(v.validate(condition, value, path)) == 0 {
+			errors = append(errors, v.validate(s["then"], value, path)...)
+		} else {
+			errors = append(errors, v.validate(s["else"], value, path)...)
+		}
+	}
+	return errors
+}
+
+// validateConfiguration generates the schema of a configuration file and validates it
+func validateConfiguration(t *testing.T, configfile string) []string {
+	data, err := GenerateSchema(configfile, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]interface{}
+
+	err = json.Unmarshal(data, &schema)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(configfile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values are converted to the types used by JSON
+	var config interface{}
+
+	err = yaml.Unmarshal(content, &config)
+
+	if err == nil {
+		err = json.Unmarshal(mustMarshal(t, config), &config)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schemaValidator{root: schema}.validate(schema, config, "config")
+}
+
+func TestSchemaValidation(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"valid.yaml": testFormatsConfig["config.yaml"],
+		"invalid.yaml": `
+targets:
+  - targettype: obs
+  - targettype: unknown
+macros:
+  - name: live
+    parameters: [scene]
+    commands: []
+keybindings:
+  - bindings:
+      - keys: [serial.1]
+        color: red
+        commands:
+          - command: obs.unknown
+          - command: obs.activateScene
+          - command: obs.setVolume
+            parameters: [Mic, 100]
+          - command: macro.live
+          - command: vars.increment
+            parameters: [count, 1, 2]
+`,
+	})
+
+	for _, configfile := range []string{filepath.Join("..", "..", "doc", "sample-config.yaml"), filepath.Join(dir, "valid.yaml")} {
+		if errors := validateConfiguration(t, configfile); len(errors) != 0 {
+			t.Errorf("Configuration %s is not valid:\n%s", configfile, strings.Join(errors, "\n"))
+		}
+	}
+
+	errors := strings.Join(validateConfiguration(t, filepath.Join(dir, "invalid.yaml")), "\n")
+
+	for _, expected := range []string{
+		"config.targets[1].targettype: unknown is not one of",
+		"config.keybindings[0].bindings[0].color: not allowed",
+		"config.keybindings[0].bindings[0].commands[0].command: obs.unknown does not match any",
+		"config.keybindings[0].bindings[0].commands[1]: parameters is required",
+		"config.keybindings[0].bindings[0].commands[2].parameters[1]: 100 is greater than",
+		"config.keybindings[0].bindings[0].commands[3]: parameters is required",
+		"config.keybindings[0].bindings[0].commands[4].parameters: more than 2 items",
+	} {
+		if !strings.Contains(errors, expected) {
+			t.Errorf("Error %q not reported, errors are:\n%s", expected, errors)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	keypad "keypad/keypads"
+	"keypad/targets"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// compileParameters replaces all the string parameters containing template actions
+// with parsed templates, returns true if at least one template has been found
+func compileParameters(parameters []interface{}) ([]interface{}, bool, error) {
+	if parameters == nil {
+		return nil, false, nil
+	}
+
+	compiled, hastemplates, err := compileValue(parameters)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return compiled.([]interface{}), hastemplates, nil
+}
+
+func compileValue(value interface{}) (interface{}, bool, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, false, nil
+		}
+
+		t, err := template.New(v).Option("missingkey=error").Parse(v)
+
+		if err != nil {
+			return nil, false, fmt.Errorf("Invalid template %q: %v", v, err)
+		}
+		return t, true, nil
+	case []interface{}:
+		compiled := make([]interface{}, len(v))
+		found := false
+
+		for index, item := range v {
+			c, hastemplates, err := compileValue(item)
+
+			if err != nil {
+				return nil, false, err
+			}
+
+			compiled[index] = c
+			found = found || hastemplates
+		}
+		return compiled, found, nil
+	case map[string]interface{}:
+		compiled := make(map[string]interface{}, len(v))
+		found := false
+
+		for key, item := range v {
+			c, hastemplates, err := compileValue(item)
+
+			if err != nil {
+				return nil, false, err
+			}
+
+			compiled[key] = c
+			found = found || hastemplates
+		}
+		return compiled, found, nil
+	}
+	return value, false, nil
+}
+
+// templatePlaceholders replaces templates inside compiled parameters with targets.Template,
+// so commands can be checked before their values are known
+func templatePlaceholders(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *template.Template:
+		return targets.Template{}
+	case []interface{}:
+		replaced := make([]interface{}, len(v))
+
+		for index, item := range v {
+			replaced[index] = templatePlaceholders(item)
+		}
+		return replaced
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			replaced[key] = templatePlaceholders(item)
+		}
+		return replaced
+	}
+	return value
+}
+
+// expandParameters executes all the templates inside parameters
+func expandParameters(parameters []interface{}, data map[string]interface{}) ([]interface{}, error) {
+	if parameters == nil {
+		return nil, nil
+	}
+
+	expanded, err := expandValue(parameters, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return expanded.([]interface{}), nil
+}
+
+func expandValue(value interface{}, data map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case *template.Template:
+		var buffer bytes.Buffer
+
+		err := v.Execute(&buffer, data)
+
+		if err != nil {
+			return nil, err
+		}
+		return buffer.String(), nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+
+		for index, item := range v {
+			e, err := expandValue(item, data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expanded[index] = e
+		}
+		return expanded, nil
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			e, err := expandValue(item, data)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expanded[key] = e
+		}
+		return expanded, nil
+	}
+	return value, nil
+}
+
+// templateData collects the values that can be referenced inside templates:
+// event information, active bindings, macro parameters and the state of each target, by name
+func (kc *keypadsControllerData) templateData(event keypad.Event, args map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{})
+
+	for name, target := range kc.targets {
+		if provider, ok := target.(targets.StateProvider); ok {
+			data[name] = provider.GetState()
+		}
+	}
+
+	data["Source"] = event.Source
+	data["Key"] = event.Key
+	data["Value"] = event.Value
+	data["Timestamp"] = event.Time
+	data["Now"] = time.Now()
+	data["Bindings"] = kc.getActiveBindings()
+	data["Args"] = args
+	return data
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	keypad "keypad/keypads"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHTTPTemplates sends a request with a templated body and stores a value of the
+// response in a variable
+func TestHTTPTemplates(t *testing.T) {
+	bodies := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"state": "on"})
+	}))
+
+	defer server.Close()
+
+	vars, keyevents := startTestController(t, fmt.Sprintf(`
+keypads:
+  - keypadtype: testkeypad
+    name: test
+targets:
+  - targettype: http
+    config:
+      endpoints:
+        lights:
+          url: %s
+keybindings:
+  - bindings:
+      - keys: [fader]
+        commands:
+          - command: http.request
+            parameters: [lights, POST, "{{.Key}}", {level: "{{.Value}}"}]
+          - command: vars.set
+            parameters: [light, "{{.http.lights.body.state}}"]
+          - command: vars.set
+            parameters: [ok, true]
+            when: http.lights["status"] == 200
+`, server.URL))
+
+	keyevents <- keypad.Event{Source: "test", Key: "fader", Value: 42}
+
+	if body := <-bodies; body != `{"level":"42"}` {
+		t.Errorf("Request body is %s", body)
+	}
+
+	waitForVariables(t, vars, map[string]string{"light": "on", "ok": "true"})
+}
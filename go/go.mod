@@ -2,11 +2,10 @@ module keypad
 
 go 1.15
 
-replace github.com/christopher-dG/go-obs-websocket => /home/valter/Work/go/go-obs-websocket
-
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/christopher-dG/go-obs-websocket v0.0.0-20200720193653-c4fed10356a5
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/kr/text v0.2.0 // indirect
 	github.com/micmonay/keybd_event v1.1.0
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
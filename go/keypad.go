@@ -4,18 +4,57 @@ import (
 	"flag"
 	"keypad/controller"
 	"log"
+	"os"
 )
 
 func main() {
 	var configname = "~/.keypad.yaml"
 
+	profile := flag.String("profile", "", "name of the configuration profile to load")
+
 	flag.Parse()
 
-	if len(flag.Args()) > 0 {
-		configname = flag.Args()[0]
+	args := flag.Args()
+
+	// keypad schema [configfile] prints the JSON schema of the configuration
+	if len(args) > 0 && args[0] == "schema" {
+		configname = ""
+
+		if len(args) > 1 {
+			configname = args[1]
+		}
+
+		schema, err := controller.GenerateSchema(configname, *profile)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		os.Stdout.Write(schema)
+		return
+	}
+
+	// keypad commands [configfile] lists the available commands
+	if len(args) > 0 && args[0] == "commands" {
+		configname = ""
+
+		if len(args) > 1 {
+			configname = args[1]
+		}
+
+		err := controller.WriteCommands(os.Stdout, configname, *profile)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 {
+		configname = args[0]
 	}
 
-	keypadcontroller, err := controller.CreateAndInitController(configname)
+	keypadcontroller, err := controller.CreateAndInitController(configname, *profile)
 
 	if err != nil {
 		log.Fatal(err)
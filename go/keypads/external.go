@@ -0,0 +1,115 @@
+package keypad
+
+import (
+	"encoding/json"
+	"fmt"
+	"keypad/rpc"
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// externalKeypad is implemented by a driver running as a separate process,
+// see rpc.Process for a description of the protocol
+type externalKeypad struct {
+	name      string
+	process   *rpc.Process
+	keyevents chan<- Event
+	mutex     sync.Mutex // protects keyevents
+}
+
+type externalKeypadConfiguration struct {
+	Command string
+	Args    []string
+}
+
+type externalKeyNotification struct {
+	Key   string
+	Value interface{}
+}
+
+func init() {
+	Register("external", func() Keypad { return new(externalKeypad) })
+}
+
+// notification handles messages sent by the driver, "key" reports a key event
+func (e *externalKeypad) notification(method string, params json.RawMessage) {
+	if method != "key" {
+		log.Printf("Unknown notification %s from keypad %s", method, e.name)
+		return
+	}
+
+	var key externalKeyNotification
+
+	err := json.Unmarshal(params, &key)
+
+	if err != nil || key.Key == "" {
+		log.Printf("Invalid key notification %s from keypad %s", string(params), e.name)
+		return
+	}
+
+	e.mutex.Lock()
+	keyevents := e.keyevents
+	e.mutex.Unlock()
+
+	// events sent before processing has started are discarded
+	if keyevents == nil {
+		return
+	}
+
+	keyevents <- Event{Source: e.name, Key: key.Key, Value: key.Value, Time: time.Now()}
+}
+
+func (e *externalKeypad) Init(name string, configyaml []byte) error {
+	var cfg externalKeypadConfiguration
+	var config map[string]interface{}
+
+	e.name = name
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err == nil {
+		err = yaml.Unmarshal(configyaml, &config)
+	}
+
+	if err != nil {
+		log.Printf("error %v parsing external keypad configuration", err)
+		return err
+	}
+
+	if cfg.Command == "" {
+		return fmt.Errorf("no command has been configured for keypad %s", name)
+	}
+
+	e.process, err = rpc.Start(cfg.Command, cfg.Args, e.notification)
+
+	if err != nil {
+		return err
+	}
+
+	err = e.process.Call("init", map[string]interface{}{"name": name, "config": config}, nil)
+
+	if err != nil {
+		e.process.Close()
+		return fmt.Errorf("Error %v initializing keypad %s", err, name)
+	}
+	return nil
+}
+
+func (e *externalKeypad) Start(keyevents chan<- Event) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.keyevents = keyevents
+	return nil
+}
+
+func (e *externalKeypad) Close() {
+	e.process.Close()
+}
+
+func (e *externalKeypad) GetName() string {
+	return e.name
+}
@@ -2,12 +2,16 @@ package keypad
 
 import (
 	"fmt"
+	"sort"
+	"time"
 )
 
 // Event is used to report a key event, key must be translated in a valid string
 type Event struct {
 	Source string
 	Key    string
+	Value  interface{} // optional value associated with the key (ex: fader position)
+	Time   time.Time   // time when the event has been received
 }
 
 // Keypad is th base interface for all the keypads
@@ -18,11 +22,40 @@ type Keypad interface {
 	GetName() string
 }
 
+// Factory creates a new, uninitialized, instance of a keypad
+type Factory func() Keypad
+
+// keypads registered by their init functions, by type
+var registry = make(map[string]Factory)
+
+// Register makes a keypad type available for configuration, it must be called from the init
+// function of the file implementing the keypad
+func Register(keypadtype string, factory Factory) {
+	if _, ok := registry[keypadtype]; ok {
+		panic("Keypad type " + keypadtype + " registered twice")
+	}
+
+	registry[keypadtype] = factory
+}
+
 // CreateKeypad creates a keypad instance based on type string
 func CreateKeypad(keypadtype string) (Keypad, error) {
-	switch keypadtype {
-	case "serial":
-		return new(serialKeypad), nil
+	factory, ok := registry[keypadtype]
+
+	if !ok {
+		return nil, fmt.Errorf("%v is not a valid keypad type", keypadtype)
+	}
+	return factory(), nil
+}
+
+// KeypadTypes returns the list of supported keypad types
+func KeypadTypes() []string {
+	types := make([]string, 0, len(registry))
+
+	for keypadtype := range registry {
+		types = append(types, keypadtype)
 	}
-	return nil, fmt.Errorf("%v is not a valid keypad type", keypadtype)
+
+	sort.Strings(types)
+	return types
 }
@@ -0,0 +1,126 @@
+package keypad
+
+import (
+	"keypad/osc"
+	"log"
+	"net"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// oscKeypad receives OSC messages over UDP, each message is reported as a key event
+type oscKeypad struct {
+	name string
+	conn net.PacketConn
+	keys map[string]string
+}
+
+type oscKeypadConfiguration struct {
+	Listen string            // address and port (ex: :8000)
+	Keys   map[string]string // key names by OSC address, other addresses are used as key names
+}
+
+func init() {
+	Register("osc", func() Keypad { return new(oscKeypad) })
+}
+
+func (o *oscKeypad) Init(name string, configyaml []byte) error {
+	o.name = name
+
+	cfg := oscKeypadConfiguration{
+		Listen: ":8000",
+	}
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err != nil {
+		log.Printf("error %v parsing osc keypad configuration", err)
+		return err
+	}
+
+	o.keys = cfg.Keys
+	o.conn, err = net.ListenPacket("udp", cfg.Listen)
+
+	if err != nil {
+		log.Printf("error %v listening on %s", err, cfg.Listen)
+		return err
+	}
+	return nil
+}
+
+// oscValue converts OSC arguments to the types used by configuration values
+func oscValue(argument interface{}) interface{} {
+	switch v := argument.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float32:
+		return float64(v)
+	}
+	return argument
+}
+
+// event converts an OSC message to a key event, value is the message argument
+// (or a list of arguments, if there are more)
+func (o *oscKeypad) event(message osc.Message) Event {
+	key, ok := o.keys[message.Address]
+
+	if !ok {
+		key = message.Address
+	}
+
+	event := Event{Source: o.name, Key: key, Time: time.Now()}
+
+	switch len(message.Arguments) {
+	case 0:
+	case 1:
+		event.Value = oscValue(message.Arguments[0])
+	default:
+		values := make([]interface{}, len(message.Arguments))
+
+		for index, argument := range message.Arguments {
+			values[index] = oscValue(argument)
+		}
+
+		event.Value = values
+	}
+	return event
+}
+
+func (o *oscKeypad) processMessages(keyevents chan<- Event) error {
+	buffer := make([]byte, 65536)
+
+	for {
+		size, _, err := o.conn.ReadFrom(buffer)
+
+		if err != nil {
+			return err
+		}
+
+		messages, err := osc.Decode(buffer[:size])
+
+		if err != nil {
+			log.Printf("Error %v decoding OSC message on keypad %s", err, o.name)
+			continue
+		}
+
+		for _, message := range messages {
+			keyevents <- o.event(message)
+		}
+	}
+}
+
+func (o *oscKeypad) Start(keyevents chan<- Event) error {
+	go o.processMessages(keyevents)
+	return nil
+}
+
+func (o *oscKeypad) Close() {
+	o.conn.Close()
+}
+
+func (o *oscKeypad) GetName() string {
+	return o.name
+}
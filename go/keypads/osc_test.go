@@ -0,0 +1,73 @@
+package keypad
+
+import (
+	"keypad/osc"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOSCKeypad(t *testing.T) {
+	o := new(oscKeypad)
+
+	err := o.Init("mixer", []byte("listen: 127.0.0.1:0\nkeys:\n  /ch/1/mute: mute\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer o.Close()
+
+	keyevents := make(chan Event, 10)
+
+	o.Start(keyevents)
+
+	conn, err := net.Dial("udp", o.conn.LocalAddr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	messages := []osc.Message{
+		{Address: "/ch/1/mute", Arguments: []interface{}{int32(1)}},
+		{Address: "/ch/1/fader", Arguments: []interface{}{float32(0.5)}},
+		{Address: "/ch/1/name", Arguments: []interface{}{"drums", int64(2), true}},
+		{Address: "/go"},
+	}
+
+	expected := []Event{
+		{Source: "mixer", Key: "mute", Value: 1},
+		{Source: "mixer", Key: "/ch/1/fader", Value: 0.5},
+		{Source: "mixer", Key: "/ch/1/name", Value: []interface{}{"drums", 2, true}},
+		{Source: "mixer", Key: "/go"},
+	}
+
+	// a malformed packet is ignored, following packets are processed
+	conn.Write([]byte("/ch/1/mute"))
+
+	for _, message := range messages {
+		packet, err := osc.Encode(message)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Write(packet)
+	}
+
+	for _, event := range expected {
+		select {
+		case received := <-keyevents:
+			received.Time = time.Time{}
+
+			if !reflect.DeepEqual(received, event) {
+				t.Errorf("Received %v instead of %v", received, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %v not received", event)
+		}
+	}
+}
@@ -1,3 +1,6 @@
+//go:build !noserial
+// +build !noserial
+
 package keypad
 
 import (
@@ -5,6 +8,7 @@ import (
 	"github.com/tarm/serial"
 	"gopkg.in/yaml.v3"
 	"log"
+	"time"
 )
 
 type serialKeypad struct {
@@ -20,6 +24,10 @@ type serialKeypadConfiguration struct {
 	Size     byte
 }
 
+func init() {
+	Register("serial", func() Keypad { return new(serialKeypad) })
+}
+
 func (s *serialKeypad) Init(name string, configyaml []byte) error {
 
 	s.name = name
@@ -68,7 +76,7 @@ func (s *serialKeypad) processKeys(keyevents chan<- Event) error {
 	var _ int
 
 	for _, err = s.Port.Read(b); err == nil; _, err = s.Port.Read(b) {
-		keyevents <- Event{Source: s.name, Key: string(b[0])}
+		keyevents <- Event{Source: s.name, Key: string(b[0]), Time: time.Now()}
 	}
 	return err
 }
@@ -0,0 +1,584 @@
+package obsws5
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Op-codes of obs-websocket protocol v5 messages
+const (
+	OpHello                = 0
+	OpIdentify             = 1
+	OpIdentified           = 2
+	OpReidentify           = 3
+	OpEvent                = 5
+	OpRequest              = 6
+	OpRequestResponse      = 7
+	OpRequestBatch         = 8
+	OpRequestBatchResponse = 9
+)
+
+// Event subscriptions, used to select the events sent by OBS
+const (
+	SubscriptionGeneral     = 1 << 0
+	SubscriptionConfig      = 1 << 1
+	SubscriptionScenes      = 1 << 2
+	SubscriptionInputs      = 1 << 3
+	SubscriptionTransitions = 1 << 4
+	SubscriptionFilters     = 1 << 5
+	SubscriptionOutputs     = 1 << 6
+	SubscriptionSceneItems  = 1 << 7
+	SubscriptionMediaInputs = 1 << 8
+	SubscriptionVendors     = 1 << 9
+	SubscriptionUI          = 1 << 10
+	SubscriptionAll         = 1<<11 - 1 // all the events, except high-volume ones
+
+	SubscriptionInputVolumeMeters = 1 << 16
+)
+
+const rpcVersion = 1
+const eventsQueueSize = 256
+
+// DefaultTimeout is the time a request waits for a response, when Client.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+// Client is a connection to obs-websocket using protocol version 5 (OBS 28 or newer)
+type Client struct {
+	Host               string
+	Port               int
+	Password           string
+	EventSubscriptions int           // events sent by OBS (default is SubscriptionAll)
+	Timeout            time.Duration // maximum time waiting for a response
+
+	conn      *websocket.Conn
+	connected bool
+	pending   map[string]chan message // requests waiting for a response, by id
+	nextid    uint64
+	handler   func(eventType string, data map[string]interface{})
+	events    chan Event
+	dialing   net.Conn           // connection being established, closed by Disconnect
+	cancel    context.CancelFunc // cancels the connection being established
+	mutex     sync.Mutex         // protects connection state, pending, nextid and handler
+	writelock sync.Mutex         // serializes writes on the connection
+}
+
+// Event is an event sent by OBS
+type Event struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// RequestError reports a request that has been processed by OBS with an error
+type RequestError struct {
+	RequestType string
+	Code        int
+	Comment     string
+}
+
+func (e *RequestError) Error() string {
+	if e.Comment == "" {
+		return fmt.Sprintf("OBS request %s failed with code %d", e.RequestType, e.Code)
+	}
+	return fmt.Sprintf("OBS request %s failed: %s", e.RequestType, e.Comment)
+}
+
+// Request is a single request of a batch
+type Request struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// Response is the result of a request of a batch, Err is nil if the request succeeded
+type Response struct {
+	Type string
+	Data map[string]interface{}
+	Err  error
+}
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type requestStatus struct {
+	Result  bool   `json:"result"`
+	Code    int    `json:"code"`
+	Comment string `json:"comment"`
+}
+
+type requestResponse struct {
+	RequestType   string                 `json:"requestType"`
+	RequestID     string                 `json:"requestId"`
+	RequestStatus requestStatus          `json:"requestStatus"`
+	ResponseData  map[string]interface{} `json:"responseData"`
+}
+
+type batchResponse struct {
+	RequestID string            `json:"requestId"`
+	Results   []requestResponse `json:"results"`
+}
+
+// SetEventHandler sets the function invoked for each event, events are delivered in order
+// by a dedicated goroutine, so the handler can send requests
+func (c *Client) SetEventHandler(handler func(eventType string, data map[string]interface{})) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.handler = handler
+}
+
+// Connected returns true if the client is connected and identified
+func (c *Client) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.connected
+}
+
+// authentication computes the authentication string from the challenge sent by OBS
+func authentication(password string, salt string, challenge string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + challenge))
+
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// Connect opens the connection and identifies the client, authenticating if OBS requires it.
+// The handshake fails if OBS does not answer within the timeout, Disconnect cancels it
+func (c *Client) Connect() error {
+	timeout := c.timeout()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.mutex.Lock()
+	c.cancel = cancel
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.dialing = nil
+		c.cancel = nil
+		c.mutex.Unlock()
+
+		cancel()
+	}()
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: timeout,
+		NetDialContext:   c.dialContext(ctx),
+	}
+
+	conn, _, err := dialer.DialContext(ctx, fmt.Sprintf("ws://%s:%d", c.Host, c.Port), nil)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("OBS connection cancelled")
+		}
+		return err
+	}
+
+	err = c.identify(conn, timeout)
+
+	if err != nil {
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("OBS connection cancelled")
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+
+	if ctx.Err() != nil {
+		c.mutex.Unlock()
+		conn.Close()
+		return fmt.Errorf("OBS connection cancelled")
+	}
+
+	c.conn = conn
+	c.connected = true
+	c.pending = make(map[string]chan message)
+	c.events = make(chan Event, eventsQueueSize)
+	c.mutex.Unlock()
+
+	go c.dispatchEvents(c.events)
+	go c.receive(conn, c.events)
+	return nil
+}
+
+// dialContext returns a dial function that records the network connection, so Disconnect can
+// close it while the websocket handshake is in progress
+func (c *Client) dialContext(ctx context.Context) func(context.Context, string, string) (net.Conn, error) {
+	return func(dialctx context.Context, network string, address string) (net.Conn, error) {
+		var dialer net.Dialer
+
+		netconn, err := dialer.DialContext(dialctx, network, address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if ctx.Err() != nil {
+			netconn.Close()
+			return nil, ctx.Err()
+		}
+
+		c.dialing = netconn
+		return netconn, nil
+	}
+}
+
+// identify reads the hello message and identifies the client, a silent OBS would block the
+// handshake forever, so reads have a deadline
+func (c *Client) identify(conn *websocket.Conn, timeout time.Duration) error {
+	var hello struct {
+		RPCVersion     int `json:"rpcVersion"`
+		Authentication *struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		} `json:"authentication"`
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+
+	err := readMessage(conn, OpHello, &hello)
+
+	if err != nil {
+		return err
+	}
+
+	identify := map[string]interface{}{
+		"rpcVersion":         rpcVersion,
+		"eventSubscriptions": c.subscriptions(),
+	}
+
+	if hello.Authentication != nil {
+		identify["authentication"] = authentication(c.Password, hello.Authentication.Salt, hello.Authentication.Challenge)
+	}
+
+	err = conn.WriteJSON(map[string]interface{}{"op": OpIdentify, "d": identify})
+
+	if err == nil {
+		err = readMessage(conn, OpIdentified, nil)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the receive goroutine waits for messages without a deadline, OBS is pinged by its users
+	conn.SetWriteDeadline(time.Time{})
+	return conn.SetReadDeadline(time.Time{})
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c *Client) subscriptions() int {
+	if c.EventSubscriptions == 0 {
+		return SubscriptionAll
+	}
+	return c.EventSubscriptions
+}
+
+// readMessage reads a message during the handshake, when it's not read by the receive goroutine
+func readMessage(conn *websocket.Conn, op int, data interface{}) error {
+	var msg message
+
+	err := conn.ReadJSON(&msg)
+
+	if err != nil {
+		// authentication failures are reported closing the connection
+		if closeerr, ok := err.(*websocket.CloseError); ok {
+			return fmt.Errorf("OBS closed connection: %s (%d)", closeerr.Text, closeerr.Code)
+		}
+
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			return fmt.Errorf("Timeout waiting for OBS handshake")
+		}
+		return err
+	}
+
+	if msg.Op != op {
+		return fmt.Errorf("Unexpected message from OBS, op-code %d instead of %d", msg.Op, op)
+	}
+
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(msg.D, data)
+}
+
+// Disconnect closes the connection, pending requests fail. A connection being established
+// is cancelled, Connect returns an error
+func (c *Client) Disconnect() {
+	c.mutex.Lock()
+	conn := c.conn
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if c.dialing != nil {
+		c.dialing.Close()
+	}
+	c.mutex.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// receive reads messages until the connection is closed
+func (c *Client) receive(conn *websocket.Conn, events chan Event) {
+	for {
+		var msg message
+
+		err := conn.ReadJSON(&msg)
+
+		if err != nil {
+			break
+		}
+
+		switch msg.Op {
+		case OpEvent:
+			var event struct {
+				EventType string                 `json:"eventType"`
+				EventData map[string]interface{} `json:"eventData"`
+			}
+
+			if json.Unmarshal(msg.D, &event) == nil {
+				events <- Event{Type: event.EventType, Data: event.EventData}
+			}
+		case OpRequestResponse, OpRequestBatchResponse:
+			var response struct {
+				RequestID string `json:"requestId"`
+			}
+
+			if json.Unmarshal(msg.D, &response) != nil {
+				continue
+			}
+
+			c.mutex.Lock()
+			result, ok := c.pending[response.RequestID]
+			delete(c.pending, response.RequestID)
+			c.mutex.Unlock()
+
+			if ok {
+				result <- msg
+			}
+		}
+	}
+
+	conn.Close()
+	close(events)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// the client could already be connected again
+	if c.conn != conn {
+		return
+	}
+
+	c.connected = false
+
+	for id, result := range c.pending {
+		close(result)
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) dispatchEvents(events chan Event) {
+	for event := range events {
+		c.mutex.Lock()
+		handler := c.handler
+		c.mutex.Unlock()
+
+		if handler != nil {
+			handler(event.Type, event.Data)
+		}
+	}
+}
+
+// send writes a message with a new request id and waits for the response, until ctx is done or,
+// if ctx has no deadline, until the client timeout expires. Name is used in errors
+func (c *Client) send(ctx context.Context, op int, name string, data map[string]interface{}) (message, error) {
+	result := make(chan message, 1)
+
+	c.mutex.Lock()
+
+	if !c.connected {
+		c.mutex.Unlock()
+		return message{}, fmt.Errorf("OBS is not connected")
+	}
+
+	c.nextid++
+	id := strconv.FormatUint(c.nextid, 10)
+	c.pending[id] = result
+	conn := c.conn
+	c.mutex.Unlock()
+
+	data["requestId"] = id
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout())
+		defer cancel()
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	// a connection that is not read by OBS would block writes forever
+	c.writelock.Lock()
+	conn.SetWriteDeadline(deadline)
+	err := conn.WriteJSON(map[string]interface{}{"op": op, "d": data})
+	c.writelock.Unlock()
+
+	if err != nil {
+		c.forget(id)
+		return message{}, err
+	}
+
+	select {
+	case msg, ok := <-result:
+		if !ok {
+			return message{}, fmt.Errorf("OBS connection closed, request %s (id %s) cancelled", name, id)
+		}
+		return msg, nil
+	case <-ctx.Done():
+		c.forget(id)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return message{}, fmt.Errorf("Timeout waiting for OBS response to request %s (id %s)", name, id)
+		}
+		return message{}, fmt.Errorf("OBS request %s (id %s) cancelled", name, id)
+	}
+}
+
+func (c *Client) forget(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pending, id)
+}
+
+func responseError(response requestResponse) error {
+	if response.RequestStatus.Result {
+		return nil
+	}
+	return &RequestError{RequestType: response.RequestType, Code: response.RequestStatus.Code, Comment: response.RequestStatus.Comment}
+}
+
+// Request sends a request and returns its response data, waiting for it at most Timeout
+func (c *Client) Request(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	return c.RequestContext(context.Background(), requestType, data)
+}
+
+// RequestContext sends a request and waits for its response until ctx is done, Timeout is
+// used if ctx has no deadline
+func (c *Client) RequestContext(ctx context.Context, requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	d := map[string]interface{}{"requestType": requestType}
+
+	if data != nil {
+		d["requestData"] = data
+	}
+
+	msg, err := c.send(ctx, OpRequest, requestType, d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response requestResponse
+
+	err = json.Unmarshal(msg.D, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = responseError(response)
+
+	if err != nil {
+		return nil, err
+	}
+	return response.ResponseData, nil
+}
+
+// RequestBatch sends multiple requests, that are executed in order by OBS. If haltOnFailure is
+// true requests following a failed one are not executed, and they are not included in results
+func (c *Client) RequestBatch(requests []Request, haltOnFailure bool) ([]Response, error) {
+	return c.RequestBatchContext(context.Background(), requests, haltOnFailure)
+}
+
+// RequestBatchContext sends multiple requests, like RequestBatch, waiting for the results until
+// ctx is done
+func (c *Client) RequestBatchContext(ctx context.Context, requests []Request, haltOnFailure bool) ([]Response, error) {
+	items := make([]map[string]interface{}, len(requests))
+
+	for index, request := range requests {
+		items[index] = map[string]interface{}{"requestType": request.Type}
+
+		if request.Data != nil {
+			items[index]["requestData"] = request.Data
+		}
+	}
+
+	msg, err := c.send(ctx, OpRequestBatch, "batch", map[string]interface{}{"haltOnFailure": haltOnFailure, "requests": items})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response batchResponse
+
+	err = json.Unmarshal(msg.D, &response)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Response, len(response.Results))
+
+	for index, result := range response.Results {
+		results[index] = Response{Type: result.RequestType, Data: result.ResponseData, Err: responseError(result)}
+	}
+	return results, nil
+}
+
+// Reidentify changes the event subscriptions of an active connection
+func (c *Client) Reidentify(subscriptions int) error {
+	c.mutex.Lock()
+	c.EventSubscriptions = subscriptions
+	conn := c.conn
+	connected := c.connected
+	c.mutex.Unlock()
+
+	if !connected {
+		return nil
+	}
+
+	c.writelock.Lock()
+	defer c.writelock.Unlock()
+
+	return conn.WriteJSON(map[string]interface{}{"op": OpReidentify, "d": map[string]interface{}{"eventSubscriptions": subscriptions}})
+}
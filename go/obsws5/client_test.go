@@ -0,0 +1,503 @@
+package obsws5
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testSalt = "lM1GncleQOaCu9lT1yeUZhFYnqhsLLP1G5lAGo3ixaI="
+const testChallenge = "+IxH4CnCiqpX1rM9scsNynZzbOe4KhDeYcTNS3PDaeY="
+
+// startFakeServer starts a websocket server that invokes serve for each connection, it returns
+// a client configured to connect to it
+func startFakeServer(t *testing.T, serve func(conn *websocket.Conn)) *Client {
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		serve(conn)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return newTestClient(server.Listener.Addr())
+}
+
+func newTestClient(address net.Addr) *Client {
+	host, port, _ := net.SplitHostPort(address.String())
+	portnumber, _ := strconv.Atoi(port)
+
+	return &Client{Host: host, Port: portnumber, Timeout: time.Second}
+}
+
+// startFakeOBS starts a server that performs the handshake, requiring authentication if password
+// is not empty, then invokes serve
+func startFakeOBS(t *testing.T, password string, serve func(conn *websocket.Conn)) *Client {
+	client := startFakeServer(t, func(conn *websocket.Conn) {
+		if fakeHandshake(t, conn, password) && serve != nil {
+			serve(conn)
+		}
+	})
+
+	client.Password = password
+	return client
+}
+
+// fakeStall reads messages without answering, until the connection is closed
+func fakeStall(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func fakeHandshake(t *testing.T, conn *websocket.Conn, password string) bool {
+	hello := map[string]interface{}{"obsWebSocketVersion": "5.0.0", "rpcVersion": rpcVersion}
+
+	if password != "" {
+		hello["authentication"] = map[string]interface{}{"challenge": testChallenge, "salt": testSalt}
+	}
+
+	conn.WriteJSON(map[string]interface{}{"op": OpHello, "d": hello})
+
+	var identify struct {
+		RPCVersion         int    `json:"rpcVersion"`
+		Authentication     string `json:"authentication"`
+		EventSubscriptions int    `json:"eventSubscriptions"`
+	}
+
+	if readMessage(conn, OpIdentify, &identify) != nil {
+		return false
+	}
+
+	if identify.RPCVersion != rpcVersion {
+		t.Errorf("Identify has RPC version %d", identify.RPCVersion)
+	}
+
+	if password != "" && identify.Authentication != authentication(password, testSalt, testChallenge) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4009, "Authentication failed."))
+		return false
+	}
+
+	conn.WriteJSON(map[string]interface{}{"op": OpIdentified, "d": map[string]interface{}{"negotiatedRpcVersion": rpcVersion}})
+	return true
+}
+
+// fakeResponse answers GetVersion with data and any other request with an error
+func fakeResponse(request map[string]interface{}) map[string]interface{} {
+	response := map[string]interface{}{"requestType": request["requestType"], "requestId": request["requestId"]}
+
+	if request["requestType"] == "GetVersion" {
+		response["requestStatus"] = map[string]interface{}{"result": true, "code": 100}
+		response["responseData"] = map[string]interface{}{"obsVersion": "30.0.0"}
+	} else {
+		response["requestStatus"] = map[string]interface{}{"result": false, "code": 204, "comment": "Unknown request"}
+	}
+	return response
+}
+
+// fakeServe answers requests and batches until the connection is closed
+func fakeServe(conn *websocket.Conn) {
+	for {
+		var msg message
+
+		if conn.ReadJSON(&msg) != nil {
+			return
+		}
+
+		var request map[string]interface{}
+
+		json.Unmarshal(msg.D, &request)
+
+		switch msg.Op {
+		case OpRequest:
+			conn.WriteJSON(map[string]interface{}{"op": OpRequestResponse, "d": fakeResponse(request)})
+		case OpRequestBatch:
+			results := []interface{}{}
+
+			for _, item := range request["requests"].([]interface{}) {
+				results = append(results, fakeResponse(item.(map[string]interface{})))
+			}
+
+			conn.WriteJSON(map[string]interface{}{"op": OpRequestBatchResponse, "d": map[string]interface{}{"requestId": request["requestId"], "results": results}})
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	client := startFakeOBS(t, "secret", fakeServe)
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	if !client.Connected() {
+		t.Errorf("Client is not connected")
+	}
+
+	client.Disconnect()
+
+	client.Password = "wrong"
+
+	err = client.Connect()
+
+	if err == nil {
+		client.Disconnect()
+		t.Fatalf("Connect succeeded with a wrong password")
+	}
+
+	if client.Connected() {
+		t.Errorf("Client is connected after a failed authentication")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	client := startFakeOBS(t, "", fakeServe)
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	defer client.Disconnect()
+
+	data, err := client.Request("GetVersion", nil)
+
+	if err != nil || data["obsVersion"] != "30.0.0" {
+		t.Errorf("GetVersion returned %v, %v", data, err)
+	}
+
+	_, err = client.Request("Unknown", map[string]interface{}{"name": "test"})
+
+	var requesterr *RequestError
+
+	if !errors.As(err, &requesterr) || requesterr.Code != 204 || requesterr.RequestType != "Unknown" {
+		t.Errorf("Failed request returned %v", err)
+	}
+}
+
+func TestRequestBatch(t *testing.T) {
+	client := startFakeOBS(t, "", fakeServe)
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	defer client.Disconnect()
+
+	results, err := client.RequestBatch([]Request{{Type: "GetVersion"}, {Type: "Unknown"}}, false)
+
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Batch returned %d results", len(results))
+	}
+
+	if results[0].Err != nil || results[0].Data["obsVersion"] != "30.0.0" {
+		t.Errorf("First request returned %v, %v", results[0].Data, results[0].Err)
+	}
+
+	if results[1].Type != "Unknown" || results[1].Err == nil {
+		t.Errorf("Second request did not fail: %v", results[1])
+	}
+}
+
+func TestEvents(t *testing.T) {
+	client := startFakeOBS(t, "", func(conn *websocket.Conn) {
+		for index := 0; index < 3; index++ {
+			conn.WriteJSON(map[string]interface{}{"op": OpEvent, "d": map[string]interface{}{
+				"eventType":   "CurrentProgramSceneChanged",
+				"eventIntent": SubscriptionScenes,
+				"eventData":   map[string]interface{}{"sceneName": strconv.Itoa(index)}}})
+		}
+
+		fakeServe(conn)
+	})
+
+	scenes := make(chan string, 3)
+
+	// the handler can send requests, since events are dispatched by a different goroutine
+	client.SetEventHandler(func(eventType string, data map[string]interface{}) {
+		_, err := client.Request("GetVersion", nil)
+
+		if err != nil {
+			t.Errorf("Request from event handler failed: %v", err)
+		}
+
+		scenes <- data["sceneName"].(string)
+	})
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	defer client.Disconnect()
+
+	for index := 0; index < 3; index++ {
+		select {
+		case scene := <-scenes:
+			if scene != strconv.Itoa(index) {
+				t.Errorf("Event %d reports scene %s", index, scene)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %d not received", index)
+		}
+	}
+}
+
+func TestDisconnectCancelsRequests(t *testing.T) {
+	received := make(chan struct{})
+
+	// requests are never answered
+	client := startFakeOBS(t, "", func(conn *websocket.Conn) {
+		var msg message
+
+		conn.ReadJSON(&msg)
+		close(received)
+		conn.ReadJSON(&msg)
+	})
+
+	client.Timeout = time.Minute
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	go func() {
+		<-received
+		client.Disconnect()
+	}()
+
+	_, err = client.Request("GetVersion", nil)
+
+	if err == nil {
+		t.Errorf("Request succeeded after disconnect")
+	}
+
+	if client.Connected() {
+		t.Errorf("Client is connected after disconnect")
+	}
+}
+
+// connectWithin calls Connect and fails the test if it does not return within limit
+func connectWithin(t *testing.T, client *Client, limit time.Duration) error {
+	result := make(chan error, 1)
+
+	go func() {
+		result <- client.Connect()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(limit):
+		t.Fatalf("Connect did not return in %v", limit)
+		return nil
+	}
+}
+
+// TestStalledHandshake connects to servers that stop answering during the handshake, Connect
+// must fail after the timeout
+func TestStalledHandshake(t *testing.T) {
+	for name, serve := range map[string]func(conn *websocket.Conn){
+		"hello": fakeStall,
+		"identify": func(conn *websocket.Conn) {
+			conn.WriteJSON(map[string]interface{}{"op": OpHello, "d": map[string]interface{}{"rpcVersion": rpcVersion}})
+			fakeStall(conn)
+		},
+	} {
+		client := startFakeServer(t, serve)
+		client.Timeout = 100 * time.Millisecond
+
+		err := connectWithin(t, client, 5*time.Second)
+
+		if err == nil || !strings.Contains(err.Error(), "Timeout") {
+			t.Errorf("Stalled %s returned %v", name, err)
+		}
+
+		if client.Connected() {
+			t.Errorf("Client is connected after stalled %s", name)
+		}
+	}
+}
+
+// TestDisconnectCancelsConnect disconnects while Connect waits for a server that never answers,
+// both during the websocket upgrade and during the OBS handshake
+func TestDisconnectCancelsConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	accepted := make(chan struct{}, 2)
+
+	// the HTTP upgrade request is never answered
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	upgrade := newTestClient(listener.Addr())
+
+	handshake := startFakeServer(t, func(conn *websocket.Conn) {
+		accepted <- struct{}{}
+		fakeStall(conn)
+	})
+
+	for name, client := range map[string]*Client{"upgrade": upgrade, "handshake": handshake} {
+		client.Timeout = time.Minute
+
+		go func(client *Client) {
+			<-accepted
+			client.Disconnect()
+		}(client)
+
+		err := connectWithin(t, client, 5*time.Second)
+
+		if err == nil {
+			client.Disconnect()
+			t.Errorf("Connect succeeded after disconnect during %s", name)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	client := startFakeOBS(t, "", fakeStall)
+	client.Timeout = 100 * time.Millisecond
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	defer client.Disconnect()
+
+	start := time.Now()
+
+	_, err = client.Request("GetVersion", nil)
+
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("Request returned %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Request timed out after %v", time.Since(start))
+	}
+}
+
+// TestRequestContext checks that the deadline of the context replaces the timeout of the client,
+// and that requests can be cancelled
+func TestRequestContext(t *testing.T) {
+	// requests are answered after 200ms
+	client := startFakeOBS(t, "", func(conn *websocket.Conn) {
+		for {
+			var msg message
+
+			if conn.ReadJSON(&msg) != nil {
+				return
+			}
+
+			var request map[string]interface{}
+
+			json.Unmarshal(msg.D, &request)
+			time.Sleep(200 * time.Millisecond)
+
+			conn.WriteJSON(map[string]interface{}{"op": OpRequestResponse, "d": fakeResponse(request)})
+		}
+	})
+
+	client.Timeout = 50 * time.Millisecond
+
+	err := client.Connect()
+
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	defer client.Disconnect()
+
+	_, err = client.Request("GetVersion", nil)
+
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("Request returned %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := client.RequestContext(ctx, "GetVersion", nil)
+
+	if err != nil || data["obsVersion"] != "30.0.0" {
+		t.Errorf("Request with a longer deadline returned %v, %v", data, err)
+	}
+
+	client.Timeout = time.Minute
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = client.RequestBatchContext(ctx, []Request{{Type: "GetVersion"}}, false)
+
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("Batch with a shorter deadline returned %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+
+	_, err = client.RequestContext(ctx, "GetVersion", nil)
+
+	if err == nil || !strings.Contains(err.Error(), "cancelled") || time.Since(start) > 5*time.Second {
+		t.Errorf("Cancelled request returned %v after %v", err, time.Since(start))
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if len(client.pending) != 0 {
+		t.Errorf("%d requests are still pending", len(client.pending))
+	}
+}
@@ -0,0 +1,260 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Message is an OSC message, arguments can be int32, int64, float32, float64,
+// string, []byte, bool or nil
+type Message struct {
+	Address   string
+	Arguments []interface{}
+}
+
+const bundleTag = "#bundle"
+
+// writeString writes a string followed by a NUL and padding to a multiple of 4 bytes
+func writeString(buffer *bytes.Buffer, s string) {
+	buffer.WriteString(s)
+	buffer.Write(make([]byte, 4-len(s)%4))
+}
+
+// Encode converts a message in an OSC packet
+func Encode(message Message) ([]byte, error) {
+	if !strings.HasPrefix(message.Address, "/") {
+		return nil, fmt.Errorf("Invalid OSC address %s", message.Address)
+	}
+
+	var data bytes.Buffer
+
+	tags := ","
+
+	for _, argument := range message.Arguments {
+		switch v := argument.(type) {
+		case int32:
+			tags += "i"
+			binary.Write(&data, binary.BigEndian, v)
+		case int64:
+			tags += "h"
+			binary.Write(&data, binary.BigEndian, v)
+		case float32:
+			tags += "f"
+			binary.Write(&data, binary.BigEndian, math.Float32bits(v))
+		case float64:
+			tags += "d"
+			binary.Write(&data, binary.BigEndian, math.Float64bits(v))
+		case string:
+			tags += "s"
+			writeString(&data, v)
+		case []byte:
+			tags += "b"
+			binary.Write(&data, binary.BigEndian, int32(len(v)))
+			data.Write(v)
+			data.Write(make([]byte, (4-len(v)%4)%4))
+		case bool:
+			if v {
+				tags += "T"
+			} else {
+				tags += "F"
+			}
+		case nil:
+			tags += "N"
+		default:
+			return nil, fmt.Errorf("Unsupported OSC argument type %T", argument)
+		}
+	}
+
+	var packet bytes.Buffer
+
+	writeString(&packet, message.Address)
+	writeString(&packet, tags)
+	packet.Write(data.Bytes())
+	return packet.Bytes(), nil
+}
+
+// reader extracts OSC values from a packet
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) read(size int) ([]byte, error) {
+	if size < 0 || r.pos+size > len(r.data) {
+		return nil, fmt.Errorf("Truncated OSC packet")
+	}
+
+	value := r.data[r.pos : r.pos+size]
+	r.pos += size
+	return value, nil
+}
+
+func (r *reader) readString() (string, error) {
+	end := bytes.IndexByte(r.data[r.pos:], 0)
+
+	if end == -1 {
+		return "", fmt.Errorf("Unterminated OSC string")
+	}
+
+	s := string(r.data[r.pos : r.pos+end])
+
+	_, err := r.read(end + 4 - end%4)
+	return s, err
+}
+
+func (r *reader) readUint32() (uint32, error) {
+	value, err := r.read(4)
+
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+func (r *reader) readUint64() (uint64, error) {
+	value, err := r.read(8)
+
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// Decode converts an OSC packet in a list of messages, bundles are flattened
+// and their time tags are ignored
+func Decode(packet []byte) ([]Message, error) {
+	r := &reader{data: packet}
+
+	if bytes.HasPrefix(packet, []byte(bundleTag+"\x00")) {
+		var messages []Message
+
+		// skip tag and time tag
+		_, err := r.read(len(bundleTag) + 1 + 8)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for r.pos < len(r.data) {
+			size, err := r.readUint32()
+
+			if err != nil {
+				return nil, err
+			}
+
+			element, err := r.read(int(size))
+
+			if err != nil {
+				return nil, err
+			}
+
+			decoded, err := Decode(element)
+
+			if err != nil {
+				return nil, err
+			}
+
+			messages = append(messages, decoded...)
+		}
+		return messages, nil
+	}
+
+	message, err := decodeMessage(r)
+
+	if err != nil {
+		return nil, err
+	}
+	return []Message{message}, nil
+}
+
+func decodeMessage(r *reader) (Message, error) {
+	var message Message
+	var err error
+
+	message.Address, err = r.readString()
+
+	if err != nil {
+		return message, err
+	}
+
+	if !strings.HasPrefix(message.Address, "/") {
+		return message, fmt.Errorf("Invalid OSC address %s", message.Address)
+	}
+
+	// old implementations may omit type tags
+	if r.pos == len(r.data) {
+		return message, nil
+	}
+
+	tags, err := r.readString()
+
+	if err != nil {
+		return message, err
+	}
+
+	if !strings.HasPrefix(tags, ",") {
+		return message, fmt.Errorf("Invalid OSC type tags %s", tags)
+	}
+
+	for _, tag := range tags[1:] {
+		var argument interface{}
+
+		switch tag {
+		case 'i':
+			var value uint32
+
+			value, err = r.readUint32()
+			argument = int32(value)
+		case 'h':
+			var value uint64
+
+			value, err = r.readUint64()
+			argument = int64(value)
+		case 'f':
+			var value uint32
+
+			value, err = r.readUint32()
+			argument = math.Float32frombits(value)
+		case 'd':
+			var value uint64
+
+			value, err = r.readUint64()
+			argument = math.Float64frombits(value)
+		case 's', 'S':
+			argument, err = r.readString()
+		case 'b':
+			var size uint32
+
+			size, err = r.readUint32()
+
+			if err == nil {
+				var blob []byte
+
+				blob, err = r.read(int(size))
+				argument = blob
+
+				if err == nil {
+					_, err = r.read((4 - int(size)%4) % 4)
+				}
+			}
+		case 'T':
+			argument = true
+		case 'F':
+			argument = false
+		case 'N', 'I':
+			argument = nil
+		default:
+			return message, fmt.Errorf("Unsupported OSC type tag %c", tag)
+		}
+
+		if err != nil {
+			return message, err
+		}
+
+		message.Arguments = append(message.Arguments, argument)
+	}
+	return message, nil
+}
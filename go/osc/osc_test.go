@@ -0,0 +1,205 @@
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bundle builds a bundle packet containing elements
+func bundle(elements ...[]byte) []byte {
+	var packet bytes.Buffer
+
+	writeString(&packet, bundleTag)
+	packet.Write(make([]byte, 8))
+
+	for _, element := range elements {
+		binary.Write(&packet, binary.BigEndian, int32(len(element)))
+		packet.Write(element)
+	}
+	return packet.Bytes()
+}
+
+func mustEncode(t *testing.T, message Message) []byte {
+	packet, err := Encode(message)
+
+	if err != nil {
+		t.Fatalf("Encode %v failed: %v", message, err)
+	}
+	return packet
+}
+
+var testMessages = []Message{
+	{Address: "/empty"},
+	{Address: "/int", Arguments: []interface{}{int32(-5), int32(1 << 30)}},
+	{Address: "/long", Arguments: []interface{}{int64(-1 << 40)}},
+	{Address: "/float", Arguments: []interface{}{float32(0.5), float32(-1.25)}},
+	{Address: "/double", Arguments: []interface{}{1.0 / 3}},
+	{Address: "/string", Arguments: []interface{}{"", "a", "abc", "abcd", "abcdefg"}},
+	{Address: "/blob", Arguments: []interface{}{[]byte{}, []byte{1}, []byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 5}}},
+	{Address: "/flags", Arguments: []interface{}{true, false, nil}},
+	{Address: "/mixed", Arguments: []interface{}{int32(1), "two", float32(3), []byte{4}, true, nil, int64(7), 8.0}},
+	{Address: "/abc", Arguments: []interface{}{"abc"}},
+	{Address: "/abcdef", Arguments: []interface{}{"abcdefg", int32(1)}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, message := range testMessages {
+		packet := mustEncode(t, message)
+
+		if len(packet)%4 != 0 {
+			t.Errorf("Packet for %v is %d bytes long", message, len(packet))
+		}
+
+		decoded, err := Decode(packet)
+
+		if err != nil {
+			t.Errorf("Decode %v failed: %v", message, err)
+			continue
+		}
+
+		if len(decoded) != 1 || !reflect.DeepEqual(decoded[0], message) {
+			t.Errorf("Decode returned %v instead of %v", decoded, message)
+		}
+	}
+}
+
+// TestStringPadding checks that strings are always terminated, also when their length is
+// a multiple of 4
+func TestStringPadding(t *testing.T) {
+	tests := []struct {
+		message Message
+		packet  string
+	}{
+		{Message{Address: "/abc"}, "/abc\x00\x00\x00\x00,\x00\x00\x00"},
+		{Message{Address: "/ab"}, "/ab\x00,\x00\x00\x00"},
+		{Message{Address: "/a", Arguments: []interface{}{"abcd"}}, "/a\x00\x00,s\x00\x00abcd\x00\x00\x00\x00"},
+		{Message{Address: "/a", Arguments: []interface{}{"abcde"}}, "/a\x00\x00,s\x00\x00abcde\x00\x00\x00"},
+		{Message{Address: "/a", Arguments: []interface{}{[]byte("abcd")}}, "/a\x00\x00,b\x00\x00\x00\x00\x00\x04abcd"},
+		{Message{Address: "/a", Arguments: []interface{}{int32(1), true, false, nil}}, "/a\x00\x00,iTFN\x00\x00\x00\x00\x00\x00\x01"},
+	}
+
+	for _, test := range tests {
+		packet := mustEncode(t, test.message)
+
+		if string(packet) != test.packet {
+			t.Errorf("Encode %v returned %q instead of %q", test.message, packet, test.packet)
+		}
+	}
+}
+
+func TestBundles(t *testing.T) {
+	first := mustEncode(t, testMessages[1])
+	second := mustEncode(t, testMessages[5])
+	third := mustEncode(t, testMessages[8])
+
+	tests := []struct {
+		packet   []byte
+		expected []Message
+	}{
+		{bundle(), nil},
+		{bundle(first), []Message{testMessages[1]}},
+		{bundle(first, second), []Message{testMessages[1], testMessages[5]}},
+		{bundle(first, bundle(second, bundle(third)), bundle()), []Message{testMessages[1], testMessages[5], testMessages[8]}},
+	}
+
+	for _, test := range tests {
+		messages, err := Decode(test.packet)
+
+		if err != nil {
+			t.Errorf("Decode %q failed: %v", test.packet, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(messages, test.expected) {
+			t.Errorf("Decode %q returned %v instead of %v", test.packet, messages, test.expected)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	message := mustEncode(t, Message{Address: "/a", Arguments: []interface{}{int32(1)}})
+
+	tests := []struct {
+		packet string
+		error  string
+	}{
+		{"", "Unterminated OSC string"},
+		{"/abc", "Unterminated OSC string"},
+		{"/abc\x00\x00", "Truncated OSC packet"},
+		{"abc\x00", "Invalid OSC address abc"},
+		{"/a\x00\x00i\x00\x00\x00", "Invalid OSC type tags i"},
+		{"/a\x00\x00,x\x00\x00", "Unsupported OSC type tag x"},
+		{"/a\x00\x00,i\x00\x00\x00\x00", "Truncated OSC packet"},
+		{"/a\x00\x00,h\x00\x00\x00\x00\x00\x00", "Truncated OSC packet"},
+		{"/a\x00\x00,d\x00\x00\x00\x00\x00\x00", "Truncated OSC packet"},
+		{"/a\x00\x00,s\x00\x00abcd", "Unterminated OSC string"},
+		{"/a\x00\x00,b\x00\x00\x00\x00\x00\x05abcd", "Truncated OSC packet"},
+		{"/a\x00\x00,b\x00\x00\x00\x00\x00\x05abcde", "Truncated OSC packet"},
+		{"/a\x00\x00,b\x00\x00\xff\xff\xff\xff", "Truncated OSC packet"},
+		{"#bundle\x00\x00\x00", "Truncated OSC packet"},
+		{string(bundle()) + "\x00\x00", "Truncated OSC packet"},
+		{string(bundle()) + "\x00\x00\x00\x10" + string(message), "Truncated OSC packet"},
+		{string(bundle()) + "\xff\xff\xff\xff" + string(message), "Truncated OSC packet"},
+		{string(bundle([]byte("abc\x00"))), "Invalid OSC address abc"},
+	}
+
+	for _, test := range tests {
+		messages, err := Decode([]byte(test.packet))
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Decode %q returned %v, %v, expected %q", test.packet, messages, err, test.error)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		message Message
+		error   string
+	}{
+		{Message{Address: "mixer"}, "Invalid OSC address mixer"},
+		{Message{Address: "/mixer", Arguments: []interface{}{1}}, "Unsupported OSC argument type int"},
+		{Message{Address: "/mixer", Arguments: []interface{}{[]string{"a"}}}, "Unsupported OSC argument type []string"},
+	}
+
+	for _, test := range tests {
+		_, err := Encode(test.message)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Encode %v returned %v, expected %q", test.message, err, test.error)
+		}
+	}
+}
+
+// TestMalformedPackets decodes truncated and corrupted packets, Decode can fail but must not panic
+func TestMalformedPackets(t *testing.T) {
+	var packets [][]byte
+
+	for _, message := range testMessages {
+		packets = append(packets, mustEncode(t, message))
+	}
+
+	packets = append(packets, bundle(packets[1], bundle(packets[8])))
+
+	random := rand.New(rand.NewSource(1))
+
+	for _, packet := range packets {
+		for size := 0; size < len(packet); size++ {
+			Decode(packet[:size])
+		}
+
+		for iteration := 0; iteration < 200; iteration++ {
+			corrupted := append([]byte{}, packet...)
+
+			for count := 0; count < 3; count++ {
+				corrupted[random.Intn(len(corrupted))] = byte(random.Intn(256))
+			}
+
+			Decode(corrupted)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const closeTimeout = 2 * time.Second
+
+// DefaultTimeout is the time a request waits for a response, unless Timeout is changed
+const DefaultTimeout = 10 * time.Second
+
+// Process is an external driver running as a subprocess, it exchanges JSON-RPC 2.0 messages
+// with the application, one per line, on its standard input and output.
+// Standard error is forwarded to the application log
+type Process struct {
+	Timeout    time.Duration // maximum time a request waits for its response
+	name       string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	notify     func(method string, params json.RawMessage)
+	pending    map[int]chan message // requests waiting for a response, by id
+	nextid     int
+	closed     bool
+	mutex      sync.Mutex // protects pending, nextid and closed
+	writeMutex sync.Mutex // serializes writes to stdin, that may block if the driver is busy
+}
+
+// Error is an error reported by the driver
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int        `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// message is a response or a notification sent by the driver
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Start runs a driver, notify is invoked by the goroutine reading driver output for each
+// notification it sends, so it should not block
+func Start(command string, args []string, notify func(method string, params json.RawMessage)) (*Process, error) {
+	p := &Process{
+		Timeout: DefaultTimeout,
+		name:    command,
+		cmd:     exec.Command(command, args...),
+		notify:  notify,
+		pending: make(map[int]chan message),
+	}
+
+	p.cmd.Stderr = os.Stderr
+
+	stdin, err := p.cmd.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := p.cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.stdin = stdin
+
+	err = p.cmd.Start()
+
+	if err != nil {
+		return nil, fmt.Errorf("Error %v starting driver %s", err, command)
+	}
+
+	go p.reader(stdout)
+	return p, nil
+}
+
+func (p *Process) reader(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var msg message
+
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+
+		if err != nil {
+			log.Printf("Error %v decoding message from driver %s", err, p.name)
+			continue
+		}
+
+		if msg.ID == nil {
+			if msg.Method != "" && p.notify != nil {
+				p.notify(msg.Method, msg.Params)
+			}
+			continue
+		}
+
+		p.mutex.Lock()
+		response, ok := p.pending[*msg.ID]
+		delete(p.pending, *msg.ID)
+		p.mutex.Unlock()
+
+		if ok {
+			response <- msg
+		}
+	}
+
+	// driver terminated, pending requests will never get a response
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+
+	for id, response := range p.pending {
+		response <- message{Error: &Error{Code: -32000, Message: fmt.Sprintf("Driver %s terminated", p.name)}}
+		delete(p.pending, id)
+	}
+}
+
+func (p *Process) send(req request) error {
+	data, err := json.Marshal(req)
+
+	if err != nil {
+		return err
+	}
+
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+
+	_, err = p.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// Call invokes a method and waits for its result, result can be nil if it's not needed.
+// The mutex is not held while sending, so responses can be delivered even if the driver
+// does not read its input while writing its output
+func (p *Process) Call(method string, params interface{}, result interface{}) error {
+	response := make(chan message, 1)
+
+	p.mutex.Lock()
+
+	if p.closed {
+		p.mutex.Unlock()
+		return fmt.Errorf("Driver %s terminated", p.name)
+	}
+
+	p.nextid++
+	id := p.nextid
+	p.pending[id] = response
+	p.mutex.Unlock()
+
+	timer := time.NewTimer(p.Timeout)
+	defer timer.Stop()
+
+	// writes are done on a separate goroutine, since they block if the driver is not reading
+	sent := make(chan error, 1)
+
+	go func() {
+		sent <- p.send(request{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	}()
+
+	for {
+		select {
+		case err := <-sent:
+			if err != nil {
+				p.cancel(id)
+				return fmt.Errorf("Error %v sending request to driver %s", err, p.name)
+			}
+
+			// a nil channel is never ready, now only the response or the timeout are waited for
+			sent = nil
+		case msg := <-response:
+			if msg.Error != nil {
+				return msg.Error
+			}
+
+			if result == nil || len(msg.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(msg.Result, result)
+		case <-timer.C:
+			p.cancel(id)
+			return fmt.Errorf("Timeout waiting for response to %s from driver %s", method, p.name)
+		}
+	}
+}
+
+// cancel removes a request that will not wait for its response anymore
+func (p *Process) cancel(id int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.pending, id)
+}
+
+// Notify sends a notification, that does not expect a response
+func (p *Process) Notify(method string, params interface{}) error {
+	return p.send(request{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Close terminates the driver, closing its standard input, the driver is killed if it does
+// not exit in a reasonable time
+func (p *Process) Close() {
+	p.stdin.Close()
+
+	exited := make(chan struct{})
+
+	go func() {
+		p.cmd.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(closeTimeout):
+		p.cmd.Process.Kill()
+		<-exited
+	}
+}
@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperDriver is not a real test, it's the driver started by the other tests, its
+// behavior is selected by the RPC_TEST_DRIVER environment variable
+func TestHelperDriver(t *testing.T) {
+	mode := os.Getenv("RPC_TEST_DRIVER")
+
+	if mode == "" {
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for count := 0; ; count++ {
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			os.Exit(0)
+		}
+
+		var req request
+
+		json.Unmarshal([]byte(line), &req)
+
+		switch {
+		case mode == "silent":
+			continue
+		case mode == "exit":
+			os.Exit(1)
+		case mode == "slowreader" && count == 0:
+			// answers the first request late and stops reading for a while
+			time.Sleep(200 * time.Millisecond)
+			fmt.Printf(`{"jsonrpc": "2.0", "id": %d, "result": "first"}`+"\n", *req.ID)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		fmt.Printf(`{"jsonrpc": "2.0", "method": "echo", "params": %q}`+"\n", req.Method)
+		fmt.Printf(`{"jsonrpc": "2.0", "id": %d, "result": %q}`+"\n", *req.ID, req.Method)
+	}
+}
+
+func startDriver(t *testing.T, mode string, notify func(string, json.RawMessage)) *Process {
+	os.Setenv("RPC_TEST_DRIVER", mode)
+	defer os.Unsetenv("RPC_TEST_DRIVER")
+
+	p, err := Start(os.Args[0], []string{"-test.run=TestHelperDriver"}, notify)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestCall(t *testing.T) {
+	notifications := make(chan string, 10)
+
+	p := startDriver(t, "echo", func(method string, params json.RawMessage) {
+		notifications <- method + " " + string(params)
+	})
+
+	var result string
+
+	err := p.Call("hello", nil, &result)
+
+	if err != nil || result != "hello" {
+		t.Fatalf("Call returned %q, %v", result, err)
+	}
+
+	if notification := <-notifications; notification != `echo "hello"` {
+		t.Errorf("Unexpected notification %s", notification)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	p := startDriver(t, "silent", nil)
+
+	p.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := p.Call("hello", nil, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("Timeout expired after %v", time.Since(start))
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.pending) != 0 {
+		t.Errorf("Request still pending after timeout")
+	}
+}
+
+func TestDriverTerminated(t *testing.T) {
+	p := startDriver(t, "exit", nil)
+
+	err := p.Call("hello", nil, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "terminated") {
+		t.Errorf("Expected termination error, got %v", err)
+	}
+}
+
+// TestBlockedWrite checks that responses are delivered while another request is blocked
+// writing to a driver that is not reading its input
+func TestBlockedWrite(t *testing.T) {
+	p := startDriver(t, "slowreader", nil)
+
+	first := make(chan error, 1)
+
+	go func() {
+		first <- p.Call("first", nil, nil)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// larger than the pipe buffer, the write blocks until the driver reads it
+	go p.Call("second", strings.Repeat("x", 1024*1024), nil)
+
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Errorf("First request failed: %v", err)
+		}
+	case <-time.After(800 * time.Millisecond):
+		t.Errorf("Response not delivered while writing another request")
+	}
+}
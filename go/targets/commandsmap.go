@@ -5,10 +5,25 @@ import (
 	"strings"
 )
 
-// CommandDefinition defines a command with a check and an exec function
+// CommandDefinition defines a command with its parameters and the exec function,
+// CheckFunc is optional and can perform checks that can't be described by parameters
 type CommandDefinition struct {
 	CheckFunc   func(interface{}, []interface{}) error
 	ExecuteFunc func(interface{}, []interface{}) error
+	Description string
+	Parameters  []ParameterDefinition
+}
+
+// ParameterDefinition describes a command parameter
+type ParameterDefinition struct {
+	Name        string
+	Type        string // string, number, integer, boolean, duration (string or number), array, object or any
+	Description string
+	Optional    bool     // parameter can be omitted (all the following ones must be optional too)
+	Variadic    bool     // last parameter can be repeated (at least once, unless it's optional)
+	Enum        []string // allowed values
+	Min         *float64 // minimum value for numbers
+	Max         *float64 // maximum value for numbers
 }
 
 // Map allow easy definition of command with name and check/execute functions
@@ -17,13 +32,18 @@ type Map struct {
 	target   interface{}
 }
 
-// Init connects object to target and map of commands
+// Init connects object to target and map of commands, command names are case-insensitive
 func (cmdmap *Map) Init(target interface{}, commands map[string]CommandDefinition) {
 	cmdmap.target = target
-	cmdmap.commands = commands
+	cmdmap.commands = make(map[string]CommandDefinition, len(commands))
+
+	for name, cmd := range commands {
+		cmdmap.commands[strings.ToLower(name)] = cmd
+	}
 }
 
-// CheckCommand verifies parameters
+// CheckCommand verifies parameters, CheckFunc is not called if some of them are templates,
+// since it may need their actual values
 func (cmdmap *Map) CheckCommand(command string, parameters []interface{}) error {
 	lowercommand := strings.ToLower(command)
 
@@ -33,6 +53,15 @@ func (cmdmap *Map) CheckCommand(command string, parameters []interface{}) error
 		return fmt.Errorf("Invalid command %s", command)
 	}
 
+	err := ValidateParameters(command, cmd.Parameters, parameters)
+
+	if err != nil {
+		return err
+	}
+
+	if cmd.CheckFunc == nil || hasTemplates(parameters) {
+		return nil
+	}
 	return cmd.CheckFunc(cmdmap.target, parameters)
 }
 
@@ -48,11 +77,3 @@ func (cmdmap *Map) ExecuteCommand(command string, parameters []interface{}) erro
 
 	return cmd.ExecuteFunc(cmdmap.target, parameters)
 }
-
-// NoParmsCheck can be used to validate commands that have no parameters
-func NoParmsCheck(target interface{}, parameters []interface{}) error {
-	if len(parameters) != 0 {
-		return fmt.Errorf("Command has no parameters")
-	}
-	return nil
-}
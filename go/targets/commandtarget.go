@@ -2,6 +2,7 @@ package targets
 
 import (
 	"fmt"
+	"sort"
 )
 
 // CommandTarget defines an object that can execute commands
@@ -11,13 +12,63 @@ type CommandTarget interface {
 	ExecuteCommand(command string, parameters []interface{}) error //executes a command
 }
 
+// StateProvider is implemented by targets that can report their internal state
+// (ex: current scene), state can be used inside command parameters
+type StateProvider interface {
+	GetState() map[string]interface{} // returns a snapshot of the current state
+}
+
+// StateTypesProvider can be implemented by state providers that can report values
+// before they are actually set (ex: user variables), it's used to validate conditions
+type StateTypesProvider interface {
+	GetStateTypes() map[string]interface{} // returns a sample value for each state entry
+}
+
+// Factory creates a new, uninitialized, instance of a target
+type Factory func() CommandTarget
+
+type targetRegistration struct {
+	factory  Factory
+	commands map[string]CommandDefinition
+}
+
+// targets registered by their init functions, by type
+var registry = make(map[string]targetRegistration)
+
+// Register makes a target type available for configuration, it must be called from the init
+// function of the file implementing the target. Commands are used to describe the target
+// and can be nil if they are known only after initialization
+func Register(targettype string, factory Factory, commands map[string]CommandDefinition) {
+	if _, ok := registry[targettype]; ok {
+		panic("Target type " + targettype + " registered twice")
+	}
+
+	registry[targettype] = targetRegistration{factory: factory, commands: commands}
+}
+
 // CreateCommand will return CommandTarget depending on targettype
 func CreateCommand(targettype string) (CommandTarget, error) {
-	switch targettype {
-	case "obs":
-		return new(obsCommandTarget), nil
-	case "keyboard":
-		return new(keybdCommandTarget), nil
+	registration, ok := registry[targettype]
+
+	if !ok {
+		return nil, fmt.Errorf("%v is not a valid command-target type", targettype)
+	}
+	return registration.factory(), nil
+}
+
+// TargetTypes returns the list of supported target types
+func TargetTypes() []string {
+	types := make([]string, 0, len(registry))
+
+	for targettype := range registry {
+		types = append(types, targettype)
 	}
-	return nil, fmt.Errorf("%v is not a valid command-target type", targettype)
+
+	sort.Strings(types)
+	return types
+}
+
+// GetCommands returns the commands supported by a target type
+func GetCommands(targettype string) map[string]CommandDefinition {
+	return registry[targettype].commands
 }
@@ -0,0 +1,268 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type execCommandTarget struct {
+	commandsMap *Map
+	allow       []string
+	dir         string
+	env         []string
+	timeout     time.Duration
+}
+
+type execCommandTargetConfig struct {
+	Allow   []string // executables that can be run: names, paths or wildcards
+	Dir     string
+	Env     map[string]string
+	Timeout interface{}
+}
+
+// execOptions are the options of a run command, defaults are read from configuration
+type execOptions struct {
+	dir     string
+	env     []string
+	timeout time.Duration
+	wait    bool
+}
+
+var execCommands = map[string]CommandDefinition{
+	"run": {
+		CheckFunc:   runCheck,
+		ExecuteFunc: runExec,
+		Description: "Runs an executable, its output is written to the log",
+		Parameters: []ParameterDefinition{
+			{Name: "argv", Type: "array", Description: "Executable and its arguments"},
+			{Name: "options", Type: "object", Description: "env (object), dir (string), timeout (duration), wait (boolean, default is true)", Optional: true}}},
+}
+
+func init() {
+	Register("exec", func() CommandTarget { return new(execCommandTarget) }, execCommands)
+}
+
+// logWriter writes each line of output of a process to the log
+type logWriter struct {
+	name   string
+	buffer []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+
+	for {
+		index := bytes.IndexByte(w.buffer, '\n')
+
+		if index == -1 {
+			return len(p), nil
+		}
+
+		log.Printf("%s: %s", w.name, strings.TrimRight(string(w.buffer[:index]), "\r"))
+		w.buffer = w.buffer[index+1:]
+	}
+}
+
+func (w *logWriter) flush() {
+	if len(w.buffer) != 0 {
+		log.Printf("%s: %s", w.name, string(w.buffer))
+		w.buffer = nil
+	}
+}
+
+func argvStrings(value interface{}) ([]string, error) {
+	items := value.([]interface{})
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("No executable specified for run command")
+	}
+
+	argv := make([]string, len(items))
+
+	for index, item := range items {
+		switch item.(type) {
+		case string, int, float64, bool:
+			argv[index] = fmt.Sprint(item)
+		default:
+			return nil, fmt.Errorf("Invalid argument %v for run command", item)
+		}
+	}
+	return argv, nil
+}
+
+// allowed checks if an executable matches the allowlist, using the name passed
+// in the command or the full path found by searching in PATH
+func (ex *execCommandTarget) allowed(program string) bool {
+	candidates := []string{program}
+
+	if path, err := exec.LookPath(program); err == nil {
+		candidates = append(candidates, path)
+	}
+
+	for _, pattern := range ex.allow {
+		for _, candidate := range candidates {
+			if matched, _ := filepath.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ex *execCommandTarget) parseOptions(parameters []interface{}) (execOptions, error) {
+	options := execOptions{dir: ex.dir, env: ex.env, timeout: ex.timeout, wait: true}
+
+	if len(parameters) < 2 {
+		return options, nil
+	}
+
+	for name, value := range parameters[1].(map[string]interface{}) {
+		var ok bool
+		var err error
+
+		switch name {
+		case "dir":
+			options.dir, ok = value.(string)
+		case "wait":
+			options.wait, ok = ToBool(value)
+		case "timeout":
+			options.timeout, err = ParseDuration(value)
+			ok = err == nil
+		case "env":
+			var env map[string]interface{}
+
+			env, ok = value.(map[string]interface{})
+			options.env = append([]string{}, ex.env...)
+
+			for key, v := range env {
+				options.env = append(options.env, key+"="+fmt.Sprint(v))
+			}
+		default:
+			return options, fmt.Errorf("Invalid option %s for run command", name)
+		}
+
+		if !ok {
+			return options, fmt.Errorf("Invalid value %v for option %s of run command", value, name)
+		}
+	}
+	return options, nil
+}
+
+func runCheck(target interface{}, parameters []interface{}) error {
+	ex := target.(*execCommandTarget)
+
+	argv, err := argvStrings(parameters[0])
+
+	if err != nil {
+		return err
+	}
+
+	if !ex.allowed(argv[0]) {
+		return fmt.Errorf("Executable %s is not in the allow list", argv[0])
+	}
+
+	_, err = ex.parseOptions(parameters)
+	return err
+}
+
+func runExec(target interface{}, parameters []interface{}) error {
+	ex := target.(*execCommandTarget)
+
+	argv, _ := argvStrings(parameters[0])
+	options, _ := ex.parseOptions(parameters)
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+
+	if options.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+	}
+
+	output := &logWriter{name: filepath.Base(argv[0])}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = options.dir
+	cmd.Env = append(os.Environ(), options.env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Start()
+
+	if err != nil {
+		cancel()
+		return fmt.Errorf("Can't run %s: %v", argv[0], err)
+	}
+
+	wait := func() error {
+		defer cancel()
+
+		err := cmd.Wait()
+
+		output.flush()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("Timeout running %s", argv[0])
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s terminated with %v", argv[0], err)
+		}
+		return nil
+	}
+
+	if !options.wait {
+		go func() {
+			if err := wait(); err != nil {
+				log.Print(err)
+			}
+		}()
+		return nil
+	}
+	return wait()
+}
+
+func (ex *execCommandTarget) Init(configyaml []byte) error {
+	var cfg execCommandTargetConfig
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err != nil {
+		log.Printf("error %v parsing exec target configuration", err)
+		return err
+	}
+
+	ex.commandsMap = new(Map)
+	ex.commandsMap.Init(ex, execCommands)
+
+	ex.allow = cfg.Allow
+	ex.dir = cfg.Dir
+
+	for name, value := range cfg.Env {
+		ex.env = append(ex.env, name+"="+value)
+	}
+
+	if cfg.Timeout != nil {
+		ex.timeout, err = ParseDuration(cfg.Timeout)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *execCommandTarget) CheckCommand(command string, parameters []interface{}) error {
+	return ex.commandsMap.CheckCommand(command, parameters)
+}
+
+func (ex *execCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return ex.commandsMap.ExecuteCommand(command, parameters)
+}
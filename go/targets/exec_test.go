@@ -0,0 +1,203 @@
+package targets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is run as the executable of exec tests, the mode is selected by the
+// arguments after --
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("EXEC_TEST_HELPER") != "1" {
+		return
+	}
+
+	args := os.Args
+
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+
+	if len(args) < 3 {
+		os.Exit(2)
+	}
+
+	switch args[1] {
+	case "exit":
+		code, _ := strconv.Atoi(args[2])
+		os.Exit(code)
+	case "sleep":
+		duration, _ := time.ParseDuration(args[2])
+		time.Sleep(duration)
+	case "touch":
+		time.Sleep(200 * time.Millisecond)
+		ioutil.WriteFile(args[2], nil, 0644)
+	}
+	os.Exit(0)
+}
+
+func newExecTestTarget(t *testing.T, configyaml string) *execCommandTarget {
+	ex := new(execCommandTarget)
+
+	err := ex.Init([]byte(configyaml))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ex
+}
+
+// helperArgv returns the arguments that run TestHelperProcess in mode
+func helperArgv(mode ...string) []interface{} {
+	argv := []interface{}{os.Args[0], "-test.run=TestHelperProcess", "--"}
+
+	for _, arg := range mode {
+		argv = append(argv, arg)
+	}
+	return argv
+}
+
+func TestExecAllowList(t *testing.T) {
+	helper, _ := filepath.Abs(os.Args[0])
+
+	tests := []struct {
+		allow   []string
+		program string
+		allowed bool
+	}{
+		{nil, "sh", false},
+		{[]string{"sh"}, "sh", true},
+		{[]string{"sh"}, "/bin/sh", false},
+		{[]string{"sh"}, "./sh", false},
+		{[]string{"s*"}, "sh", true},
+		{[]string{"/bin/*", "/usr/bin/*"}, "sh", true},
+		{[]string{"/bin/sh"}, "/bin/sh", true},
+		{[]string{"/bin/*"}, "/bin/../tmp/sh", false},
+		{[]string{"/bin/*"}, "../bin/sh", false},
+		{[]string{helper}, helper, true},
+		{[]string{filepath.Join(filepath.Dir(helper), "*")}, helper, true},
+		{[]string{filepath.Base(helper)}, helper, false},
+	}
+
+	for _, test := range tests {
+		ex := &execCommandTarget{allow: test.allow}
+		ex.commandsMap = new(Map)
+		ex.commandsMap.Init(ex, execCommands)
+
+		err := ex.CheckCommand("run", []interface{}{[]interface{}{test.program, "-c", "true"}})
+
+		if test.allowed && err != nil {
+			t.Errorf("%s with allow list %v failed: %v", test.program, test.allow, err)
+		}
+
+		if !test.allowed && (err == nil || !strings.Contains(err.Error(), "is not in the allow list")) {
+			t.Errorf("%s with allow list %v returned %v", test.program, test.allow, err)
+		}
+	}
+}
+
+func TestExecCheckErrors(t *testing.T) {
+	ex := newExecTestTarget(t, "allow: [sh]")
+
+	tests := []struct {
+		parameters []interface{}
+		error      string
+	}{
+		{[]interface{}{[]interface{}{}}, "No executable specified"},
+		{[]interface{}{[]interface{}{"sh", []interface{}{"-c"}}}, "Invalid argument"},
+		{[]interface{}{[]interface{}{"sh"}, map[string]interface{}{"shell": true}}, "Invalid option shell"},
+		{[]interface{}{[]interface{}{"sh"}, map[string]interface{}{"timeout": "soon"}}, "Invalid value soon for option timeout"},
+		{[]interface{}{[]interface{}{"sh"}, map[string]interface{}{"env": "A=1"}}, "Invalid value A=1 for option env"},
+	}
+
+	for _, test := range tests {
+		err := ex.CheckCommand("run", test.parameters)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Run %v returned %v, expected %q", test.parameters, err, test.error)
+		}
+	}
+}
+
+func TestExecRun(t *testing.T) {
+	ex := newExecTestTarget(t, "allow: ["+strconv.Quote(os.Args[0])+"]\nenv:\n  EXEC_TEST_HELPER: \"1\"")
+
+	tests := []struct {
+		parameters []interface{}
+		error      string
+	}{
+		{[]interface{}{helperArgv("exit", "0")}, ""},
+		{[]interface{}{helperArgv("exit", "3")}, "terminated with exit status 3"},
+		{[]interface{}{helperArgv("sleep", "10s"), map[string]interface{}{"timeout": "100ms"}}, "Timeout running"},
+	}
+
+	for _, test := range tests {
+		err := ex.CheckCommand("run", test.parameters)
+
+		if err != nil {
+			t.Errorf("Check %v failed: %v", test.parameters, err)
+			continue
+		}
+
+		start := time.Now()
+
+		err = ex.ExecuteCommand("run", test.parameters)
+
+		if test.error == "" && err != nil {
+			t.Errorf("Run %v failed: %v", test.parameters, err)
+		}
+
+		if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("Run %v returned %v, expected %q", test.parameters, err, test.error)
+		}
+
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("Run %v took %v", test.parameters, time.Since(start))
+		}
+	}
+}
+
+// TestExecNoWait checks that a run command with wait false returns before the process terminates
+func TestExecNoWait(t *testing.T) {
+	ex := newExecTestTarget(t, "allow: ["+strconv.Quote(os.Args[0])+"]")
+
+	dir, err := ioutil.TempDir("", "exec")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "done")
+	parameters := []interface{}{helperArgv("touch", file), map[string]interface{}{"wait": false, "env": map[string]interface{}{"EXEC_TEST_HELPER": 1}}}
+
+	err = ex.ExecuteCommand("run", parameters)
+
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("Run returned after the process terminated")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if _, err := os.Stat(file); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Process started without waiting did not run")
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
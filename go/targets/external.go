@@ -0,0 +1,166 @@
+package targets
+
+import (
+	"encoding/json"
+	"fmt"
+	"keypad/rpc"
+	"log"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// externalCommandTarget is implemented by a driver running as a separate process,
+// see rpc.Process for a description of the protocol
+type externalCommandTarget struct {
+	process     *rpc.Process
+	commandsMap *Map
+	state       map[string]interface{}
+	mutex       sync.Mutex // protects state, updated by driver notifications
+}
+
+type externalCommandTargetConfig struct {
+	Command string
+	Args    []string
+	Timeout interface{}
+}
+
+// externalCommand is a command declared by the driver
+type externalCommand struct {
+	Name        string
+	Description string
+	Parameters  []ParameterDefinition
+}
+
+type externalInitResult struct {
+	Commands []externalCommand
+	State    map[string]interface{}
+}
+
+func init() {
+	// commands are declared by the driver when it's initialized
+	Register("external", func() CommandTarget { return new(externalCommandTarget) }, nil)
+}
+
+// externalExec returns a function that forwards a command to the driver
+func externalExec(command string) func(interface{}, []interface{}) error {
+	return func(target interface{}, parameters []interface{}) error {
+		external := target.(*externalCommandTarget)
+
+		return external.process.Call("execute", map[string]interface{}{"command": command, "parameters": parameters}, nil)
+	}
+}
+
+// notification handles messages sent by the driver, "state" updates one or more state values
+func (external *externalCommandTarget) notification(method string, params json.RawMessage) {
+	if method != "state" {
+		log.Printf("Unknown notification %s from external target", method)
+		return
+	}
+
+	var values map[string]interface{}
+
+	err := json.Unmarshal(params, &values)
+
+	if err != nil {
+		log.Printf("Error %v decoding state of external target", err)
+		return
+	}
+
+	external.mutex.Lock()
+	defer external.mutex.Unlock()
+
+	for name, value := range values {
+		external.state[name] = value
+	}
+}
+
+func (external *externalCommandTarget) Init(configyaml []byte) error {
+	var cfg externalCommandTargetConfig
+	var config map[string]interface{}
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err == nil {
+		err = yaml.Unmarshal(configyaml, &config)
+	}
+
+	if err != nil {
+		log.Printf("error %v parsing external target configuration", err)
+		return err
+	}
+
+	if cfg.Command == "" {
+		return fmt.Errorf("no command has been configured for external target")
+	}
+
+	timeout := rpc.DefaultTimeout
+
+	if cfg.Timeout != nil {
+		timeout, err = ParseDuration(cfg.Timeout)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	external.state = make(map[string]interface{})
+
+	external.process, err = rpc.Start(cfg.Command, cfg.Args, external.notification)
+
+	if err != nil {
+		return err
+	}
+
+	external.process.Timeout = timeout
+
+	var result externalInitResult
+
+	err = external.process.Call("init", map[string]interface{}{"config": config}, &result)
+
+	if err != nil {
+		external.process.Close()
+		return fmt.Errorf("Error %v initializing external target %s", err, cfg.Command)
+	}
+
+	commands := make(map[string]CommandDefinition, len(result.Commands))
+
+	for _, command := range result.Commands {
+		commands[command.Name] = CommandDefinition{
+			ExecuteFunc: externalExec(command.Name),
+			Description: command.Description,
+			Parameters:  command.Parameters,
+		}
+	}
+
+	external.commandsMap = new(Map)
+	external.commandsMap.Init(external, commands)
+
+	external.mutex.Lock()
+	defer external.mutex.Unlock()
+
+	for name, value := range result.State {
+		external.state[name] = value
+	}
+	return nil
+}
+
+func (external *externalCommandTarget) CheckCommand(command string, parameters []interface{}) error {
+	return external.commandsMap.CheckCommand(command, parameters)
+}
+
+func (external *externalCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return external.commandsMap.ExecuteCommand(command, parameters)
+}
+
+func (external *externalCommandTarget) GetState() map[string]interface{} {
+	external.mutex.Lock()
+	defer external.mutex.Unlock()
+
+	state := make(map[string]interface{}, len(external.state))
+
+	for name, value := range external.state {
+		state[name] = value
+	}
+	return state
+}
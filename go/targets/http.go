@@ -0,0 +1,281 @@
+package targets
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const httpDefaultTimeout = 10 * time.Second
+const httpMaxErrorBody = 512
+const httpMaxBody = 1024 * 1024
+
+type httpCommandTarget struct {
+	commandsMap *Map
+	endpoints   map[string]*httpEndpoint
+	responses   map[string]map[string]interface{} // last response of each endpoint, reported as state
+	mutex       sync.Mutex                        // protects responses
+}
+
+// httpEndpoint is a service that can be invoked by commands
+type httpEndpoint struct {
+	url      string
+	headers  map[string]string
+	token    string
+	username string
+	password string
+	client   *http.Client
+}
+
+type httpEndpointConfig struct {
+	URL      string
+	Headers  map[string]string
+	Token    string // sent as bearer token in Authorization header
+	Username string // basic authentication
+	Password string
+	Timeout  interface{}
+	Insecure bool   // skips verification of server certificate
+	CA       string // file with certificates of the authorities that can sign server certificate
+	Cert     string // files with client certificate and key
+	Key      string
+}
+
+type httpCommandTargetConfig struct {
+	Endpoints map[string]httpEndpointConfig
+}
+
+var httpMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+
+var httpCommands = map[string]CommandDefinition{
+	"request": {
+		CheckFunc:   httpRequestCheck,
+		ExecuteFunc: httpRequestExec,
+		Description: "Sends a request to an endpoint, fails if response status is not 2xx",
+		Parameters: []ParameterDefinition{
+			{Name: "endpoint", Type: "string", Description: "Endpoint name"},
+			{Name: "method", Type: "string", Description: "HTTP method", Enum: httpMethods},
+			{Name: "path", Type: "string", Description: "Path appended to endpoint URL", Optional: true},
+			{Name: "body", Type: "any", Description: "Body, objects and arrays are sent as JSON", Optional: true}}},
+}
+
+func init() {
+	Register("http", func() CommandTarget { return new(httpCommandTarget) }, httpCommands)
+}
+
+func httpRequestCheck(target interface{}, parameters []interface{}) error {
+	h := target.(*httpCommandTarget)
+
+	if _, ok := h.endpoints[parameters[0].(string)]; !ok {
+		return fmt.Errorf("Invalid endpoint %s", parameters[0])
+	}
+	return nil
+}
+
+func httpRequestExec(target interface{}, parameters []interface{}) error {
+	h := target.(*httpCommandTarget)
+	endpoint := h.endpoints[parameters[0].(string)]
+	method := parameters[1].(string)
+	url := endpoint.url
+	contenttype := ""
+
+	if len(parameters) > 2 {
+		url = strings.TrimRight(url, "/") + "/" + strings.TrimLeft(parameters[2].(string), "/")
+	}
+
+	var body io.Reader
+
+	if len(parameters) > 3 {
+		switch v := parameters[3].(type) {
+		case string:
+			body = strings.NewReader(v)
+		default:
+			data, err := json.Marshal(v)
+
+			if err != nil {
+				return err
+			}
+
+			body = bytes.NewReader(data)
+			contenttype = "application/json"
+		}
+	}
+
+	request, err := http.NewRequest(method, url, body)
+
+	if err != nil {
+		return err
+	}
+
+	if contenttype != "" {
+		request.Header.Set("Content-Type", contenttype)
+	}
+
+	for name, value := range endpoint.headers {
+		request.Header.Set(name, value)
+	}
+
+	if endpoint.token != "" {
+		request.Header.Set("Authorization", "Bearer "+endpoint.token)
+	}
+
+	if endpoint.username != "" {
+		request.SetBasicAuth(endpoint.username, endpoint.password)
+	}
+
+	response, err := endpoint.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, httpMaxBody))
+
+	if err != nil {
+		return err
+	}
+
+	h.setResponse(parameters[0].(string), response, data)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		if len(data) > httpMaxErrorBody {
+			data = data[:httpMaxErrorBody]
+		}
+		return fmt.Errorf("%s %s returned %s %s", method, url, response.Status, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// setResponse stores status and body of the last response of an endpoint, JSON bodies are decoded
+func (h *httpCommandTarget) setResponse(endpoint string, response *http.Response, data []byte) {
+	var body interface{} = string(data)
+
+	if strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+		var decoded interface{}
+
+		if json.Unmarshal(data, &decoded) == nil {
+			body = decoded
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.responses[endpoint] = map[string]interface{}{"status": response.StatusCode, "body": body}
+}
+
+func newHTTPEndpoint(name string, cfg httpEndpointConfig) (*httpEndpoint, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("no URL has been configured for endpoint %s", name)
+	}
+
+	timeout := httpDefaultTimeout
+
+	if cfg.Timeout != nil {
+		var err error
+
+		timeout, err = ParseDuration(cfg.Timeout)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsconfig := &tls.Config{InsecureSkipVerify: cfg.Insecure}
+
+	if cfg.CA != "" {
+		data, err := ioutil.ReadFile(cfg.CA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsconfig.RootCAs = x509.NewCertPool()
+
+		if !tlsconfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no valid certificates in %s", cfg.CA)
+		}
+	}
+
+	if cfg.Cert != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsconfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconfig
+
+	return &httpEndpoint{
+		url:      cfg.URL,
+		headers:  cfg.Headers,
+		token:    cfg.Token,
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+func (h *httpCommandTarget) Init(configyaml []byte) error {
+	var cfg httpCommandTargetConfig
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err != nil {
+		log.Printf("error %v parsing http target configuration", err)
+		return err
+	}
+
+	h.commandsMap = new(Map)
+	h.commandsMap.Init(h, httpCommands)
+	h.endpoints = make(map[string]*httpEndpoint, len(cfg.Endpoints))
+	h.responses = make(map[string]map[string]interface{}, len(cfg.Endpoints))
+
+	for name, endpointcfg := range cfg.Endpoints {
+		h.endpoints[name], err = newHTTPEndpoint(name, endpointcfg)
+
+		if err != nil {
+			log.Printf("error %v configuring endpoint %s", err, name)
+			return err
+		}
+
+		h.responses[name] = map[string]interface{}{"status": 0, "body": nil}
+	}
+	return nil
+}
+
+func (h *httpCommandTarget) CheckCommand(command string, parameters []interface{}) error {
+	return h.commandsMap.CheckCommand(command, parameters)
+}
+
+func (h *httpCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return h.commandsMap.ExecuteCommand(command, parameters)
+}
+
+func (h *httpCommandTarget) GetState() map[string]interface{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	state := make(map[string]interface{}, len(h.responses))
+
+	for name, response := range h.responses {
+		state[name] = response
+	}
+	return state
+}
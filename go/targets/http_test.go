@@ -0,0 +1,190 @@
+package targets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedRequest is a request received by the test server
+type receivedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func newHTTPTestTarget(t *testing.T, handler http.HandlerFunc, endpoint string) (*httpCommandTarget, chan receivedRequest) {
+	received := make(chan receivedRequest, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		received <- receivedRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)}
+		handler(w, r)
+	}))
+
+	t.Cleanup(server.Close)
+
+	h := new(httpCommandTarget)
+
+	err := h.Init([]byte(fmt.Sprintf("endpoints:\n  api:\n    url: %s/api\n%s", server.URL, endpoint)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, received
+}
+
+func TestHTTPRequest(t *testing.T) {
+	h, received := newHTTPTestTarget(t, func(w http.ResponseWriter, r *http.Request) {}, `
+    token: secret
+    headers:
+      X-Test: value
+`)
+
+	tests := []struct {
+		parameters  []interface{}
+		method      string
+		path        string
+		body        string
+		contenttype string
+	}{
+		{[]interface{}{"api", "GET"}, "GET", "/api", "", ""},
+		{[]interface{}{"api", "POST", "/lights/1", "on"}, "POST", "/api/lights/1", "on", ""},
+		{[]interface{}{"api", "PUT", "lights", map[string]interface{}{"level": 3}}, "PUT", "/api/lights", `{"level":3}`, "application/json"},
+		{[]interface{}{"api", "PATCH", "lights", []interface{}{1, "two"}}, "PATCH", "/api/lights", `[1,"two"]`, "application/json"},
+	}
+
+	for _, test := range tests {
+		err := h.CheckCommand("request", test.parameters)
+
+		if err == nil {
+			err = h.ExecuteCommand("request", test.parameters)
+		}
+
+		if err != nil {
+			t.Errorf("Request %v failed: %v", test.parameters, err)
+			continue
+		}
+
+		request := <-received
+
+		if request.method != test.method || request.path != test.path || request.body != test.body {
+			t.Errorf("Request %v received as %s %s %q", test.parameters, request.method, request.path, request.body)
+		}
+
+		if test.contenttype != "" && request.header.Get("Content-Type") != test.contenttype {
+			t.Errorf("Request %v received with content type %s", test.parameters, request.header.Get("Content-Type"))
+		}
+
+		if request.header.Get("Authorization") != "Bearer secret" || request.header.Get("X-Test") != "value" {
+			t.Errorf("Request %v received without configured headers: %v", test.parameters, request.header)
+		}
+	}
+
+	for _, parameters := range [][]interface{}{{"other", "GET"}, {"api", "FETCH"}} {
+		if h.CheckCommand("request", parameters) == nil {
+			t.Errorf("Invalid request %v accepted", parameters)
+		}
+	}
+}
+
+func TestHTTPBasicAuthentication(t *testing.T) {
+	h, received := newHTTPTestTarget(t, func(w http.ResponseWriter, r *http.Request) {}, `
+    username: user
+    password: pwd
+`)
+
+	err := h.ExecuteCommand("request", []interface{}{"api", "GET"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-received
+	username, password, ok := (&http.Request{Header: request.header}).BasicAuth()
+
+	if !ok || username != "user" || password != "pwd" {
+		t.Errorf("Basic authentication not received: %v", request.header)
+	}
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	h, _ := newHTTPTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no such light\n"))
+	}, "")
+
+	err := h.ExecuteCommand("request", []interface{}{"api", "GET", "lights/9"})
+
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "no such light") {
+		t.Errorf("Expected error with status and body, got %v", err)
+	}
+
+	response := h.GetState()["api"].(map[string]interface{})
+
+	if response["status"] != http.StatusNotFound {
+		t.Errorf("Status of failed request not reported in state: %v", response)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	h, _ := newHTTPTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}, "    timeout: 50ms\n")
+
+	start := time.Now()
+	err := h.ExecuteCommand("request", []interface{}{"api", "GET"})
+
+	if err == nil {
+		t.Errorf("Request did not time out")
+	}
+
+	if time.Since(start) > 400*time.Millisecond {
+		t.Errorf("Request timed out after %v", time.Since(start))
+	}
+}
+
+func TestHTTPResponseState(t *testing.T) {
+	h, _ := newHTTPTestTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/json" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(map[string]interface{}{"state": "on", "level": 3})
+			return
+		}
+		w.Write([]byte("plain"))
+	}, "")
+
+	if response := h.GetState()["api"].(map[string]interface{}); response["status"] != 0 {
+		t.Errorf("Unexpected state before first request: %v", response)
+	}
+
+	err := h.ExecuteCommand("request", []interface{}{"api", "GET", "json"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := h.GetState()["api"].(map[string]interface{})
+	body, _ := response["body"].(map[string]interface{})
+
+	if response["status"] != http.StatusOK || body["state"] != "on" || body["level"] != 3.0 {
+		t.Errorf("JSON response not decoded in state: %v", response)
+	}
+
+	err = h.ExecuteCommand("request", []interface{}{"api", "GET", "text"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := h.GetState()["api"].(map[string]interface{}); response["body"] != "plain" {
+		t.Errorf("Text response not reported in state: %v", response)
+	}
+}
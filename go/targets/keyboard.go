@@ -1,8 +1,13 @@
+//go:build !nokeyboard
+// +build !nokeyboard
+
 package targets
 
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"sync"
 
 	"github.com/micmonay/keybd_event"
 )
@@ -10,12 +15,102 @@ import (
 type keybdCommandTarget struct {
 	commandsMap *Map
 	kb          keybd_event.KeyBonding
+	mutex       sync.Mutex // keys are sent one command at a time
 }
 
 var keybdCommands = map[string]CommandDefinition{
 	"keypress": {
-		CheckFunc:   keySequenceCheck,
-		ExecuteFunc: keySequenceExec},
+		ExecuteFunc: keySequenceExec,
+		Description: "Emulates a key press, with optional modifiers",
+		Parameters: []ParameterDefinition{
+			{Name: "key", Type: "string", Description: "Key"},
+			{Name: "modifiers", Type: "string", Description: "Modifiers", Optional: true, Variadic: true}}},
+	"text": {
+		CheckFunc:   typeTextCheck,
+		ExecuteFunc: typeTextExec,
+		Description: "Types a string",
+		Parameters: []ParameterDefinition{
+			{Name: "text", Type: "string", Description: "Text to type"}}},
+}
+
+type textKey struct {
+	keycode int
+	shift   bool
+}
+
+// characters that can be typed by text command (US keyboard layout), letters and digits
+// are added from keyMap
+var textMap = map[rune]textKey{
+	' ':  {keybd_event.VK_SPACE, false},
+	'\n': {keybd_event.VK_ENTER, false},
+	'\t': {keybd_event.VK_TAB, false},
+	',':  {keybd_event.VK_COMMA, false},
+	'-':  {keybd_event.VK_MINUS, false},
+	'_':  {keybd_event.VK_MINUS, true},
+	'/':  {keybd_event.VK_SLASH, false},
+	'?':  {keybd_event.VK_SLASH, true},
+	';':  {keybd_event.VK_SEMICOLON, false},
+	':':  {keybd_event.VK_SEMICOLON, true},
+	'=':  {keybd_event.VK_EQUAL, false},
+	'+':  {keybd_event.VK_EQUAL, true},
+	// VK_SPn constants are punctuation keys, numbered by position, the grave accent key has a
+	// different number on macOS (see keyGrave)
+	'.':  {keybd_event.VK_SP10, false},
+	'>':  {keybd_event.VK_SP10, true},
+	'<':  {keybd_event.VK_SP9, true},
+	'\'': {keybd_event.VK_SP7, false},
+	'"':  {keybd_event.VK_SP7, true},
+	'`':  {keyGrave, false},
+	'~':  {keyGrave, true},
+	'[':  {keybd_event.VK_SP4, false},
+	'{':  {keybd_event.VK_SP4, true},
+	']':  {keybd_event.VK_SP5, false},
+	'}':  {keybd_event.VK_SP5, true},
+	'\\': {keybd_event.VK_SP8, false},
+	'|':  {keybd_event.VK_SP8, true},
+	'!':  {keybd_event.VK_1, true},
+	'@':  {keybd_event.VK_2, true},
+	'#':  {keybd_event.VK_3, true},
+	'$':  {keybd_event.VK_4, true},
+	'%':  {keybd_event.VK_5, true},
+	'^':  {keybd_event.VK_6, true},
+	'&':  {keybd_event.VK_7, true},
+	'*':  {keybd_event.VK_8, true},
+	'(':  {keybd_event.VK_9, true},
+	')':  {keybd_event.VK_0, true},
+}
+
+func init() {
+	Register("keyboard", func() CommandTarget { return new(keybdCommandTarget) }, keybdCommands)
+
+	// allowed values for keypress parameters
+	keypress := keybdCommands["keypress"]
+
+	for key := range keyMap {
+		keypress.Parameters[0].Enum = append(keypress.Parameters[0].Enum, key)
+	}
+
+	for modifier := range modifiersMap {
+		keypress.Parameters[1].Enum = append(keypress.Parameters[1].Enum, modifier)
+	}
+
+	sort.Strings(keypress.Parameters[0].Enum)
+	sort.Strings(keypress.Parameters[1].Enum)
+
+	for key, keycode := range keyMap {
+		if len(key) != 1 {
+			continue
+		}
+
+		c := rune(key[0])
+
+		textMap[c] = textKey{keycode, false}
+
+		if c >= 'A' && c <= 'Z' {
+			textMap[c] = textKey{keycode, true}
+			textMap[c-'A'+'a'] = textKey{keycode, false}
+		}
+	}
 }
 
 var keyMap = map[string]int{
@@ -87,33 +182,6 @@ var modifiersMap = map[string]string{
 	"super":       "HasSuper",
 }
 
-func keySequenceCheck(target interface{}, parameters []interface{}) error {
-
-	if len(parameters) < 1 {
-		return fmt.Errorf("Invalid parameters count for keypress command")
-	}
-
-	if _, ok := parameters[0].(string); !ok {
-		return fmt.Errorf("Invalid parameter type for keypress command")
-	}
-
-	if _, ok := keyMap[parameters[0].(string)]; !ok {
-		return fmt.Errorf("Invalid key value for keypress command")
-	}
-
-	for i := 1; i < len(parameters); i++ {
-		if _, ok := parameters[i].(string); !ok {
-			return fmt.Errorf("Invalid parameter type for keypress command")
-		}
-
-		if _, ok := modifiersMap[parameters[i].(string)]; !ok {
-			return fmt.Errorf("Invalid key modifier keypress command")
-		}
-	}
-
-	return nil
-}
-
 func keySequenceExec(target interface{}, parameters []interface{}) error {
 	keybd := target.(*keybdCommandTarget)
 
@@ -137,6 +205,37 @@ func keySequenceExec(target interface{}, parameters []interface{}) error {
 	return keybd.kb.Launching()
 }
 
+func typeTextCheck(target interface{}, parameters []interface{}) error {
+	for _, c := range parameters[0].(string) {
+		if _, ok := textMap[c]; !ok {
+			return fmt.Errorf("Character %q can't be typed by text command", c)
+		}
+	}
+
+	return nil
+}
+
+func typeTextExec(target interface{}, parameters []interface{}) error {
+	keybd := target.(*keybdCommandTarget)
+
+	text := parameters[0].(string)
+
+	for _, c := range text {
+		key := textMap[c]
+
+		keybd.kb.Clear()
+		keybd.kb.HasSHIFT(key.shift)
+		keybd.kb.SetKeys(key.keycode)
+
+		err := keybd.kb.Launching()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (keybd *keybdCommandTarget) Init(configyaml []byte) error {
 	err := error(nil)
 
@@ -152,5 +251,8 @@ func (keybd *keybdCommandTarget) CheckCommand(command string, parameters []inter
 }
 
 func (keybd *keybdCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	keybd.mutex.Lock()
+	defer keybd.mutex.Unlock()
+
 	return keybd.commandsMap.ExecuteCommand(command, parameters)
 }
@@ -0,0 +1,9 @@
+//go:build !nokeyboard
+// +build !nokeyboard
+
+package targets
+
+import "github.com/micmonay/keybd_event"
+
+// keyGrave is the grave accent (`) key, VK_SP1 is the key next to it on ISO keyboards
+const keyGrave = keybd_event.VK_SP12
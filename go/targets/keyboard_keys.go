@@ -0,0 +1,9 @@
+//go:build !darwin && !nokeyboard
+// +build !darwin,!nokeyboard
+
+package targets
+
+import "github.com/micmonay/keybd_event"
+
+// keyGrave is the grave accent (`) key, VK_SP12 is the additional key of ISO keyboards
+const keyGrave = keybd_event.VK_SP1
@@ -0,0 +1,34 @@
+//go:build !nokeyboard
+// +build !nokeyboard
+
+package targets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypeTextCheck(t *testing.T) {
+	now := time.Now()
+
+	valid := []string{
+		"Recording started at " + now.String(),
+		"Recording started at " + now.Format("15:04"),
+		"https://example.com/path?a=1&b=2#top",
+		`Punctuation: .,;:'"!?()[]{}<>@$%^*~|\` + "`",
+	}
+
+	for _, text := range valid {
+		err := typeTextCheck(nil, []interface{}{text})
+
+		if err != nil {
+			t.Errorf("Text %q not accepted: %v", text, err)
+		}
+	}
+
+	err := typeTextCheck(nil, []interface{}{"Caffè"})
+
+	if err == nil {
+		t.Errorf("Text with non-US characters accepted")
+	}
+}
@@ -1,100 +1,362 @@
+//go:build !noobs
+// +build !noobs
+
 package targets
 
 import (
+	"context"
 	"fmt"
+	"keypad/obsws5"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
-	obsws "github.com/christopher-dG/go-obs-websocket"
 	"gopkg.in/yaml.v3"
 )
 
-type obsCommand struct {
-	Command    string
-	Parameters []interface{}
-}
-
 type obsCommandTarget struct {
-	client           obsws.Client
-	quitflag         bool
-	sceneCollections []string
-	activeCollection string
-	scenes           []string
-	activeScene      string
-	commands         chan obsCommand
-	errors           chan error
-	commandsMap      *Map
-	streaming        bool
-	recording        bool
-	recordingPaused  bool
+	client            obsClient
+	protocol          int // obs-websocket protocol version
+	quitflag          bool
+	sceneCollections  []string
+	activeCollection  string
+	scenes            []string
+	activeScene       string
+	commandsMap       *Map
+	streaming         bool
+	recording         bool
+	recordingPaused   bool
+	replayBuffer      bool
+	virtualCam        bool
+	lastReplay        string                    // path of the last replay saved
+	sceneItems        map[string][]obsSceneItem // by scene name, nil until read from OBS
+	inputs            map[string]*obsInput      // audio inputs by name, nil until read from OBS
+	inputKinds        map[string]string         // kind of all the inputs by name, nil until read from OBS
+	studioMode        bool
+	previewScene      string
+	transitions       []string       // nil until read from OBS
+	hotkeys           []string       // nil until read from OBS
+	transition        obsTransition  // current transition
+	restoreTransition *obsTransition // transition active before a quick transition
+	connection        string         // connection state: disconnected, connecting or connected
+	reconnectAttempts int            // failed connection attempts since last connection
+	reconnectDelay    time.Duration  // delay before next connection attempt
+	lastError         string         // last connection error
+	offlinePolicy     string         // policy for commands sent while OBS is not connected
+	offlineCommands   map[string]string
+	queueTimeout      time.Duration
+	pending           []obsPendingCommand // commands queued while OBS is not connected
+	stats             obsStats
+	statsInterval     time.Duration       // 0 if statistics are not read
+	checkedCommands   []obsCheckedCommand // commands checked before OBS state was available
+	stateRead         bool                // OBS state has been read at least once
+	mutex             sync.RWMutex        // protects state, updated by OBS events
 }
 
 type obsCommandTargetConfig struct {
 	Host     string
-	Port     int16
+	Port     int
 	Password string
+	Protocol int // obs-websocket protocol version, 4 (default) or 5
+	// policy for commands sent while OBS is not connected: fail (default), queue or drop
+	Offline         string
+	OfflineCommands map[string]string // policies of specific commands, by command name
+	QueueTimeout    interface{}       // maximum time a queued command waits for OBS connection
+	Timeout         interface{}       // maximum time waiting for the response to a request
+	StatsInterval   interface{}       // interval between reads of statistics, 0 disables them
 }
 
-var obsCommands = map[string]CommandDefinition{
-	"activatescene": {
-		CheckFunc:   activateSceneCheck,
-		ExecuteFunc: activateSceneExec},
-	"prevscene": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: prevSceneExec},
-	"nextscene": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: nextSceneExec},
-	"activatescenecollection": {
-		CheckFunc:   activateSceneCheck,
-		ExecuteFunc: activateSceneCollectionExec},
-	"prevscenecollection": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: prevSceneCollectionExec},
-	"nextscenecollection": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: nextSceneCollectionExec},
-	"startrecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: startRecordingExec},
-	"stoprecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: stopRecordingExec},
-	"togglerecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: toggleRecordingExec},
-	"pauserecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: pauseRecordingExec},
-	"resumerecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: resumeRecordingExec},
-	"togglepauserecording": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: togglePauseRecordingExec},
-	"startstreaming": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: startStreamingExec},
-	"stopstreaming": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: stopStreamingExec},
-	"togglestreaming": {
-		CheckFunc:   NoParmsCheck,
-		ExecuteFunc: toggleStreamingExec},
-}
-
-func activateSceneCheck(target interface{}, parameters []interface{}) error {
-
-	if len(parameters) != 1 {
-		return fmt.Errorf("Invalid parameters count for activateScene command")
-	}
-
-	if _, ok := parameters[0].(string); !ok {
-		return fmt.Errorf("Invalid parameter type for activateScene command")
-	}
+// obsCheckedCommand is a command checked while OBS state was not available, so its check is
+// repeated after the first connection
+type obsCheckedCommand struct {
+	command    string
+	parameters []interface{}
+}
 
-	return nil
+// connection is checked every second and OBS is pinged every obsPingInterval seconds,
+// so a stalled connection is closed and pending requests fail. The ping does not use the
+// request timeout, that can be longer to allow for slow commands
+const obsPingInterval = 5
+const obsPingTimeout = 3 * time.Second
+
+const obsMaxCheckedCommands = 10000
+
+var obsCommands = map[string]CommandDefinition{
+	"activateScene": {
+		ExecuteFunc: activateSceneExec,
+		Description: "Activates a scene, use <collection>.<scene> to activate a scene in a different collection",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Scene name"}}},
+	"prevScene": {
+		ExecuteFunc: prevSceneExec,
+		Description: "Moves to the previous scene (in preview, if studio mode is enabled)"},
+	"nextScene": {
+		ExecuteFunc: nextSceneExec,
+		Description: "Moves to the next scene (in preview, if studio mode is enabled)"},
+	"activateSceneCollection": {
+		ExecuteFunc: activateSceneCollectionExec,
+		Description: "Activates a scene collection",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Scene collection name"}}},
+	"prevSceneCollection": {
+		ExecuteFunc: prevSceneCollectionExec,
+		Description: "Moves to the previous scene collection"},
+	"nextSceneCollection": {
+		ExecuteFunc: nextSceneCollectionExec,
+		Description: "Moves to the next scene collection"},
+	"startRecording": {
+		ExecuteFunc: startRecordingExec,
+		Description: "Starts recording"},
+	"stopRecording": {
+		ExecuteFunc: stopRecordingExec,
+		Description: "Stops recording"},
+	"toggleRecording": {
+		ExecuteFunc: toggleRecordingExec,
+		Description: "Starts or stops recording, depending on current state"},
+	"pauseRecording": {
+		ExecuteFunc: pauseRecordingExec,
+		Description: "Pauses recording"},
+	"resumeRecording": {
+		ExecuteFunc: resumeRecordingExec,
+		Description: "Resumes recording"},
+	"togglePauseRecording": {
+		ExecuteFunc: togglePauseRecordingExec,
+		Description: "Pauses or resumes recording, depending on current state"},
+	"startStreaming": {
+		ExecuteFunc: startStreamingExec,
+		Description: "Starts streaming"},
+	"stopStreaming": {
+		ExecuteFunc: stopStreamingExec,
+		Description: "Stops streaming"},
+	"toggleStreaming": {
+		ExecuteFunc: toggleStreamingExec,
+		Description: "Starts or stops streaming, depending on current state"},
+	"showSource": {
+		CheckFunc:   obsSourceCheck(1),
+		ExecuteFunc: showSourceExec,
+		Description: "Shows a source in a scene",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"hideSource": {
+		CheckFunc:   obsSourceCheck(1),
+		ExecuteFunc: hideSourceExec,
+		Description: "Hides a source in a scene",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"toggleSource": {
+		CheckFunc:   obsSourceCheck(1),
+		ExecuteFunc: toggleSourceExec,
+		Description: "Shows or hides a source in a scene, depending on current state",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"setSourcePosition": {
+		CheckFunc:   obsSourceCheck(3),
+		ExecuteFunc: setSourcePositionExec,
+		Description: "Moves a source in a scene",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "x", Type: "number", Description: "Horizontal position in pixels"},
+			{Name: "y", Type: "number", Description: "Vertical position in pixels"},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"setSourceScale": {
+		CheckFunc:   obsSourceCheck(3),
+		ExecuteFunc: setSourceScaleExec,
+		Description: "Scales a source in a scene",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "x", Type: "number", Description: "Horizontal scale factor (1 is original size)", Min: Limit(0)},
+			{Name: "y", Type: "number", Description: "Vertical scale factor (1 is original size)", Min: Limit(0)},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"setSourceCrop": {
+		CheckFunc:   obsSourceCheck(5),
+		ExecuteFunc: setSourceCropExec,
+		Description: "Crops a source in a scene",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source name"},
+			{Name: "left", Type: "integer", Description: "Pixels cropped from the left side", Min: Limit(0)},
+			{Name: "top", Type: "integer", Description: "Pixels cropped from the top side", Min: Limit(0)},
+			{Name: "right", Type: "integer", Description: "Pixels cropped from the right side", Min: Limit(0)},
+			{Name: "bottom", Type: "integer", Description: "Pixels cropped from the bottom side", Min: Limit(0)},
+			{Name: "scene", Type: "string", Description: "Scene name (default is current scene)", Optional: true}}},
+	"setMute": {
+		CheckFunc:   obsInputCheck,
+		ExecuteFunc: setMuteExec,
+		Description: "Mutes or unmutes an audio input",
+		Parameters: []ParameterDefinition{
+			{Name: "input", Type: "string", Description: "Input name"},
+			{Name: "muted", Type: "boolean", Description: "true to mute the input, false to unmute it"}}},
+	"toggleMute": {
+		CheckFunc:   obsInputCheck,
+		ExecuteFunc: toggleMuteExec,
+		Description: "Mutes or unmutes an audio input, depending on current state",
+		Parameters: []ParameterDefinition{
+			{Name: "input", Type: "string", Description: "Input name"}}},
+	"setVolume": {
+		CheckFunc:   obsInputCheck,
+		ExecuteFunc: setVolumeExec,
+		Description: "Sets the volume of an audio input",
+		Parameters: []ParameterDefinition{
+			{Name: "input", Type: "string", Description: "Input name"},
+			{Name: "volume", Type: "number", Description: "Volume in dB (0 is original volume)", Min: Limit(obsMinVolume), Max: Limit(obsMaxVolume)}}},
+	"adjustVolume": {
+		CheckFunc:   obsInputCheck,
+		ExecuteFunc: adjustVolumeExec,
+		Description: "Increases or decreases the volume of an audio input",
+		Parameters: []ParameterDefinition{
+			{Name: "input", Type: "string", Description: "Input name"},
+			{Name: "step", Type: "number", Description: "Volume change in dB, negative values decrease volume"}}},
+	"setMonitorType": {
+		CheckFunc:   obsInputCheck,
+		ExecuteFunc: setMonitorTypeExec,
+		Description: "Sets audio monitoring of an input",
+		Parameters: []ParameterDefinition{
+			{Name: "input", Type: "string", Description: "Input name"},
+			{Name: "type", Type: "string", Description: "Monitoring type", Enum: []string{"none", "monitorOnly", "monitorAndOutput"}}}},
+	"enableStudioMode": {
+		ExecuteFunc: enableStudioModeExec,
+		Description: "Enables studio mode"},
+	"disableStudioMode": {
+		ExecuteFunc: disableStudioModeExec,
+		Description: "Disables studio mode"},
+	"toggleStudioMode": {
+		ExecuteFunc: toggleStudioModeExec,
+		Description: "Enables or disables studio mode, depending on current state"},
+	"setPreviewScene": {
+		ExecuteFunc: setPreviewSceneExec,
+		Description: "Sets the scene in preview, in studio mode",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Scene name"}}},
+	"prevPreviewScene": {
+		ExecuteFunc: prevPreviewSceneExec,
+		Description: "Moves preview to the previous scene, in studio mode"},
+	"nextPreviewScene": {
+		ExecuteFunc: nextPreviewSceneExec,
+		Description: "Moves preview to the next scene, in studio mode"},
+	"transitionToProgram": {
+		ExecuteFunc: transitionToProgramExec,
+		Description: "Moves the scene in preview to program, in studio mode"},
+	"setTransition": {
+		CheckFunc:   obsTransitionCheck,
+		ExecuteFunc: setTransitionExec,
+		Description: "Sets the current transition",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Transition name"},
+			{Name: "duration", Type: "duration", Description: "Transition duration (ex: 500ms) or number of milliseconds", Optional: true}}},
+	"setTransitionDuration": {
+		ExecuteFunc: setTransitionDurationExec,
+		Description: "Sets the duration of the current transition",
+		Parameters: []ParameterDefinition{
+			{Name: "duration", Type: "duration", Description: "Transition duration (ex: 500ms) or number of milliseconds"}}},
+	"quickTransition": {
+		CheckFunc:   obsTransitionCheck,
+		ExecuteFunc: quickTransitionExec,
+		Description: "Moves the scene in preview to program using a specific transition, in studio mode",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Transition name"},
+			{Name: "duration", Type: "duration", Description: "Transition duration (ex: 500ms) or number of milliseconds", Optional: true}}},
+	"setText": {
+		CheckFunc:   setTextCheck,
+		ExecuteFunc: setTextExec,
+		Description: "Sets the text of a text source (GDI+ or FreeType 2)",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Text source name"},
+			{Name: "text", Type: "any", Description: "Text"}}},
+	"enableFilter": {
+		CheckFunc:   filterCheck,
+		ExecuteFunc: enableFilterExec,
+		Description: "Enables a filter of a source",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source or scene name"},
+			{Name: "filter", Type: "string", Description: "Filter name"}}},
+	"disableFilter": {
+		CheckFunc:   filterCheck,
+		ExecuteFunc: disableFilterExec,
+		Description: "Disables a filter of a source",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source or scene name"},
+			{Name: "filter", Type: "string", Description: "Filter name"}}},
+	"toggleFilter": {
+		CheckFunc:   filterCheck,
+		ExecuteFunc: toggleFilterExec,
+		Description: "Enables or disables a filter of a source, depending on current state",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Source or scene name"},
+			{Name: "filter", Type: "string", Description: "Filter name"}}},
+	"triggerHotkey": {
+		CheckFunc:   triggerHotkeyCheck,
+		ExecuteFunc: triggerHotkeyExec,
+		Description: "Triggers an OBS hotkey by name",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Hotkey name (ex: OBSBasic.StartRecording)"}}},
+	"triggerKeySequence": {
+		ExecuteFunc: triggerKeySequenceExec,
+		Description: "Triggers the OBS hotkeys associated to a key combination",
+		Parameters: []ParameterDefinition{
+			{Name: "key", Type: "string", Description: "Key (ex: F13 or OBS_KEY_F13)"},
+			{Name: "modifiers", Type: "string", Description: "Modifier keys", Optional: true, Variadic: true, Enum: obsKeyModifiers}}},
+	"playMedia": {
+		CheckFunc:   mediaCheck,
+		ExecuteFunc: playMediaExec,
+		Description: "Starts playback of a media source",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Media source name"}}},
+	"pauseMedia": {
+		CheckFunc:   mediaCheck,
+		ExecuteFunc: pauseMediaExec,
+		Description: "Pauses playback of a media source",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Media source name"}}},
+	"restartMedia": {
+		CheckFunc:   mediaCheck,
+		ExecuteFunc: restartMediaExec,
+		Description: "Restarts playback of a media source from the beginning",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Media source name"}}},
+	"stopMedia": {
+		CheckFunc:   mediaCheck,
+		ExecuteFunc: stopMediaExec,
+		Description: "Stops playback of a media source",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Media source name"}}},
+	"seekMedia": {
+		CheckFunc:   mediaCheck,
+		ExecuteFunc: seekMediaExec,
+		Description: "Moves playback of a media source to a position",
+		Parameters: []ParameterDefinition{
+			{Name: "source", Type: "string", Description: "Media source name"},
+			{Name: "position", Type: "duration", Description: "Position from the beginning (ex: 1m30s) or number of milliseconds"}}},
+	"startReplayBuffer": {
+		ExecuteFunc: startReplayBufferExec,
+		Description: "Starts the replay buffer"},
+	"stopReplayBuffer": {
+		ExecuteFunc: stopReplayBufferExec,
+		Description: "Stops the replay buffer"},
+	"toggleReplayBuffer": {
+		ExecuteFunc: toggleReplayBufferExec,
+		Description: "Starts or stops the replay buffer, depending on current state"},
+	"saveReplayBuffer": {
+		ExecuteFunc: saveReplayBufferExec,
+		Description: "Saves the content of the replay buffer to a file"},
+	"startVirtualCam": {
+		ExecuteFunc: startVirtualCamExec,
+		Description: "Starts the virtual camera"},
+	"stopVirtualCam": {
+		ExecuteFunc: stopVirtualCamExec,
+		Description: "Stops the virtual camera"},
+	"toggleVirtualCam": {
+		ExecuteFunc: toggleVirtualCamExec,
+		Description: "Starts or stops the virtual camera, depending on current state"},
+	"saveScreenshot": {
+		CheckFunc:   saveScreenshotCheck,
+		ExecuteFunc: saveScreenshotExec,
+		Description: "Saves a screenshot of a source to a file, on the machine running OBS",
+		Parameters: []ParameterDefinition{
+			{Name: "path", Type: "string", Description: "Absolute path of the image, its extension selects the format (ex: png, jpg)"},
+			{Name: "source", Type: "string", Description: "Source or scene name (default is current scene)", Optional: true}}},
 }
 
 func activateSceneExec(target interface{}, parameters []interface{}) error {
@@ -124,22 +386,12 @@ func activateSceneExec(target interface{}, parameters []interface{}) error {
 
 func prevSceneExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	index := obs.getSceneIndex(obs.activeScene)
-	index = index - 1
-	if index < 0 {
-		index = len(obs.scenes) - 1
-	}
-	return obs.activateScene(obs.scenes[index])
+	return obs.moveScene(-1)
 }
 
 func nextSceneExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	index := obs.getSceneIndex(obs.activeScene)
-	index = index + 1
-	if index >= len(obs.scenes) {
-		index = 0
-	}
-	return obs.activateScene(obs.scenes[index])
+	return obs.moveScene(1)
 }
 
 func activateSceneCollectionExec(target interface{}, parameters []interface{}) error {
@@ -151,22 +403,28 @@ func activateSceneCollectionExec(target interface{}, parameters []interface{}) e
 
 func prevSceneCollectionExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	index := obs.getSceneCollectionIndex(obs.activeCollection)
+	obs.mutex.RLock()
+	index := indexOf(obs.sceneCollections, obs.activeCollection)
 	index = index - 1
 	if index < 0 {
 		index = len(obs.sceneCollections) - 1
 	}
-	return obs.activateSceneCollection(obs.sceneCollections[index])
+	collection := itemAt(obs.sceneCollections, index)
+	obs.mutex.RUnlock()
+	return obs.activateSceneCollection(collection)
 }
 
 func nextSceneCollectionExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	index := obs.getSceneCollectionIndex(obs.activeCollection)
+	obs.mutex.RLock()
+	index := indexOf(obs.sceneCollections, obs.activeCollection)
 	index = index + 1
 	if index >= len(obs.sceneCollections) {
 		index = 0
 	}
-	return obs.activateSceneCollection(obs.sceneCollections[index])
+	collection := itemAt(obs.sceneCollections, index)
+	obs.mutex.RUnlock()
+	return obs.activateSceneCollection(collection)
 }
 
 func startRecordingExec(target interface{}, parameters []interface{}) error {
@@ -181,7 +439,7 @@ func stopRecordingExec(target interface{}, parameters []interface{}) error {
 
 func toggleRecordingExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	if obs.recording {
+	if obs.getFlag(&obs.recording) {
 		return obs.stopRecording()
 	}
 	return obs.startRecording()
@@ -199,7 +457,7 @@ func resumeRecordingExec(target interface{}, parameters []interface{}) error {
 
 func togglePauseRecordingExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	if obs.recordingPaused {
+	if obs.getFlag(&obs.recordingPaused) {
 		return obs.resumeRecording()
 	}
 	return obs.pauseRecording()
@@ -217,36 +475,106 @@ func stopStreamingExec(target interface{}, parameters []interface{}) error {
 
 func toggleStreamingExec(target interface{}, parameters []interface{}) error {
 	obs := target.(*obsCommandTarget)
-	if obs.streaming {
+	if obs.getFlag(&obs.streaming) {
 		return obs.stopStreaming()
 	}
 	return obs.startStreaming()
 }
 
+// CheckCommand validates a command, names of scenes, sources and other OBS objects can be checked
+// only when OBS is connected, so commands checked before are checked again after the connection
 func (obs *obsCommandTarget) CheckCommand(command string, parameters []interface{}) error {
-	return obs.commandsMap.CheckCommand(command, parameters)
+	err := obs.commandsMap.CheckCommand(command, parameters)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	// templated commands are checked again when executed, the limit protects from commands
+	// checked at execution time, while OBS has never been connected
+	if !obs.stateRead && !hasTemplates(parameters) && len(obs.checkedCommands) < obsMaxCheckedCommands {
+		obs.checkedCommands = append(obs.checkedCommands, obsCheckedCommand{command: command, parameters: parameters})
+	}
+	return nil
+}
+
+// recheckCommands repeats the checks of the commands loaded before OBS state was read, reporting
+// unknown objects as warnings, since they can be created later
+func (obs *obsCommandTarget) recheckCommands() {
+	obs.mutex.Lock()
+	checked := obs.checkedCommands
+	obs.checkedCommands = nil
+	obs.stateRead = true
+	obs.mutex.Unlock()
+
+	for _, command := range checked {
+		err := obs.commandsMap.CheckCommand(command.command, command.parameters)
+
+		if err != nil {
+			log.Printf("Warning: %v in OBS command %s", err, command.command)
+		}
+	}
 }
 
 func (obs *obsCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
-	if !obs.client.Connected() {
-		return fmt.Errorf("OBS winsock connection is not active")
+	if offline, err := obs.offlineCommand(command, parameters); offline {
+		return err
 	}
 
-	var cmd = obsCommand{
-		Command:    command,
-		Parameters: parameters,
+	// commands are executed by the calling goroutine, requests time out if OBS does not respond
+	return obs.commandsMap.ExecuteCommand(command, parameters)
+}
+
+func (obs *obsCommandTarget) GetState() map[string]interface{} {
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	muted, volumes := obs.inputsState()
+
+	state := map[string]interface{}{
+		"connected":          obs.connection == obsConnected,
+		"connection":         obs.connection,
+		"reconnectAttempts":  obs.reconnectAttempts,
+		"lastError":          obs.lastError,
+		"pendingCommands":    len(obs.pending),
+		"sceneCollections":   append([]string(nil), obs.sceneCollections...),
+		"activeCollection":   obs.activeCollection,
+		"scenes":             append([]string(nil), obs.scenes...),
+		"activeScene":        obs.activeScene,
+		"streaming":          obs.streaming,
+		"recording":          obs.recording,
+		"recordingPaused":    obs.recordingPaused,
+		"sceneItems":         obs.sceneItemsState(),
+		"sceneSources":       obs.sceneSourcesState(),
+		"muted":              muted,
+		"volumes":            volumes,
+		"replayBuffer":       obs.replayBuffer,
+		"virtualCam":         obs.virtualCam,
+		"lastReplay":         obs.lastReplay,
+		"studioMode":         obs.studioMode,
+		"previewScene":       obs.previewScene,
+		"transitions":        append([]string(nil), obs.transitions...),
+		"transition":         obs.transition.name,
+		"transitionDuration": obs.transition.duration,
 	}
 
-	obs.commands <- cmd
-	return <-obs.errors
+	obs.statsState(state)
+	return state
+}
+
+func init() {
+	Register("obs", func() CommandTarget { return new(obsCommandTarget) }, obsCommands)
 }
 
 func (obs *obsCommandTarget) Init(configyaml []byte) error {
 
 	cfg := obsCommandTargetConfig{
-		Port:     4444,
 		Host:     "localhost",
 		Password: "",
+		Protocol: 4,
 	}
 
 	err := yaml.Unmarshal(configyaml, &cfg)
@@ -256,173 +584,189 @@ func (obs *obsCommandTarget) Init(configyaml []byte) error {
 		return err
 	}
 
-	obs.commandsMap = new(Map)
-
-	obs.commandsMap.Init(obs, obsCommands)
-
-	obs.client.Host = cfg.Host
-	obs.client.Port = int(cfg.Port)
-	if cfg.Password != "" {
-		obs.client.Password = cfg.Password
+	if cfg.Protocol != 4 && cfg.Protocol != 5 {
+		return fmt.Errorf("invalid OBS websocket protocol version %d", cfg.Protocol)
 	}
 
-	obs.quitflag = false
-	obs.commands = make(chan obsCommand)
-	obs.errors = make(chan error)
+	// default ports used by OBS for each protocol version
+	if cfg.Port == 0 {
+		cfg.Port = 4444
 
-	obs.recording = false
-	obs.streaming = false
-	obs.recordingPaused = false
+		if cfg.Protocol == 5 {
+			cfg.Port = 4455
+		}
+	}
 
-	go obs.manageWebSockCommunication()
+	err = obs.initOfflinePolicies(cfg)
 
-	return nil
-}
+	if err != nil {
+		return err
+	}
 
-func (obs *obsCommandTarget) onSwitchScenes(e obsws.Event) {
-	se := e.(obsws.SwitchScenesEvent)
+	obs.protocol = cfg.Protocol
+	obs.commandsMap = new(Map)
 
-	obs.activeScene = se.SceneName
-}
+	obs.commandsMap.Init(obs, obsCommands)
 
-func (obs *obsCommandTarget) onScenesChanged(e obsws.Event) {
-	se := e.(obsws.ScenesChangedEvent)
+	timeout := obsws5.DefaultTimeout
 
-	obs.scenes = make([]string, len(se.Scenes))
+	if cfg.Timeout != nil {
+		timeout, err = ParseDuration(cfg.Timeout)
 
-	for index, s := range se.Scenes {
-		obs.scenes[index] = s.Name
+		if err != nil {
+			return err
+		}
 	}
-}
 
-func (obs *obsCommandTarget) onScenesCollectionChanged(e obsws.Event) {
-	se := e.(obsws.SceneCollectionChangedEvent)
+	obs.statsInterval = obsDefaultStatsInterval
 
-	obs.activeCollection = se.SceneCollection
-	obs.refreshScenes()
-}
+	if cfg.StatsInterval != nil {
+		obs.statsInterval, err = ParseDuration(cfg.StatsInterval)
 
-func (obs *obsCommandTarget) onSceneCollectionListChanged(_ obsws.Event) {
-	obs.refreshSceneCollections()
-}
-
-func (obs *obsCommandTarget) onRecordingStarting(_ obsws.Event) {
-	obs.recording = true
-	obs.recordingPaused = false
-}
+		if err != nil {
+			return err
+		}
+	}
 
-func (obs *obsCommandTarget) onRecordingStopping(_ obsws.Event) {
-	obs.recording = false
-	obs.recordingPaused = false
-}
+	obs.client = newOBSClient(cfg, timeout)
+	obs.client.SetEventHandler(obs.onEvent)
 
-func (obs *obsCommandTarget) onRecordingPaused(_ obsws.Event) {
-	obs.recordingPaused = true
-}
+	obs.quitflag = false
+	obs.connection = obsDisconnected
 
-func (obs *obsCommandTarget) onRecordingResumed(_ obsws.Event) {
+	obs.recording = false
+	obs.streaming = false
 	obs.recordingPaused = false
-}
 
-func (obs *obsCommandTarget) onStreamingStarting(_ obsws.Event) {
-	obs.streaming = true
-}
+	go obs.manageWebSockCommunication()
 
-func (obs *obsCommandTarget) onStreamingStopping(_ obsws.Event) {
-	obs.streaming = false
+	return nil
 }
 
-func (obs *obsCommandTarget) createEventHandlers() {
-	obs.client.AddEventHandler("SwitchScenes", obs.onSwitchScenes)
-	obs.client.AddEventHandler("ScenesChanged", obs.onScenesChanged)
-	obs.client.AddEventHandler("SceneCollectionChanged", obs.onScenesCollectionChanged)
-	obs.client.AddEventHandler("SceneCollectionListChanged", obs.onSceneCollectionListChanged)
-	obs.client.AddEventHandler("RecordingStarting", obs.onRecordingStarting)
-	obs.client.AddEventHandler("RecordingStopping", obs.onRecordingStopping)
-	obs.client.AddEventHandler("RecordingPaused", obs.onRecordingPaused)
-	obs.client.AddEventHandler("RecordingResumed", obs.onRecordingResumed)
-	obs.client.AddEventHandler("StreamStarting", obs.onStreamingStarting)
-	obs.client.AddEventHandler("StreamStopping", obs.onStreamingStopping)
+// onEvent updates state when OBS reports a change
+func (obs *obsCommandTarget) onEvent(eventType string, data map[string]interface{}) {
+	switch eventType {
+	case "CurrentProgramSceneChanged":
+		obs.mutex.Lock()
+		obs.activeScene = obsString(data, "sceneName")
+		obs.mutex.Unlock()
+	case "SceneListChanged":
+		obs.mutex.Lock()
+		obs.scenes = obsSceneNames(data)
+		obs.mutex.Unlock()
+	case "CurrentSceneCollectionChanged":
+		obs.mutex.Lock()
+		obs.activeCollection = obsString(data, "sceneCollectionName")
+		obs.mutex.Unlock()
+
+		obs.refreshScenes()
+	case "SceneCollectionListChanged":
+		obs.mutex.Lock()
+		obs.sceneCollections = obsStrings(data, "sceneCollections", "")
+		obs.mutex.Unlock()
+	case "RecordStateChanged":
+		obs.mutex.Lock()
+		obs.recording = obsBool(data, "outputActive")
+
+		switch obsString(data, "outputState") {
+		case "OBS_WEBSOCKET_OUTPUT_PAUSED":
+			obs.recordingPaused = true
+		case "OBS_WEBSOCKET_OUTPUT_RESUMED", "OBS_WEBSOCKET_OUTPUT_STARTED", "OBS_WEBSOCKET_OUTPUT_STOPPED":
+			obs.recordingPaused = false
+		}
+		obs.mutex.Unlock()
+	case "StreamStateChanged":
+		obs.mutex.Lock()
+		obs.streaming = obsBool(data, "outputActive")
+		obs.mutex.Unlock()
+	default:
+		obs.onSceneItemEvent(eventType, data)
+		obs.onInputEvent(eventType, data)
+		obs.onStudioModeEvent(eventType, data)
+		obs.onOutputEvent(eventType, data)
+	}
 }
 
 func (obs *obsCommandTarget) refreshScenes() error {
+	obs.mutex.Lock()
 	obs.activeScene = ""
+	obs.mutex.Unlock()
 
-	slreq := obsws.NewGetSceneListRequest()
-	slresp, err := slreq.SendReceive(obs.client)
+	slresp, err := obs.client.Request("GetSceneList", nil)
 
 	if err != nil {
 		return err
 	}
 
-	obs.scenes = make([]string, len(slresp.Scenes))
+	scenes := obsSceneNames(slresp)
 
-	obs.activeScene = slresp.CurrentScene
-
-	for index, scene := range slresp.Scenes {
-		obs.scenes[index] = scene.Name
-	}
+	obs.mutex.Lock()
+	obs.scenes = scenes
+	obs.activeScene = obsString(slresp, "currentProgramSceneName")
+	obs.mutex.Unlock()
 
-	return nil
+	return obs.refreshSceneItems(scenes, true)
 }
 
 func (obs *obsCommandTarget) refreshSceneCollections() error {
 
+	obs.mutex.Lock()
 	obs.activeCollection = ""
 	obs.activeScene = ""
+	obs.mutex.Unlock()
 
-	lscreq := obsws.NewListSceneCollectionsRequest()
-
-	lscresp, err := lscreq.SendReceive(obs.client)
-
-	if err != nil {
-		return err
-	}
-
-	obs.sceneCollections = make([]string, len(lscresp.SceneCollections))
-
-	for index, collection := range lscresp.SceneCollections {
-		obs.sceneCollections[index] = collection.Name
-	}
-
-	gcsreq := obsws.NewGetCurrentSceneCollectionRequest()
-
-	gcsresp, err := gcsreq.SendReceive(obs.client)
+	lscresp, err := obs.client.Request("GetSceneCollectionList", nil)
 
 	if err != nil {
 		return err
 	}
 
-	obs.activeCollection = gcsresp.ScName
+	obs.mutex.Lock()
+	obs.sceneCollections = obsStrings(lscresp, "sceneCollections", "")
+	obs.activeCollection = obsString(lscresp, "currentSceneCollectionName")
+	obs.mutex.Unlock()
 
 	return obs.refreshScenes()
 }
 
 func (obs *obsCommandTarget) refreshOBSState() error {
 
-	ssreq := obsws.NewGetStreamingStatusRequest()
-
-	ssresp, err := ssreq.SendReceive(obs.client)
+	responses, err := obs.client.RequestBatch([]obsws5.Request{
+		{Type: "GetStreamStatus"},
+		{Type: "GetRecordStatus"},
+	}, true)
 
 	if err != nil {
 		return err
 	}
 
-	obs.streaming = ssresp.Streaming
-	obs.recording = ssresp.Recording
-	return nil
-}
+	for _, response := range responses {
+		if response.Err != nil {
+			return response.Err
+		}
+	}
 
-func (obs *obsCommandTarget) processCommand(command obsCommand) error {
-	return obs.commandsMap.ExecuteCommand(command.Command, command.Parameters)
+	if len(responses) != 2 {
+		return fmt.Errorf("OBS returned %d results for stream and recording status requests", len(responses))
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	obs.streaming = obsBool(responses[0].Data, "outputActive")
+	obs.recording = obsBool(responses[1].Data, "outputActive")
+	obs.recordingPaused = obsBool(responses[1].Data, "outputPaused")
+	return nil
 }
 
 func (obs *obsCommandTarget) pingObs() bool {
-	gvreq := obsws.NewGetVersionRequest()
+	ctx, cancel := context.WithTimeout(context.Background(), obsPingTimeout)
+	defer cancel()
 
-	_, err := gvreq.SendReceive(obs.client)
+	_, err := obs.client.RequestContext(ctx, "GetVersion", nil)
 
 	if err != nil {
+		log.Printf("Error %v pinging OBS, closing connection", err)
 		return false
 	}
 	return true
@@ -430,177 +774,250 @@ func (obs *obsCommandTarget) pingObs() bool {
 
 func (obs *obsCommandTarget) manageWebSockCommunication() {
 	for !obs.quitflag {
-		if obs.client.Connected() {
-			obs.client.Disconnect()
-		}
+		obs.client.Disconnect()
+
+		obs.mutex.Lock()
+		obs.setConnectionState(obsConnecting, nil)
+		obs.mutex.Unlock()
 
 		err := obs.client.Connect()
 
 		if err != nil {
-			time.Sleep(time.Second)
+			time.Sleep(obs.connectionFailed(err))
 			continue
 		}
 
 		err = obs.refreshSceneCollections()
+
 		if err != nil {
-			log.Print(err)
+			time.Sleep(obs.connectionFailed(err))
 			continue
 		}
 
 		err = obs.refreshOBSState()
 
 		if err != nil {
-			log.Print(err)
+			time.Sleep(obs.connectionFailed(err))
 			continue
 		}
 
-		obs.createEventHandlers()
+		// audio inputs are not required by other commands, failures are not fatal
+		err = obs.refreshInputs()
 
-		loop := true
+		if err != nil {
+			log.Printf("Error %v reading OBS audio inputs", err)
+		}
 
-		for loop {
-			select {
-			case commandMsg := <-obs.commands:
-				obs.errors <- obs.processCommand(commandMsg)
-			case <-time.After(time.Second * 5):
-				if !obs.pingObs() {
-					loop = false
-				}
-			}
+		err = obs.refreshStudioMode()
+
+		if err != nil {
+			log.Printf("Error %v reading OBS studio mode state", err)
 		}
 
-		if obs.client.Connected() {
-			obs.client.Disconnect()
+		err = obs.refreshOutputs()
+
+		if err != nil {
+			log.Printf("Error %v reading OBS outputs state", err)
 		}
-	}
 
-	if obs.client.Connected() {
+		err = obs.refreshHotkeys()
+
+		if err != nil {
+			log.Printf("Error %v reading OBS hotkeys", err)
+		}
+
+		obs.recheckCommands()
+
+		obs.executePendingCommands()
+
+		done := make(chan struct{})
+
+		if obs.statsInterval > 0 {
+			go obs.pollStats(done)
+		}
+
+		for ticks := 1; obs.client.Connected() && !obs.quitflag; ticks++ {
+			time.Sleep(time.Second)
+
+			if ticks%obsPingInterval == 0 && !obs.pingObs() {
+				break
+			}
+		}
+
+		close(done)
+
+		obs.mutex.Lock()
+		obs.setConnectionState(obsDisconnected, nil)
+		obs.mutex.Unlock()
+
 		obs.client.Disconnect()
 	}
+
+	obs.client.Disconnect()
 }
 
 func (obs *obsCommandTarget) getSceneIndex(sceneName string) int {
-	for index, s := range obs.scenes {
-		if s == sceneName {
-			return index
-		}
-	}
-	return -1
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	return indexOf(obs.scenes, sceneName)
 }
 
 func (obs *obsCommandTarget) getSceneCollectionIndex(sceneCollectionName string) int {
-	for index, s := range obs.sceneCollections {
-		if s == sceneCollectionName {
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	return indexOf(obs.sceneCollections, sceneCollectionName)
+}
+
+func (obs *obsCommandTarget) getFlag(flag *bool) bool {
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	return *flag
+}
+
+func indexOf(items []string, item string) int {
+	for index, s := range items {
+		if s == item {
 			return index
 		}
 	}
 	return -1
 }
 
-func (obs *obsCommandTarget) activateScene(scenename string) error {
-	scsreq := obsws.NewSetCurrentSceneRequest(scenename)
+// adjacentItem returns the item that follows (delta is 1) or precedes (delta is -1) an item,
+// wrapping around at the ends of the list
+func adjacentItem(items []string, item string, delta int) string {
+	index := indexOf(items, item) + delta
+
+	if index < 0 {
+		index = len(items) - 1
+	} else if index >= len(items) {
+		index = 0
+	}
+	return itemAt(items, index)
+}
 
-	_, err := scsreq.SendReceive(obs.client)
+// itemAt returns an empty string if list is empty
+func itemAt(items []string, index int) string {
+	if index < 0 || index >= len(items) {
+		return ""
+	}
+	return items[index]
+}
+
+func (obs *obsCommandTarget) activateScene(scenename string) error {
+	_, err := obs.client.Request("SetCurrentProgramScene", map[string]interface{}{"sceneName": scenename})
 
 	if err != nil {
 		return err
 	}
 
+	obs.mutex.Lock()
 	obs.activeScene = scenename
+	obs.mutex.Unlock()
 	return nil
 }
 
+// moveScene activates the next or previous scene, in studio mode it changes the scene in preview
+func (obs *obsCommandTarget) moveScene(delta int) error {
+	obs.mutex.RLock()
+	studiomode := obs.studioMode
+	current := obs.activeScene
+
+	if studiomode {
+		current = obs.previewScene
+	}
+
+	scene := adjacentItem(obs.scenes, current, delta)
+	obs.mutex.RUnlock()
+
+	if studiomode {
+		return obs.setPreviewScene(scene)
+	}
+	return obs.activateScene(scene)
+}
+
 func (obs *obsCommandTarget) activateSceneCollection(scenecollectionname string) error {
 	if obs.getSceneCollectionIndex(scenecollectionname) == -1 {
 		return fmt.Errorf("Invalid collection scene name")
 	}
 
-	scscreq := obsws.NewSetCurrentSceneCollectionRequest(scenecollectionname)
-
-	_, err := scscreq.SendReceive(obs.client)
+	_, err := obs.client.Request("SetCurrentSceneCollection", map[string]interface{}{"sceneCollectionName": scenecollectionname})
 
 	if err != nil {
 		return err
 	}
 
+	obs.mutex.Lock()
 	obs.activeCollection = scenecollectionname
+	obs.mutex.Unlock()
 	return err
 }
 
 func (obs *obsCommandTarget) startRecording() error {
 
-	if obs.recording {
+	if obs.getFlag(&obs.recording) {
 		return nil
 	}
 
-	srreq := obsws.NewStartRecordingRequest()
-
-	_, err := srreq.SendReceive(obs.client)
+	_, err := obs.client.Request("StartRecord", nil)
 
 	return err
 }
 
 func (obs *obsCommandTarget) stopRecording() error {
 
-	if !obs.recording {
+	if !obs.getFlag(&obs.recording) {
 		return nil
 	}
 
-	srreq := obsws.NewStopRecordingRequest()
-
-	_, err := srreq.SendReceive(obs.client)
+	_, err := obs.client.Request("StopRecord", nil)
 
 	return err
 }
 
 func (obs *obsCommandTarget) pauseRecording() error {
 
-	if !obs.recording || obs.recordingPaused {
+	if !obs.getFlag(&obs.recording) || obs.getFlag(&obs.recordingPaused) {
 		return nil
 	}
 
-	prreq := obsws.NewPauseRecordingRequest()
-
-	_, err := prreq.SendReceive(obs.client)
+	_, err := obs.client.Request("PauseRecord", nil)
 
 	return err
 }
 
 func (obs *obsCommandTarget) resumeRecording() error {
 
-	if !obs.recording || !obs.recordingPaused {
+	if !obs.getFlag(&obs.recording) || !obs.getFlag(&obs.recordingPaused) {
 		return nil
 	}
 
-	rrreq := obsws.NewResumeRecordingRequest()
-
-	_, err := rrreq.SendReceive(obs.client)
+	_, err := obs.client.Request("ResumeRecord", nil)
 
 	return err
 }
 
 func (obs *obsCommandTarget) startStreaming() error {
 
-	if obs.streaming {
+	if obs.getFlag(&obs.streaming) {
 		return nil
 	}
 
-	ssreq := obsws.NewStartStreamingRequest(nil, "", nil, nil, "", "", false, "", "")
-
-	_, err := ssreq.SendReceive(obs.client)
+	_, err := obs.client.Request("StartStream", nil)
 
 	return err
 }
 
 func (obs *obsCommandTarget) stopStreaming() error {
 
-	if !obs.streaming {
+	if !obs.getFlag(&obs.streaming) {
 		return nil
 	}
 
-	ssreq := obsws.NewStopStreamingRequest()
-
-	_, err := ssreq.SendReceive(obs.client)
+	_, err := obs.client.Request("StopStream", nil)
 
 	return err
 }
@@ -0,0 +1,355 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"keypad/obsws5"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// obsCheckTests lists commands with the warning expected when OBS state is known, empty if the
+// command refers to existing objects
+var obsCheckTests = []struct {
+	command    string
+	parameters []interface{}
+	warning    string
+}{
+	{"showSource", []interface{}{"camera"}, ""},
+	{"hideSource", []interface{}{"camera", "main"}, ""},
+	{"toggleSource", []interface{}{"missing"}, "source missing not found in OBS scenes"},
+	{"setSourcePosition", []interface{}{"camera", 10, 10, "other"}, "scene other not found in OBS"},
+	{"setSourceScale", []interface{}{"logo", 1, 1, "main"}, "source logo not found in OBS scene main"},
+	{"setMute", []interface{}{"mic", true}, ""},
+	{"toggleMute", []interface{}{"aux"}, "audio input aux not found in OBS"},
+	{"setVolume", []interface{}{"aux", -6}, "audio input aux not found in OBS"},
+	{"setTransition", []interface{}{"Fade"}, ""},
+	{"quickTransition", []interface{}{"Wipe", 500}, "transition Wipe not found in OBS"},
+	{"setText", []interface{}{"title", "text"}, ""},
+	{"setText", []interface{}{"subtitle", "text"}, "text source subtitle not found in OBS"},
+	{"setText", []interface{}{"camera", "text"}, "Source camera is not a text source in OBS command setText"},
+	{"enableFilter", []interface{}{"main", "blur"}, ""},
+	{"toggleFilter", []interface{}{"overlay", "blur"}, "source overlay not found in OBS"},
+	{"triggerHotkey", []interface{}{"OBSBasic.StartRecording"}, ""},
+	{"triggerHotkey", []interface{}{"OBSBasic.Unknown"}, "hotkey OBSBasic.Unknown not found in OBS"},
+	{"playMedia", []interface{}{"intro"}, ""},
+	{"stopMedia", []interface{}{"outro"}, "media source outro not found in OBS"},
+}
+
+// newTestOBSTarget returns a target that is not connected to OBS
+func newTestOBSTarget() *obsCommandTarget {
+	obs := new(obsCommandTarget)
+	obs.commandsMap = new(Map)
+	obs.commandsMap.Init(obs, obsCommands)
+	return obs
+}
+
+// fakeOBSClient records the requests it receives, they are answered by respond (or with no data,
+// if respond is nil). Connect fails if connect returns an error, batches can fail or return only
+// some of the results
+type fakeOBSClient struct {
+	mutex     sync.Mutex
+	connected bool
+	connect   func() error
+	respond   func(requestType string, data map[string]interface{}) (map[string]interface{}, error)
+	requests  []obsws5.Request
+	deadline  time.Time // deadline of the context of the last request, zero if it has none
+	batch     error     // error returned by batches
+	results   int       // maximum number of results of a batch, 0 if there's no limit
+	handler   func(eventType string, data map[string]interface{})
+}
+
+func (c *fakeOBSClient) Connect() error {
+	var err error
+
+	if c.connect != nil {
+		err = c.connect()
+	}
+
+	c.mutex.Lock()
+	c.connected = err == nil
+	c.mutex.Unlock()
+	return err
+}
+
+func (c *fakeOBSClient) Disconnect() {
+	c.mutex.Lock()
+	c.connected = false
+	c.mutex.Unlock()
+}
+
+func (c *fakeOBSClient) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connected
+}
+
+func (c *fakeOBSClient) SetEventHandler(handler func(eventType string, data map[string]interface{})) {
+	c.handler = handler
+}
+
+func (c *fakeOBSClient) Request(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	c.mutex.Lock()
+	c.requests = append(c.requests, obsws5.Request{Type: requestType, Data: data})
+	c.mutex.Unlock()
+
+	if c.respond == nil {
+		return map[string]interface{}{}, nil
+	}
+	return c.respond(requestType, data)
+}
+
+func (c *fakeOBSClient) RequestContext(ctx context.Context, requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	deadline, _ := ctx.Deadline()
+
+	c.mutex.Lock()
+	c.deadline = deadline
+	c.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Request(requestType, data)
+}
+
+func (c *fakeOBSClient) RequestBatch(requests []obsws5.Request, haltOnFailure bool) ([]obsws5.Response, error) {
+	var responses []obsws5.Response
+
+	c.mutex.Lock()
+	failure := c.batch
+	c.mutex.Unlock()
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	if c.results > 0 && len(requests) > c.results {
+		requests = requests[:c.results]
+	}
+
+	for _, request := range requests {
+		data, err := c.Request(request.Type, request.Data)
+
+		responses = append(responses, obsws5.Response{Type: request.Type, Data: data, Err: err})
+
+		if err != nil && haltOnFailure {
+			break
+		}
+	}
+	return responses, nil
+}
+
+// sent returns the requests received by the client, removing them
+func (c *fakeOBSClient) sent() []obsws5.Request {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	requests := c.requests
+	c.requests = nil
+	return requests
+}
+
+// newConnectedOBSTarget returns a target connected to a fake client
+func newConnectedOBSTarget(client *fakeOBSClient) *obsCommandTarget {
+	obs := newTestOBSTarget()
+	obs.client = client
+	obs.connection = obsConnected
+	client.connected = true
+	return obs
+}
+
+// captureLog returns the messages logged by f
+func captureLog(f func()) string {
+	var output bytes.Buffer
+
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	f()
+	return output.String()
+}
+
+// TestOBSRecheckCommands checks commands while OBS state is not available, warnings for unknown
+// objects must be logged when the checks are repeated after state has been read
+func TestOBSRecheckCommands(t *testing.T) {
+	obs := newTestOBSTarget()
+
+	output := captureLog(func() {
+		for _, test := range obsCheckTests {
+			err := obs.CheckCommand(test.command, test.parameters)
+
+			if err != nil {
+				t.Errorf("Command %s %v failed: %v", test.command, test.parameters, err)
+			}
+		}
+	})
+
+	if output != "" {
+		t.Errorf("Warnings logged before OBS state is available: %s", output)
+	}
+
+	obs.scenes = []string{"main"}
+	obs.sceneItems = map[string][]obsSceneItem{"main": {{id: 1, source: "camera", enabled: true}}}
+	obs.inputs = map[string]*obsInput{"mic": {volume: 0}}
+	obs.transitions = []string{"Cut", "Fade"}
+	obs.inputKinds = map[string]string{"camera": "v4l2_input", "mic": "pulse_input_capture", "title": "text_ft2_source_v2", "intro": "ffmpeg_source"}
+	obs.hotkeys = []string{"OBSBasic.StartRecording", "OBSBasic.StopRecording"}
+
+	output = captureLog(obs.recheckCommands)
+
+	for _, test := range obsCheckTests {
+		if test.warning != "" && !strings.Contains(output, test.warning) {
+			t.Errorf("Warning %q not logged for command %s %v", test.warning, test.command, test.parameters)
+		}
+	}
+
+	if count := strings.Count(output, "Warning"); count != strings.Count(output, "\n") || count != obsExpectedWarnings() {
+		t.Errorf("Unexpected warnings logged: %s", output)
+	}
+
+	// commands checked after state has been read are checked only once
+	output = captureLog(func() {
+		obs.CheckCommand("showSource", []interface{}{"missing"})
+		obs.recheckCommands()
+	})
+
+	if strings.Count(output, "Warning") != 1 {
+		t.Errorf("Command checked after state has been read logged: %s", output)
+	}
+}
+
+func obsExpectedWarnings() int {
+	count := 0
+
+	for _, test := range obsCheckTests {
+		if test.warning != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// TestOBSToggleSource checks that visibility is read from OBS before toggling a source, the
+// cache can be outdated
+func TestOBSToggleSource(t *testing.T) {
+	enabled := true
+
+	client := &fakeOBSClient{respond: func(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+		switch requestType {
+		case "GetSceneItemId":
+			return map[string]interface{}{"sceneItemId": float64(7)}, nil
+		case "GetSceneItemEnabled":
+			return map[string]interface{}{"sceneItemEnabled": enabled}, nil
+		}
+		return map[string]interface{}{}, nil
+	}}
+
+	obs := newConnectedOBSTarget(client)
+	obs.activeScene = "main"
+	obs.sceneItems = map[string][]obsSceneItem{"main": {{id: 1, source: "camera", enabled: false}}}
+
+	tests := []struct {
+		parameters []interface{}
+		enabled    bool
+		expected   []obsws5.Request
+	}{
+		{[]interface{}{"camera"}, true, []obsws5.Request{
+			{Type: "GetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "main", "sceneItemId": 1}},
+			{Type: "SetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "main", "sceneItemId": 1, "sceneItemEnabled": false}}}},
+		{[]interface{}{"camera", "main"}, false, []obsws5.Request{
+			{Type: "GetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "main", "sceneItemId": 1}},
+			{Type: "SetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "main", "sceneItemId": 1, "sceneItemEnabled": true}}}},
+		// items missing from the cache are requested to OBS
+		{[]interface{}{"logo", "other"}, true, []obsws5.Request{
+			{Type: "GetSceneItemId", Data: map[string]interface{}{"sceneName": "other", "sourceName": "logo"}},
+			{Type: "GetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "other", "sceneItemId": 7}},
+			{Type: "SetSceneItemEnabled", Data: map[string]interface{}{"sceneName": "other", "sceneItemId": 7, "sceneItemEnabled": false}}}},
+	}
+
+	for _, test := range tests {
+		enabled = test.enabled
+
+		err := obs.ExecuteCommand("toggleSource", test.parameters)
+
+		if err != nil {
+			t.Errorf("Toggle %v failed: %v", test.parameters, err)
+		}
+
+		if requests := client.sent(); !reflect.DeepEqual(requests, test.expected) {
+			t.Errorf("Toggle %v sent %v instead of %v", test.parameters, requests, test.expected)
+		}
+	}
+}
+
+// TestOBSSceneItemsProtocol checks that scene item commands are rejected with protocol 4
+func TestOBSSceneItemsProtocol(t *testing.T) {
+	obs := newTestOBSTarget()
+	obs.protocol = 4
+
+	for _, command := range []string{"showSource", "hideSource", "toggleSource"} {
+		err := obs.CheckCommand(command, []interface{}{"camera"})
+
+		if err == nil || !strings.Contains(err.Error(), "require OBS websocket protocol 5") {
+			t.Errorf("Command %s returned %v with protocol 4", command, err)
+		}
+	}
+
+	err := obs.CheckCommand("setSourceCrop", []interface{}{"camera", 0, 0, 10, 10})
+
+	if err == nil {
+		t.Errorf("Command setSourceCrop accepted with protocol 4")
+	}
+
+	obs.protocol = 5
+
+	err = obs.CheckCommand("toggleSource", []interface{}{"camera"})
+
+	if err != nil {
+		t.Errorf("Command toggleSource failed with protocol 5: %v", err)
+	}
+}
+
+// TestOBSPing checks that the ping uses its own timeout, instead of the timeout of the requests
+func TestOBSPing(t *testing.T) {
+	var failure error
+
+	client := &fakeOBSClient{respond: func(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{}, failure
+	}}
+
+	obs := newConnectedOBSTarget(client)
+	start := time.Now()
+
+	if !obs.pingObs() {
+		t.Errorf("Ping failed")
+	}
+
+	if requests := client.sent(); len(requests) != 1 || requests[0].Type != "GetVersion" {
+		t.Errorf("Ping sent %v", requests)
+	}
+
+	if client.deadline.Before(start) || client.deadline.After(time.Now().Add(obsPingTimeout)) {
+		t.Errorf("Ping deadline is %v after its start", client.deadline.Sub(start))
+	}
+
+	failure = fmt.Errorf("Timeout waiting for OBS response to request GetVersion")
+
+	output := captureLog(func() {
+		if obs.pingObs() {
+			t.Errorf("Ping succeeded after a timeout")
+		}
+	})
+
+	if !strings.Contains(output, "closing connection") {
+		t.Errorf("Failed ping logged %q", output)
+	}
+}
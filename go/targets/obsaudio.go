@@ -0,0 +1,229 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"keypad/obsws5"
+	"log"
+	"math"
+)
+
+// limits of the volume of an input, in dB, lower volumes are considered silent
+const obsMinVolume = -100
+const obsMaxVolume = 26
+
+// obsInput is the audio state of an input
+type obsInput struct {
+	muted  bool
+	volume float64 // dB
+}
+
+var obsMonitorTypes = map[string]string{
+	"none":             "OBS_MONITORING_TYPE_NONE",
+	"monitorOnly":      "OBS_MONITORING_TYPE_MONITOR_ONLY",
+	"monitorAndOutput": "OBS_MONITORING_TYPE_MONITOR_AND_OUTPUT",
+}
+
+// obsInputCheck reports inputs that are not known to OBS as warnings, since they could be added later
+func obsInputCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	if obs.inputs == nil {
+		return nil
+	}
+
+	if _, ok := obs.inputs[parameters[0].(string)]; !ok {
+		log.Printf("Warning: audio input %s not found in OBS", parameters[0])
+	}
+	return nil
+}
+
+func setMuteExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	muted, _ := ToBool(parameters[1])
+
+	_, err := obs.client.Request("SetInputMute", map[string]interface{}{"inputName": parameters[0], "inputMuted": muted})
+	return err
+}
+
+func toggleMuteExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	// OBS toggles its own state, so the result does not depend on the cached one
+	_, err := obs.client.Request("ToggleInputMute", map[string]interface{}{"inputName": parameters[0]})
+	return err
+}
+
+func setVolumeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setVolume(parameters[0].(string), FloatParameter(parameters[1]))
+}
+
+func adjustVolumeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	input := parameters[0].(string)
+
+	resp, err := obs.client.Request("GetInputVolume", map[string]interface{}{"inputName": input})
+
+	if err != nil {
+		return err
+	}
+
+	volume := math.Max(obsVolume(resp), obsMinVolume) + FloatParameter(parameters[1])
+
+	return obs.setVolume(input, math.Min(math.Max(volume, obsMinVolume), obsMaxVolume))
+}
+
+func setMonitorTypeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("SetInputAudioMonitorType", map[string]interface{}{
+		"inputName":   parameters[0],
+		"monitorType": obsMonitorTypes[parameters[1].(string)],
+	})
+	return err
+}
+
+func (obs *obsCommandTarget) setVolume(input string, volume float64) error {
+	_, err := obs.client.Request("SetInputVolume", map[string]interface{}{"inputName": input, "inputVolumeDb": volume})
+	return err
+}
+
+// obsVolume reads volume in dB, OBS sends null for silent inputs (-inf dB)
+func obsVolume(data map[string]interface{}) float64 {
+	volume, ok := data["inputVolumeDb"].(float64)
+
+	if !ok {
+		return math.Inf(-1)
+	}
+	return volume
+}
+
+// refreshInputs reads the list of inputs and the state of those supporting audio
+func (obs *obsCommandTarget) refreshInputs() error {
+	resp, err := obs.client.Request("GetInputList", nil)
+
+	if err != nil {
+		return err
+	}
+
+	names := obsStrings(resp, "inputs", "inputName")
+	kinds := obsStrings(resp, "inputs", "unversionedInputKind")
+
+	obs.mutex.Lock()
+	obs.inputKinds = make(map[string]string, len(names))
+
+	for index, name := range names {
+		obs.inputKinds[name] = kinds[index]
+	}
+	obs.mutex.Unlock()
+
+	return obs.refreshInputsState(names, true)
+}
+
+// refreshInputsState reads mute state and volume of inputs, if replace is true inputs
+// that are not listed are removed
+func (obs *obsCommandTarget) refreshInputsState(names []string, replace bool) error {
+	var requests []obsws5.Request
+
+	for _, name := range names {
+		requests = append(requests,
+			obsws5.Request{Type: "GetInputMute", Data: map[string]interface{}{"inputName": name}},
+			obsws5.Request{Type: "GetInputVolume", Data: map[string]interface{}{"inputName": name}})
+	}
+
+	responses, err := obs.client.RequestBatch(requests, false)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	if obs.inputs == nil || replace {
+		obs.inputs = make(map[string]*obsInput, len(names))
+	}
+
+	for index, name := range names {
+		if 2*index+1 >= len(responses) || responses[2*index].Err != nil {
+			continue
+		}
+
+		input := &obsInput{muted: obsBool(responses[2*index].Data, "inputMuted"), volume: math.Inf(-1)}
+
+		if responses[2*index+1].Err == nil {
+			input.volume = obsVolume(responses[2*index+1].Data)
+		}
+
+		obs.inputs[name] = input
+	}
+	return nil
+}
+
+// onInputEvent updates the state of the inputs
+func (obs *obsCommandTarget) onInputEvent(eventType string, data map[string]interface{}) {
+	name := obsString(data, "inputName")
+
+	switch eventType {
+	case "InputCreated":
+		obs.mutex.Lock()
+		if obs.inputKinds != nil {
+			obs.inputKinds[name] = obsString(data, "unversionedInputKind")
+		}
+		obs.mutex.Unlock()
+
+		err := obs.refreshInputsState([]string{name}, false)
+
+		if err != nil {
+			log.Printf("Error %v reading state of OBS input %s", err, name)
+		}
+	case "InputRemoved":
+		obs.mutex.Lock()
+		delete(obs.inputs, name)
+		delete(obs.inputKinds, name)
+		obs.mutex.Unlock()
+	case "InputNameChanged":
+		oldname := obsString(data, "oldInputName")
+
+		obs.mutex.Lock()
+		if input, ok := obs.inputs[oldname]; ok {
+			delete(obs.inputs, oldname)
+			obs.inputs[name] = input
+		}
+
+		if kind, ok := obs.inputKinds[oldname]; ok {
+			delete(obs.inputKinds, oldname)
+			obs.inputKinds[name] = kind
+		}
+		obs.mutex.Unlock()
+	case "InputMuteStateChanged":
+		obs.mutex.Lock()
+		if input, ok := obs.inputs[name]; ok {
+			input.muted = obsBool(data, "inputMuted")
+		}
+		obs.mutex.Unlock()
+	case "InputVolumeChanged":
+		obs.mutex.Lock()
+		if input, ok := obs.inputs[name]; ok {
+			input.volume = obsVolume(data)
+		}
+		obs.mutex.Unlock()
+	}
+}
+
+// inputsState returns mute state and volume of inputs, by input name
+func (obs *obsCommandTarget) inputsState() (map[string]interface{}, map[string]interface{}) {
+	muted := make(map[string]interface{}, len(obs.inputs))
+	volumes := make(map[string]interface{}, len(obs.inputs))
+
+	for name, input := range obs.inputs {
+		muted[name] = input.muted
+		volumes[name] = math.Max(input.volume, obsMinVolume)
+	}
+	return muted, volumes
+}
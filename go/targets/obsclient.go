@@ -0,0 +1,92 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"context"
+	"keypad/obsws5"
+	"sort"
+	"time"
+)
+
+// obsClient is a connection to OBS. Requests and events use names and fields defined
+// by obs-websocket protocol v5, clients for older protocols translate them
+type obsClient interface {
+	Connect() error
+	Disconnect()
+	Connected() bool
+	SetEventHandler(handler func(eventType string, data map[string]interface{}))
+	Request(requestType string, data map[string]interface{}) (map[string]interface{}, error)
+	RequestContext(ctx context.Context, requestType string, data map[string]interface{}) (map[string]interface{}, error)
+	RequestBatch(requests []obsws5.Request, haltOnFailure bool) ([]obsws5.Response, error)
+}
+
+func newOBSClient(cfg obsCommandTargetConfig, timeout time.Duration) obsClient {
+	if cfg.Protocol == 4 {
+		return newOBSV4Client(cfg.Host, cfg.Port, cfg.Password, timeout)
+	}
+	return &obsws5.Client{Host: cfg.Host, Port: cfg.Port, Password: cfg.Password, Timeout: timeout}
+}
+
+// helpers to read values from requests responses and events
+
+func obsString(data map[string]interface{}, name string) string {
+	s, _ := data[name].(string)
+	return s
+}
+
+func obsBool(data map[string]interface{}, name string) bool {
+	b, _ := data[name].(bool)
+	return b
+}
+
+func obsNumber(data map[string]interface{}, name string) float64 {
+	f, _ := data[name].(float64)
+	return f
+}
+
+func obsList(data map[string]interface{}, name string) []interface{} {
+	l, _ := data[name].([]interface{})
+	return l
+}
+
+// obsStrings reads a list of strings, or the value of field name for a list of objects
+func obsStrings(data map[string]interface{}, name string, field string) []string {
+	var items []string
+
+	for _, item := range obsList(data, name) {
+		if field == "" {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		} else if object, ok := item.(map[string]interface{}); ok {
+			items = append(items, obsString(object, field))
+		}
+	}
+	return items
+}
+
+// obsSceneNames returns names of scenes in the same order used by OBS user interface
+func obsSceneNames(data map[string]interface{}) []string {
+	scenes := obsList(data, "scenes")
+
+	sorted := make([]map[string]interface{}, 0, len(scenes))
+
+	for _, scene := range scenes {
+		if object, ok := scene.(map[string]interface{}); ok {
+			sorted = append(sorted, object)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return obsNumber(sorted[i], "sceneIndex") > obsNumber(sorted[j], "sceneIndex")
+	})
+
+	names := make([]string, len(sorted))
+
+	for index, scene := range sorted {
+		names[index] = obsString(scene, "sceneName")
+	}
+	return names
+}
@@ -0,0 +1,195 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// states of the connection with OBS
+const obsDisconnected = "disconnected"
+const obsConnecting = "connecting"
+const obsConnected = "connected"
+
+// policies for commands sent while OBS is not connected
+const obsOfflineFail = "fail"
+const obsOfflineQueue = "queue"
+const obsOfflineDrop = "drop"
+
+var obsOfflinePolicies = []string{obsOfflineFail, obsOfflineQueue, obsOfflineDrop}
+
+// delay between connection attempts, doubled after each failure
+const obsMinReconnectDelay = time.Second
+const obsMaxReconnectDelay = 30 * time.Second
+
+const obsDefaultQueueTimeout = 30 * time.Second
+const obsMaxPendingCommands = 100
+
+// obsPendingCommand is a command queued while OBS is not connected
+type obsPendingCommand struct {
+	command    string
+	parameters []interface{}
+	expires    time.Time
+}
+
+// initOfflinePolicies validates the policies read from configuration
+func (obs *obsCommandTarget) initOfflinePolicies(cfg obsCommandTargetConfig) error {
+	if cfg.Offline == "" {
+		cfg.Offline = obsOfflineFail
+	}
+
+	if indexOf(obsOfflinePolicies, cfg.Offline) == -1 {
+		return fmt.Errorf("Invalid offline policy %s for OBS target", cfg.Offline)
+	}
+
+	for command, policy := range cfg.OfflineCommands {
+		if _, ok := obsCommands[command]; !ok {
+			return fmt.Errorf("Invalid OBS command %s in offline policies", command)
+		}
+
+		if indexOf(obsOfflinePolicies, policy) == -1 {
+			return fmt.Errorf("Invalid offline policy %s for OBS command %s", policy, command)
+		}
+	}
+
+	obs.offlinePolicy = cfg.Offline
+	obs.offlineCommands = cfg.OfflineCommands
+	obs.queueTimeout = obsDefaultQueueTimeout
+
+	if cfg.QueueTimeout != nil {
+		timeout, err := ParseDuration(cfg.QueueTimeout)
+
+		if err != nil {
+			return err
+		}
+
+		obs.queueTimeout = timeout
+	}
+	return nil
+}
+
+// offlineCommand applies the offline policy of a command, it returns true if the command has been
+// queued or dropped, false if it can be executed because OBS is connected
+func (obs *obsCommandTarget) offlineCommand(command string, parameters []interface{}) (bool, error) {
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	if obs.connection == obsConnected && obs.client.Connected() {
+		return false, nil
+	}
+
+	obs.dropExpiredCommands()
+
+	policy, ok := obs.offlineCommands[command]
+
+	if !ok {
+		policy = obs.offlinePolicy
+	}
+
+	switch {
+	case policy == obsOfflineFail:
+		return true, fmt.Errorf("OBS is not connected")
+	case policy == obsOfflineQueue && len(obs.pending) < obsMaxPendingCommands:
+		log.Printf("OBS is not connected, command %s queued", command)
+		obs.pending = append(obs.pending, obsPendingCommand{command: command, parameters: parameters, expires: time.Now().Add(obs.queueTimeout)})
+	case policy == obsOfflineQueue:
+		log.Printf("OBS is not connected and too many commands are queued, command %s dropped", command)
+	default:
+		log.Printf("OBS is not connected, command %s dropped", command)
+	}
+	return true, nil
+}
+
+// dropExpiredCommands removes queued commands that waited too long, the mutex must be locked
+func (obs *obsCommandTarget) dropExpiredCommands() {
+	pending := obs.pending[:0]
+
+	for _, command := range obs.pending {
+		if time.Now().After(command.expires) {
+			log.Printf("Queued OBS command %s expired, dropped", command.command)
+			continue
+		}
+
+		pending = append(pending, command)
+	}
+
+	obs.pending = pending
+}
+
+// executePendingCommands runs the commands queued while OBS was not connected, in the same order
+// they have been sent, then marks the connection as active. Commands queued while this is running
+// are executed before the connection becomes active, so the order is preserved
+func (obs *obsCommandTarget) executePendingCommands() {
+	for {
+		obs.mutex.Lock()
+		obs.dropExpiredCommands()
+
+		pending := obs.pending
+		obs.pending = nil
+
+		if len(pending) == 0 {
+			obs.setConnectionState(obsConnected, nil)
+			obs.mutex.Unlock()
+			return
+		}
+		obs.mutex.Unlock()
+
+		for _, command := range pending {
+			err := obs.commandsMap.ExecuteCommand(command.command, command.parameters)
+
+			if err != nil {
+				log.Printf("Error %v executing queued OBS command %s", err, command.command)
+			}
+		}
+	}
+}
+
+// setConnectionState updates connection state and logs its changes, the mutex must be locked
+func (obs *obsCommandTarget) setConnectionState(state string, err error) {
+	if err != nil {
+		// errors are logged only when they change, to avoid filling the log while OBS is down
+		if err.Error() != obs.lastError {
+			log.Printf("Error %v connecting to OBS, retrying in %v", err, obs.reconnectDelay)
+		}
+
+		obs.lastError = err.Error()
+		obs.reconnectAttempts++
+	}
+
+	if state == obsConnected {
+		log.Printf("Connected to OBS")
+
+		obs.lastError = ""
+		obs.reconnectAttempts = 0
+		obs.reconnectDelay = 0
+	} else if obs.connection == obsConnected {
+		log.Printf("OBS connection lost")
+	}
+
+	obs.connection = state
+}
+
+// connectionFailed records a failed connection attempt and returns the time to wait before
+// the next one, the delay is doubled after each failure
+func (obs *obsCommandTarget) connectionFailed(err error) time.Duration {
+	obs.client.Disconnect()
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	if obs.reconnectDelay == 0 {
+		obs.reconnectDelay = obsMinReconnectDelay
+	}
+
+	delay := obs.reconnectDelay
+	obs.setConnectionState(obsDisconnected, err)
+	obs.reconnectDelay = delay * 2
+
+	if obs.reconnectDelay > obsMaxReconnectDelay {
+		obs.reconnectDelay = obsMaxReconnectDelay
+	}
+	return delay
+}
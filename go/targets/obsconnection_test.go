@@ -0,0 +1,218 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newOfflineOBSTarget returns a target that is not connected, using the offline policies of cfg
+func newOfflineOBSTarget(t *testing.T, cfg obsCommandTargetConfig) (*obsCommandTarget, *fakeOBSClient) {
+	client := &fakeOBSClient{}
+
+	obs := newTestOBSTarget()
+	obs.client = client
+	obs.connection = obsDisconnected
+
+	err := obs.initOfflinePolicies(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obs, client
+}
+
+// hotkeys returns the names of the hotkeys triggered by requests
+func hotkeys(client *fakeOBSClient) []string {
+	var names []string
+
+	for _, request := range client.sent() {
+		if request.Type == "TriggerHotkeyByName" {
+			names = append(names, fmt.Sprint(request.Data["hotkeyName"]))
+		}
+	}
+	return names
+}
+
+func TestOBSReconnectDelay(t *testing.T) {
+	client := &fakeOBSClient{}
+	obs := newConnectedOBSTarget(client)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+
+	output := captureLog(func() {
+		for attempt, delay := range expected {
+			if got := obs.connectionFailed(fmt.Errorf("Connection refused")); got != delay {
+				t.Errorf("Attempt %d waits %v instead of %v", attempt+1, got, delay)
+			}
+
+			if obs.reconnectAttempts != attempt+1 || obs.connection != obsDisconnected || obs.lastError != "Connection refused" {
+				t.Errorf("Attempt %d has state %d, %s, %s", attempt+1, obs.reconnectAttempts, obs.connection, obs.lastError)
+			}
+		}
+	})
+
+	if client.Connected() {
+		t.Errorf("Client is connected after a failed attempt")
+	}
+
+	// the same error is logged only once
+	if strings.Count(output, "Connection refused") != 1 {
+		t.Errorf("Failed attempts logged %q", output)
+	}
+
+	// the delay is reset after a successful connection
+	captureLog(func() {
+		obs.setConnectionState(obsConnected, nil)
+	})
+
+	if obs.reconnectAttempts != 0 || obs.lastError != "" {
+		t.Errorf("Connection did not reset state: %d, %s", obs.reconnectAttempts, obs.lastError)
+	}
+
+	captureLog(func() {
+		if delay := obs.connectionFailed(fmt.Errorf("Connection refused")); delay != time.Second {
+			t.Errorf("First attempt after a connection waits %v", delay)
+		}
+	})
+}
+
+func TestOBSOfflinePolicies(t *testing.T) {
+	tests := []struct {
+		offline  string
+		commands map[string]string
+		err      string // expected error, empty if the command is queued or dropped
+		queued   bool
+		logged   string
+	}{
+		{"", nil, "OBS is not connected", false, ""},
+		{"fail", nil, "OBS is not connected", false, ""},
+		{"queue", nil, "", true, "OBS is not connected, command triggerHotkey queued"},
+		{"drop", nil, "", false, "OBS is not connected, command triggerHotkey dropped"},
+		{"queue", map[string]string{"triggerHotkey": "fail"}, "OBS is not connected", false, ""},
+		{"fail", map[string]string{"triggerHotkey": "queue"}, "", true, "queued"},
+		{"queue", map[string]string{"triggerHotkey": "drop", "startRecording": "fail"}, "", false, "dropped"},
+	}
+
+	for _, test := range tests {
+		obs, client := newOfflineOBSTarget(t, obsCommandTargetConfig{Offline: test.offline, OfflineCommands: test.commands})
+
+		var err error
+
+		output := captureLog(func() {
+			err = obs.ExecuteCommand("triggerHotkey", []interface{}{"OBSBasic.StartRecording"})
+		})
+
+		if test.err == "" && err != nil {
+			t.Errorf("Policy %s %v failed: %v", test.offline, test.commands, err)
+		}
+
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Policy %s %v returned %v, expected %q", test.offline, test.commands, err, test.err)
+		}
+
+		if test.queued != (len(obs.pending) == 1) {
+			t.Errorf("Policy %s %v queued %d commands", test.offline, test.commands, len(obs.pending))
+		}
+
+		if !strings.Contains(output, test.logged) {
+			t.Errorf("Policy %s %v logged %q, expected %q", test.offline, test.commands, output, test.logged)
+		}
+
+		if requests := client.sent(); len(requests) != 0 {
+			t.Errorf("Policy %s %v sent %v while OBS is not connected", test.offline, test.commands, requests)
+		}
+	}
+}
+
+func TestOBSOfflinePoliciesErrors(t *testing.T) {
+	tests := []struct {
+		cfg   obsCommandTargetConfig
+		error string
+	}{
+		{obsCommandTargetConfig{Offline: "later"}, "Invalid offline policy later for OBS target"},
+		{obsCommandTargetConfig{OfflineCommands: map[string]string{"jump": "queue"}}, "Invalid OBS command jump in offline policies"},
+		{obsCommandTargetConfig{OfflineCommands: map[string]string{"startRecording": "retry"}}, "Invalid offline policy retry for OBS command startRecording"},
+		{obsCommandTargetConfig{Offline: "queue", QueueTimeout: "soon"}, "soon"},
+	}
+
+	for _, test := range tests {
+		err := newTestOBSTarget().initOfflinePolicies(test.cfg)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Policies %v returned %v, expected %q", test.cfg, err, test.error)
+		}
+	}
+}
+
+// TestOBSQueuedCommands queues more commands than allowed, the ones that fit in the queue must be
+// executed in order when OBS is connected
+func TestOBSQueuedCommands(t *testing.T) {
+	obs, client := newOfflineOBSTarget(t, obsCommandTargetConfig{Offline: "queue"})
+
+	var expected []string
+
+	output := captureLog(func() {
+		for index := 0; index <= obsMaxPendingCommands; index++ {
+			name := fmt.Sprintf("hotkey%d", index)
+
+			err := obs.ExecuteCommand("triggerHotkey", []interface{}{name})
+
+			if err != nil {
+				t.Errorf("Command %d failed: %v", index, err)
+			}
+
+			if index < obsMaxPendingCommands {
+				expected = append(expected, name)
+			}
+		}
+	})
+
+	if len(obs.pending) != obsMaxPendingCommands || !strings.Contains(output, "too many commands are queued, command triggerHotkey dropped") {
+		t.Errorf("Queue has %d commands, logged %q", len(obs.pending), output)
+	}
+
+	client.connected = true
+
+	captureLog(obs.executePendingCommands)
+
+	if names := hotkeys(client); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Queued commands executed as %v", names)
+	}
+
+	if obs.connection != obsConnected || len(obs.pending) != 0 {
+		t.Errorf("After replay connection is %s with %d pending commands", obs.connection, len(obs.pending))
+	}
+
+	// commands are executed immediately once connected
+	obs.ExecuteCommand("triggerHotkey", []interface{}{"now"})
+
+	if names := hotkeys(client); len(names) != 1 || names[0] != "now" {
+		t.Errorf("Command executed while connected sent %v", names)
+	}
+}
+
+func TestOBSQueueTimeout(t *testing.T) {
+	obs, client := newOfflineOBSTarget(t, obsCommandTargetConfig{Offline: "queue", QueueTimeout: "20ms"})
+
+	output := captureLog(func() {
+		obs.ExecuteCommand("triggerHotkey", []interface{}{"expired"})
+		time.Sleep(50 * time.Millisecond)
+		obs.ExecuteCommand("triggerHotkey", []interface{}{"valid"})
+
+		client.connected = true
+		obs.executePendingCommands()
+	})
+
+	if names := hotkeys(client); len(names) != 1 || names[0] != "valid" {
+		t.Errorf("Queued commands executed as %v", names)
+	}
+
+	if !strings.Contains(output, "Queued OBS command triggerHotkey expired, dropped") {
+		t.Errorf("Expired command not logged: %q", output)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"keypad/obsws5"
+	"log"
+	"strings"
+)
+
+var obsKeyModifiers = []string{"shift", "control", "alt", "command"}
+
+func triggerHotkeyCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	if obs.hotkeys != nil && indexOf(obs.hotkeys, parameters[0].(string)) == -1 {
+		log.Printf("Warning: hotkey %s not found in OBS", parameters[0])
+	}
+	return nil
+}
+
+func triggerHotkeyExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("TriggerHotkeyByName", map[string]interface{}{"hotkeyName": parameters[0]})
+	return err
+}
+
+// obsKeyID converts a key name to the id used by OBS (ex: f13 to OBS_KEY_F13)
+func obsKeyID(key string) string {
+	key = strings.ToUpper(key)
+
+	if strings.HasPrefix(key, "OBS_KEY_") {
+		return key
+	}
+	return "OBS_KEY_" + key
+}
+
+func triggerKeySequenceExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	modifiers := make(map[string]interface{}, len(obsKeyModifiers))
+
+	for _, modifier := range obsKeyModifiers {
+		modifiers[modifier] = false
+	}
+
+	for _, modifier := range parameters[1:] {
+		modifiers[modifier.(string)] = true
+	}
+
+	_, err := obs.client.Request("TriggerHotkeyByKeySequence", map[string]interface{}{
+		"keyId":        obsKeyID(parameters[0].(string)),
+		"keyModifiers": modifiers,
+	})
+	return err
+}
+
+// refreshHotkeys reads the names of the hotkeys, they are not validated if the list
+// is not available (protocol v4)
+func (obs *obsCommandTarget) refreshHotkeys() error {
+	responses, err := obs.client.RequestBatch([]obsws5.Request{{Type: "GetHotkeyList"}}, false)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	obs.hotkeys = nil
+
+	if len(responses) == 1 && responses[0].Err == nil {
+		obs.hotkeys = obsStrings(responses[0].Data, "hotkeys", "")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"log"
+)
+
+func mediaCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if !obs.sourceExists(parameters[0].(string)) {
+		log.Printf("Warning: media source %s not found in OBS", parameters[0])
+	}
+	return nil
+}
+
+func (obs *obsCommandTarget) mediaAction(input string, action string) error {
+	_, err := obs.client.Request("TriggerMediaInputAction", map[string]interface{}{
+		"inputName":   input,
+		"mediaAction": "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_" + action,
+	})
+	return err
+}
+
+func playMediaExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.mediaAction(parameters[0].(string), "PLAY")
+}
+
+func pauseMediaExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.mediaAction(parameters[0].(string), "PAUSE")
+}
+
+func restartMediaExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.mediaAction(parameters[0].(string), "RESTART")
+}
+
+func stopMediaExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.mediaAction(parameters[0].(string), "STOP")
+}
+
+func seekMediaExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("SetMediaInputCursor", map[string]interface{}{
+		"inputName":   parameters[0],
+		"mediaCursor": obsMilliseconds(parameters[1]),
+	})
+	return err
+}
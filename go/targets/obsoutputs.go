@@ -0,0 +1,156 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"keypad/obsws5"
+	"path/filepath"
+	"strings"
+)
+
+func startReplayBufferExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if obs.getFlag(&obs.replayBuffer) {
+		return nil
+	}
+
+	_, err := obs.client.Request("StartReplayBuffer", nil)
+	return err
+}
+
+func stopReplayBufferExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if !obs.getFlag(&obs.replayBuffer) {
+		return nil
+	}
+
+	_, err := obs.client.Request("StopReplayBuffer", nil)
+	return err
+}
+
+func toggleReplayBufferExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("ToggleReplayBuffer", nil)
+	return err
+}
+
+func saveReplayBufferExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("SaveReplayBuffer", nil)
+	return err
+}
+
+func startVirtualCamExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if obs.getFlag(&obs.virtualCam) {
+		return nil
+	}
+
+	_, err := obs.client.Request("StartVirtualCam", nil)
+	return err
+}
+
+func stopVirtualCamExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if !obs.getFlag(&obs.virtualCam) {
+		return nil
+	}
+
+	_, err := obs.client.Request("StopVirtualCam", nil)
+	return err
+}
+
+func toggleVirtualCamExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("ToggleVirtualCam", nil)
+	return err
+}
+
+// screenshotFormat returns the image format, from the extension of the file
+func screenshotFormat(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func saveScreenshotCheck(target interface{}, parameters []interface{}) error {
+	if screenshotFormat(parameters[0].(string)) == "" {
+		return fmt.Errorf("Screenshot file %s has no extension, it's required to select image format", parameters[0])
+	}
+	return nil
+}
+
+func saveScreenshotExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	path := parameters[0].(string)
+
+	obs.mutex.RLock()
+	source := obs.activeScene
+	obs.mutex.RUnlock()
+
+	if len(parameters) > 1 {
+		source = parameters[1].(string)
+	}
+
+	_, err := obs.client.Request("SaveSourceScreenshot", map[string]interface{}{
+		"sourceName":    source,
+		"imageFormat":   screenshotFormat(path),
+		"imageFilePath": path,
+	})
+	return err
+}
+
+// refreshOutputs reads the state of replay buffer and virtual camera, outputs that are
+// not available (ex: replay buffer not enabled in OBS settings) are reported as not active
+func (obs *obsCommandTarget) refreshOutputs() error {
+	responses, err := obs.client.RequestBatch([]obsws5.Request{
+		{Type: "GetReplayBufferStatus"},
+		{Type: "GetVirtualCamStatus"},
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	obs.replayBuffer = false
+	obs.virtualCam = false
+
+	for _, response := range responses {
+		switch {
+		case response.Err != nil:
+		case response.Type == "GetReplayBufferStatus":
+			obs.replayBuffer = obsBool(response.Data, "outputActive")
+		case response.Type == "GetVirtualCamStatus":
+			obs.virtualCam = obsBool(response.Data, "outputActive")
+		}
+	}
+	return nil
+}
+
+// onOutputEvent updates state of replay buffer and virtual camera
+func (obs *obsCommandTarget) onOutputEvent(eventType string, data map[string]interface{}) {
+	switch eventType {
+	case "ReplayBufferStateChanged":
+		obs.mutex.Lock()
+		obs.replayBuffer = obsBool(data, "outputActive")
+		obs.mutex.Unlock()
+	case "VirtualcamStateChanged":
+		obs.mutex.Lock()
+		obs.virtualCam = obsBool(data, "outputActive")
+		obs.mutex.Unlock()
+	case "ReplayBufferSaved":
+		obs.mutex.Lock()
+		obs.lastReplay = obsString(data, "savedReplayPath")
+		obs.mutex.Unlock()
+	}
+}
@@ -0,0 +1,342 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"keypad/obsws5"
+	"log"
+)
+
+// obsSceneItem is a source added to a scene
+type obsSceneItem struct {
+	id      int
+	source  string
+	enabled bool
+}
+
+// obsSourceCheck returns a check function for commands that have source as first parameter
+// and an optional scene name at sceneindex, unknown sources are reported only as warnings
+// because scenes can be changed while the application is running
+func obsSourceCheck(sceneindex int) func(interface{}, []interface{}) error {
+	return func(target interface{}, parameters []interface{}) error {
+		obs := target.(*obsCommandTarget)
+		scene := ""
+
+		// scene item requests are not translated by the protocol v4 client
+		if obs.protocol == 4 {
+			return fmt.Errorf("Scene item commands require OBS websocket protocol 5")
+		}
+
+		if len(parameters) > sceneindex {
+			scene = parameters[sceneindex].(string)
+		}
+
+		obs.checkSource(scene, parameters[0].(string))
+		return nil
+	}
+}
+
+// obsScene returns the optional scene parameter at index
+func obsScene(parameters []interface{}, index int) string {
+	if len(parameters) > index {
+		return parameters[index].(string)
+	}
+	return ""
+}
+
+func showSourceExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setSourceEnabled(obsScene(parameters, 1), parameters[0].(string), true)
+}
+
+func hideSourceExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setSourceEnabled(obsScene(parameters, 1), parameters[0].(string), false)
+}
+
+func toggleSourceExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.toggleSource(obsScene(parameters, 1), parameters[0].(string))
+}
+
+func setSourcePositionExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	return obs.setSourceTransform(obsScene(parameters, 3), parameters[0].(string), map[string]interface{}{
+		"positionX": FloatParameter(parameters[1]),
+		"positionY": FloatParameter(parameters[2]),
+	})
+}
+
+func setSourceScaleExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	return obs.setSourceTransform(obsScene(parameters, 3), parameters[0].(string), map[string]interface{}{
+		"scaleX": FloatParameter(parameters[1]),
+		"scaleY": FloatParameter(parameters[2]),
+	})
+}
+
+func setSourceCropExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	return obs.setSourceTransform(obsScene(parameters, 5), parameters[0].(string), map[string]interface{}{
+		"cropLeft":   FloatParameter(parameters[1]),
+		"cropTop":    FloatParameter(parameters[2]),
+		"cropRight":  FloatParameter(parameters[3]),
+		"cropBottom": FloatParameter(parameters[4]),
+	})
+}
+
+// checkSource logs a warning if the source is not part of the scene (or of any scene, if
+// scene name is empty), it does nothing if the list of scene items is not available
+func (obs *obsCommandTarget) checkSource(scene string, source string) {
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	if obs.sceneItems == nil {
+		return
+	}
+
+	if scene != "" {
+		items, ok := obs.sceneItems[scene]
+
+		if !ok {
+			log.Printf("Warning: scene %s not found in OBS", scene)
+			return
+		}
+
+		if findSceneItem(items, source) == nil {
+			log.Printf("Warning: source %s not found in OBS scene %s", source, scene)
+		}
+		return
+	}
+
+	for _, items := range obs.sceneItems {
+		if findSceneItem(items, source) != nil {
+			return
+		}
+	}
+
+	log.Printf("Warning: source %s not found in OBS scenes", source)
+}
+
+func findSceneItem(items []obsSceneItem, source string) *obsSceneItem {
+	for index := range items {
+		if items[index].source == source {
+			return &items[index]
+		}
+	}
+	return nil
+}
+
+// getSceneItem returns the id of a scene item of the active scene if scene is empty, items
+// that are not in the cache (ex: just added) are requested to OBS
+func (obs *obsCommandTarget) getSceneItem(scene string, source string) (string, int, error) {
+	obs.mutex.RLock()
+
+	if scene == "" {
+		scene = obs.activeScene
+	}
+
+	item := findSceneItem(obs.sceneItems[scene], source)
+	obs.mutex.RUnlock()
+
+	if item != nil {
+		return scene, item.id, nil
+	}
+
+	resp, err := obs.client.Request("GetSceneItemId", map[string]interface{}{"sceneName": scene, "sourceName": source})
+
+	if _, ok := err.(*obsws5.RequestError); ok {
+		return scene, 0, fmt.Errorf("Source %s not found in scene %s", source, scene)
+	}
+
+	if err != nil {
+		return scene, 0, err
+	}
+	return scene, int(obsNumber(resp, "sceneItemId")), nil
+}
+
+// setSourceEnabled changes visibility of a source
+func (obs *obsCommandTarget) setSourceEnabled(scene string, source string, enabled bool) error {
+	scene, id, err := obs.getSceneItem(scene, source)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = obs.client.Request("SetSceneItemEnabled", map[string]interface{}{
+		"sceneName":        scene,
+		"sceneItemId":      id,
+		"sceneItemEnabled": enabled,
+	})
+	return err
+}
+
+// toggleSource reads visibility of a source from OBS before changing it, the cache may not
+// be updated yet by the events of a previous command
+func (obs *obsCommandTarget) toggleSource(scene string, source string) error {
+	scene, id, err := obs.getSceneItem(scene, source)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := obs.client.Request("GetSceneItemEnabled", map[string]interface{}{"sceneName": scene, "sceneItemId": id})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = obs.client.Request("SetSceneItemEnabled", map[string]interface{}{
+		"sceneName":        scene,
+		"sceneItemId":      id,
+		"sceneItemEnabled": !obsBool(resp, "sceneItemEnabled"),
+	})
+	return err
+}
+
+func (obs *obsCommandTarget) setSourceTransform(scene string, source string, transform map[string]interface{}) error {
+	scene, id, err := obs.getSceneItem(scene, source)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = obs.client.Request("SetSceneItemTransform", map[string]interface{}{
+		"sceneName":          scene,
+		"sceneItemId":        id,
+		"sceneItemTransform": transform,
+	})
+	return err
+}
+
+// obsSceneItems reads the items from a GetSceneItemList response
+func obsSceneItems(data map[string]interface{}) []obsSceneItem {
+	var items []obsSceneItem
+
+	for _, item := range obsList(data, "sceneItems") {
+		if object, ok := item.(map[string]interface{}); ok {
+			items = append(items, obsSceneItem{
+				id:      int(obsNumber(object, "sceneItemId")),
+				source:  obsString(object, "sourceName"),
+				enabled: obsBool(object, "sceneItemEnabled"),
+			})
+		}
+	}
+	return items
+}
+
+// refreshSceneItems reads the items of the scenes, if replace is true items of other scenes
+// are removed from the cache
+func (obs *obsCommandTarget) refreshSceneItems(scenes []string, replace bool) error {
+	requests := make([]obsws5.Request, len(scenes))
+
+	for index, scene := range scenes {
+		requests[index] = obsws5.Request{Type: "GetSceneItemList", Data: map[string]interface{}{"sceneName": scene}}
+	}
+
+	responses, err := obs.client.RequestBatch(requests, false)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	if obs.sceneItems == nil || replace {
+		obs.sceneItems = make(map[string][]obsSceneItem, len(scenes))
+	}
+
+	for index, response := range responses {
+		if response.Err != nil {
+			log.Printf("Error %v reading items of OBS scene %s", response.Err, scenes[index])
+			continue
+		}
+
+		obs.sceneItems[scenes[index]] = obsSceneItems(response.Data)
+	}
+	return nil
+}
+
+// onSceneItemEvent updates the cache of scene items
+func (obs *obsCommandTarget) onSceneItemEvent(eventType string, data map[string]interface{}) {
+	switch eventType {
+	case "SceneItemCreated", "SceneItemRemoved", "SceneCreated":
+		err := obs.refreshSceneItems([]string{obsString(data, "sceneName")}, false)
+
+		if err != nil {
+			log.Printf("Error %v reading OBS scene items", err)
+		}
+	case "SceneRemoved":
+		obs.mutex.Lock()
+		delete(obs.sceneItems, obsString(data, "sceneName"))
+		obs.mutex.Unlock()
+	case "SceneNameChanged":
+		obs.mutex.Lock()
+		items, ok := obs.sceneItems[obsString(data, "oldSceneName")]
+
+		if ok {
+			delete(obs.sceneItems, obsString(data, "oldSceneName"))
+			obs.sceneItems[obsString(data, "sceneName")] = items
+		}
+		obs.mutex.Unlock()
+	case "SceneItemEnableStateChanged":
+		obs.mutex.Lock()
+		items := obs.sceneItems[obsString(data, "sceneName")]
+		id := int(obsNumber(data, "sceneItemId"))
+
+		for index := range items {
+			if items[index].id == id {
+				items[index].enabled = obsBool(data, "sceneItemEnabled")
+			}
+		}
+		obs.mutex.Unlock()
+	case "InputNameChanged":
+		obs.mutex.Lock()
+		for _, items := range obs.sceneItems {
+			for index := range items {
+				if items[index].source == obsString(data, "oldInputName") {
+					items[index].source = obsString(data, "inputName")
+				}
+			}
+		}
+		obs.mutex.Unlock()
+	}
+}
+
+// sceneItemsState returns visibility of sources by scene name
+func (obs *obsCommandTarget) sceneItemsState() map[string]interface{} {
+	state := make(map[string]interface{}, len(obs.sceneItems))
+
+	for scene, items := range obs.sceneItems {
+		sources := make(map[string]interface{}, len(items))
+
+		for _, item := range items {
+			sources[item.source] = item.enabled
+		}
+
+		state[scene] = sources
+	}
+	return state
+}
+
+// sceneSourcesState returns names of the sources in each scene, by scene name
+func (obs *obsCommandTarget) sceneSourcesState() map[string]interface{} {
+	state := make(map[string]interface{}, len(obs.sceneItems))
+
+	for scene, items := range obs.sceneItems {
+		sources := make([]interface{}, len(items))
+
+		for index, item := range items {
+			sources[index] = item.source
+		}
+
+		state[scene] = sources
+	}
+	return state
+}
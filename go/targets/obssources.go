@@ -0,0 +1,95 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// sourceExists reports if a source is known, it returns true when the list of inputs has
+// not been read yet, so commands can be validated before OBS is connected
+func (obs *obsCommandTarget) sourceExists(source string) bool {
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	if obs.inputKinds == nil {
+		return true
+	}
+
+	_, ok := obs.inputKinds[source]
+	return ok || indexOf(obs.scenes, source) != -1
+}
+
+func setTextCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	source := parameters[0].(string)
+
+	if !obs.sourceExists(source) {
+		log.Printf("Warning: text source %s not found in OBS", source)
+		return nil
+	}
+
+	obs.mutex.RLock()
+	kind, ok := obs.inputKinds[source]
+	obs.mutex.RUnlock()
+
+	// GDI+ (text_gdiplus) and FreeType 2 (text_ft2_source) text sources
+	if ok && !strings.HasPrefix(kind, "text_") {
+		return fmt.Errorf("Source %s is not a text source", source)
+	}
+	return nil
+}
+
+func filterCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	if !obs.sourceExists(parameters[0].(string)) {
+		log.Printf("Warning: source %s not found in OBS", parameters[0])
+	}
+	return nil
+}
+
+func setTextExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("SetInputSettings", map[string]interface{}{
+		"inputName":     parameters[0],
+		"inputSettings": map[string]interface{}{"text": fmt.Sprint(parameters[1])},
+		"overlay":       true,
+	})
+	return err
+}
+
+func enableFilterExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setFilterEnabled(parameters[0].(string), parameters[1].(string), func(bool) bool { return true })
+}
+
+func disableFilterExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setFilterEnabled(parameters[0].(string), parameters[1].(string), func(bool) bool { return false })
+}
+
+func toggleFilterExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setFilterEnabled(parameters[0].(string), parameters[1].(string), func(enabled bool) bool { return !enabled })
+}
+
+// setFilterEnabled changes state of a filter, enabled computes the new state from the current one
+func (obs *obsCommandTarget) setFilterEnabled(source string, filter string, enabled func(bool) bool) error {
+	resp, err := obs.client.Request("GetSourceFilter", map[string]interface{}{"sourceName": source, "filterName": filter})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = obs.client.Request("SetSourceFilterEnabled", map[string]interface{}{
+		"sourceName":    source,
+		"filterName":    filter,
+		"filterEnabled": enabled(obsBool(resp, "filterEnabled")),
+	})
+	return err
+}
@@ -0,0 +1,129 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"keypad/obsws5"
+	"log"
+	"time"
+)
+
+const obsDefaultStatsInterval = 2 * time.Second
+
+// obsStats are performance statistics and outputs status, read periodically
+type obsStats struct {
+	cpuUsage            float64 // %
+	memoryUsage         float64 // MB
+	activeFps           float64
+	renderSkippedFrames float64
+	outputSkippedFrames float64
+	streamTimecode      string
+	streamDuration      float64 // ms
+	streamDroppedFrames float64
+	streamTotalFrames   float64
+	streamCongestion    float64 // 0-1
+	recordTimecode      string
+	recordDuration      float64 // ms
+	recordBytes         float64
+}
+
+// pollStats reads statistics every obs.statsInterval, until done is closed
+func (obs *obsCommandTarget) pollStats(done chan struct{}) {
+	ticker := time.NewTicker(obs.statsInterval)
+	defer ticker.Stop()
+
+	lasterror := ""
+
+	for {
+		select {
+		case <-done:
+			obs.mutex.Lock()
+			obs.stats = obsStats{}
+			obs.mutex.Unlock()
+			return
+		case <-ticker.C:
+			message := ""
+
+			if err := obs.refreshStats(); err != nil {
+				message = err.Error()
+			}
+
+			// errors are logged only when they change, since this runs every few seconds
+			if message != "" && message != lasterror {
+				log.Printf("Error %s reading OBS statistics", message)
+			}
+
+			lasterror = message
+		}
+	}
+}
+
+// refreshStats reads statistics and status of stream and recording, values of failed
+// requests are reset and the first error is returned
+func (obs *obsCommandTarget) refreshStats() error {
+	responses, err := obs.client.RequestBatch([]obsws5.Request{
+		{Type: "GetStats"},
+		{Type: "GetStreamStatus"},
+		{Type: "GetRecordStatus"},
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
+	var stats obsStats
+	var failed error
+
+	for _, response := range responses {
+		if response.Err != nil {
+			if failed == nil {
+				failed = response.Err
+			}
+			continue
+		}
+
+		data := response.Data
+
+		switch response.Type {
+		case "GetStats":
+			stats.cpuUsage = obsNumber(data, "cpuUsage")
+			stats.memoryUsage = obsNumber(data, "memoryUsage")
+			stats.activeFps = obsNumber(data, "activeFps")
+			stats.renderSkippedFrames = obsNumber(data, "renderSkippedFrames")
+			stats.outputSkippedFrames = obsNumber(data, "outputSkippedFrames")
+		case "GetStreamStatus":
+			stats.streamTimecode = obsString(data, "outputTimecode")
+			stats.streamDuration = obsNumber(data, "outputDuration")
+			stats.streamDroppedFrames = obsNumber(data, "outputSkippedFrames")
+			stats.streamTotalFrames = obsNumber(data, "outputTotalFrames")
+			stats.streamCongestion = obsNumber(data, "outputCongestion")
+		case "GetRecordStatus":
+			stats.recordTimecode = obsString(data, "outputTimecode")
+			stats.recordDuration = obsNumber(data, "outputDuration")
+			stats.recordBytes = obsNumber(data, "outputBytes")
+		}
+	}
+
+	obs.mutex.Lock()
+	obs.stats = stats
+	obs.mutex.Unlock()
+	return failed
+}
+
+// statsState adds statistics to target state
+func (obs *obsCommandTarget) statsState(state map[string]interface{}) {
+	state["cpuUsage"] = obs.stats.cpuUsage
+	state["memoryUsage"] = obs.stats.memoryUsage
+	state["activeFps"] = obs.stats.activeFps
+	state["renderSkippedFrames"] = obs.stats.renderSkippedFrames
+	state["outputSkippedFrames"] = obs.stats.outputSkippedFrames
+	state["streamTimecode"] = obs.stats.streamTimecode
+	state["streamDuration"] = obs.stats.streamDuration
+	state["streamDroppedFrames"] = obs.stats.streamDroppedFrames
+	state["streamTotalFrames"] = obs.stats.streamTotalFrames
+	state["streamCongestion"] = obs.stats.streamCongestion
+	state["recordTimecode"] = obs.stats.recordTimecode
+	state["recordDuration"] = obs.stats.recordDuration
+	state["recordBytes"] = obs.stats.recordBytes
+}
@@ -0,0 +1,205 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testOBSResponses = map[string]map[string]interface{}{
+	"GetStats": {
+		"cpuUsage":            12.5,
+		"memoryUsage":         512.0,
+		"activeFps":           60.0,
+		"renderSkippedFrames": 3.0,
+		"outputSkippedFrames": 4.0,
+	},
+	"GetStreamStatus": {
+		"outputActive":        true,
+		"outputTimecode":      "00:01:00.000",
+		"outputDuration":      60000.0,
+		"outputSkippedFrames": 7.0,
+		"outputTotalFrames":   3600.0,
+		"outputCongestion":    0.25,
+	},
+	"GetRecordStatus": {
+		"outputActive":   true,
+		"outputPaused":   true,
+		"outputTimecode": "00:00:30.000",
+		"outputDuration": 30000.0,
+		"outputBytes":    1048576.0,
+	},
+}
+
+var testOBSStats = map[string]interface{}{
+	"cpuUsage":            12.5,
+	"memoryUsage":         512.0,
+	"activeFps":           60.0,
+	"renderSkippedFrames": 3.0,
+	"outputSkippedFrames": 4.0,
+	"streamTimecode":      "00:01:00.000",
+	"streamDuration":      60000.0,
+	"streamDroppedFrames": 7.0,
+	"streamTotalFrames":   3600.0,
+	"streamCongestion":    0.25,
+	"recordTimecode":      "00:00:30.000",
+	"recordDuration":      30000.0,
+	"recordBytes":         1048576.0,
+}
+
+// newStatsOBSTarget returns a target connected to a client answering with testOBSResponses,
+// requests listed in failing fail
+func newStatsOBSTarget(failing ...string) (*obsCommandTarget, *fakeOBSClient) {
+	client := &fakeOBSClient{respond: func(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+		if indexOf(failing, requestType) != -1 {
+			return nil, fmt.Errorf("Request %s failed", requestType)
+		}
+		return testOBSResponses[requestType], nil
+	}}
+
+	return newConnectedOBSTarget(client), client
+}
+
+// statsValues returns the statistics values of the target state
+func statsValues(obs *obsCommandTarget) map[string]interface{} {
+	state := obs.GetState()
+	values := make(map[string]interface{}, len(testOBSStats))
+
+	for name := range testOBSStats {
+		values[name] = state[name]
+	}
+	return values
+}
+
+func TestOBSStats(t *testing.T) {
+	obs, _ := newStatsOBSTarget()
+
+	err := obs.refreshStats()
+
+	if err != nil {
+		t.Fatalf("Statistics failed: %v", err)
+	}
+
+	if values := statsValues(obs); !reflect.DeepEqual(values, testOBSStats) {
+		t.Errorf("Statistics are %v instead of %v", values, testOBSStats)
+	}
+}
+
+// TestOBSStatsErrors checks that values of failed requests are reset, while the others are updated
+func TestOBSStatsErrors(t *testing.T) {
+	obs, client := newStatsOBSTarget("GetStreamStatus")
+
+	obs.refreshStats()
+
+	expected := make(map[string]interface{}, len(testOBSStats))
+
+	for name, value := range testOBSStats {
+		expected[name] = value
+	}
+
+	expected["streamTimecode"] = ""
+
+	for _, name := range []string{"streamDuration", "streamDroppedFrames", "streamTotalFrames", "streamCongestion"} {
+		expected[name] = 0.0
+	}
+
+	// values of the previous read are replaced
+	obs.stats.streamDuration = 100
+
+	err := obs.refreshStats()
+
+	if err == nil || err.Error() != "Request GetStreamStatus failed" {
+		t.Errorf("Statistics returned %v", err)
+	}
+
+	if values := statsValues(obs); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Statistics are %v instead of %v", values, expected)
+	}
+
+	client.batch = fmt.Errorf("OBS is not connected")
+
+	err = obs.refreshStats()
+
+	if err != client.batch {
+		t.Errorf("Statistics returned %v", err)
+	}
+}
+
+// TestOBSPollStats checks that statistics are read periodically, errors are logged only when
+// they change, and values are reset when polling stops
+func TestOBSPollStats(t *testing.T) {
+	obs, client := newStatsOBSTarget()
+	obs.statsInterval = 5 * time.Millisecond
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	output := captureLog(func() {
+		client.batch = fmt.Errorf("Timeout")
+
+		go func() {
+			obs.pollStats(done)
+			close(stopped)
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+
+		client.mutex.Lock()
+		client.batch = nil
+		client.mutex.Unlock()
+
+		deadline := time.Now().Add(5 * time.Second)
+
+		for statsValues(obs)["activeFps"] != 60.0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Statistics not read")
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		close(done)
+		<-stopped
+	})
+
+	if strings.Count(output, "Error Timeout reading OBS statistics") != 1 {
+		t.Errorf("Errors logged %q", output)
+	}
+
+	if obs.stats != (obsStats{}) {
+		t.Errorf("Statistics not reset when polling stopped: %v", obs.stats)
+	}
+}
+
+func TestOBSRefreshState(t *testing.T) {
+	tests := []struct {
+		failing []string
+		results int
+		error   string
+	}{
+		{nil, 0, ""},
+		{[]string{"GetRecordStatus"}, 0, "Request GetRecordStatus failed"},
+		{[]string{"GetStreamStatus"}, 0, "Request GetStreamStatus failed"},
+		{nil, 1, "OBS returned 1 results for stream and recording status requests"},
+	}
+
+	for _, test := range tests {
+		obs, client := newStatsOBSTarget(test.failing...)
+		client.results = test.results
+
+		err := obs.refreshOBSState()
+
+		if test.error == "" && (err != nil || !obs.streaming || !obs.recording || !obs.recordingPaused) {
+			t.Errorf("State refresh returned %v, state %v %v %v", err, obs.streaming, obs.recording, obs.recordingPaused)
+		}
+
+		if test.error != "" && (err == nil || err.Error() != test.error) {
+			t.Errorf("State refresh with %v failed returned %v, expected %q", test.failing, err, test.error)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"keypad/obsws5"
+	"log"
+	"time"
+)
+
+// obsTransition is a transition with its duration
+type obsTransition struct {
+	name     string
+	duration int // ms
+}
+
+// obsTransitionCheck reports transitions that are not known to OBS as warnings
+func obsTransitionCheck(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	obs.mutex.RLock()
+	defer obs.mutex.RUnlock()
+
+	if obs.transitions != nil && indexOf(obs.transitions, parameters[0].(string)) == -1 {
+		log.Printf("Warning: transition %s not found in OBS", parameters[0])
+	}
+	return nil
+}
+
+// obsMilliseconds converts a validated duration parameter to milliseconds
+func obsMilliseconds(value interface{}) int {
+	duration, _ := ParseDuration(value)
+	return int(duration / time.Millisecond)
+}
+
+func enableStudioModeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setStudioMode(true)
+}
+
+func disableStudioModeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setStudioMode(false)
+}
+
+func toggleStudioModeExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setStudioMode(!obs.getFlag(&obs.studioMode))
+}
+
+func setPreviewSceneExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	return obs.setPreviewScene(parameters[0].(string))
+}
+
+func prevPreviewSceneExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	obs.mutex.RLock()
+	scene := adjacentItem(obs.scenes, obs.previewScene, -1)
+	obs.mutex.RUnlock()
+	return obs.setPreviewScene(scene)
+}
+
+func nextPreviewSceneExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	obs.mutex.RLock()
+	scene := adjacentItem(obs.scenes, obs.previewScene, 1)
+	obs.mutex.RUnlock()
+	return obs.setPreviewScene(scene)
+}
+
+func transitionToProgramExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("TriggerStudioModeTransition", nil)
+	return err
+}
+
+func setTransitionExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	transition := obsTransition{name: parameters[0].(string)}
+
+	if len(parameters) > 1 {
+		transition.duration = obsMilliseconds(parameters[1])
+	}
+	return obs.setTransition(transition)
+}
+
+func setTransitionDurationExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+
+	_, err := obs.client.Request("SetCurrentSceneTransitionDuration", map[string]interface{}{"transitionDuration": obsMilliseconds(parameters[0])})
+	return err
+}
+
+// quickTransitionExec moves preview to program using a transition different from the current
+// one, current transition is restored when the transition ends
+func quickTransitionExec(target interface{}, parameters []interface{}) error {
+	obs := target.(*obsCommandTarget)
+	transition := obsTransition{name: parameters[0].(string)}
+
+	if len(parameters) > 1 {
+		transition.duration = obsMilliseconds(parameters[1])
+	}
+
+	obs.mutex.Lock()
+	current := obs.transition
+
+	if obs.restoreTransition != nil {
+		// a quick transition is already running
+		current = *obs.restoreTransition
+	}
+	obs.mutex.Unlock()
+
+	err := obs.setTransition(transition)
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	obs.restoreTransition = &current
+	obs.mutex.Unlock()
+
+	_, err = obs.client.Request("TriggerStudioModeTransition", nil)
+
+	if err != nil {
+		obs.restoreCurrentTransition()
+	}
+	return err
+}
+
+func (obs *obsCommandTarget) setStudioMode(enabled bool) error {
+	_, err := obs.client.Request("SetStudioModeEnabled", map[string]interface{}{"studioModeEnabled": enabled})
+	return err
+}
+
+func (obs *obsCommandTarget) setPreviewScene(scene string) error {
+	_, err := obs.client.Request("SetCurrentPreviewScene", map[string]interface{}{"sceneName": scene})
+
+	if err != nil {
+		return err
+	}
+
+	obs.mutex.Lock()
+	obs.previewScene = scene
+	obs.mutex.Unlock()
+	return nil
+}
+
+// setTransition changes current transition, its duration is changed only if it's not zero
+func (obs *obsCommandTarget) setTransition(transition obsTransition) error {
+	requests := []obsws5.Request{
+		{Type: "SetCurrentSceneTransition", Data: map[string]interface{}{"transitionName": transition.name}},
+	}
+
+	if transition.duration != 0 {
+		requests = append(requests, obsws5.Request{Type: "SetCurrentSceneTransitionDuration", Data: map[string]interface{}{"transitionDuration": transition.duration}})
+	}
+
+	responses, err := obs.client.RequestBatch(requests, true)
+
+	if err != nil {
+		return err
+	}
+
+	for _, response := range responses {
+		if response.Err != nil {
+			return response.Err
+		}
+	}
+	return nil
+}
+
+// restoreCurrentTransition sets the transition that was active before a quick transition
+func (obs *obsCommandTarget) restoreCurrentTransition() {
+	obs.mutex.Lock()
+	transition := obs.restoreTransition
+	obs.restoreTransition = nil
+	obs.mutex.Unlock()
+
+	if transition == nil || transition.name == "" {
+		return
+	}
+
+	err := obs.setTransition(*transition)
+
+	if err != nil {
+		log.Printf("Error %v restoring OBS transition %s", err, transition.name)
+	}
+}
+
+// refreshStudioMode reads studio mode state and transitions
+func (obs *obsCommandTarget) refreshStudioMode() error {
+	responses, err := obs.client.RequestBatch([]obsws5.Request{
+		{Type: "GetStudioModeEnabled"},
+		{Type: "GetSceneTransitionList"},
+		{Type: "GetCurrentSceneTransition"},
+	}, true)
+
+	if err != nil {
+		return err
+	}
+
+	for _, response := range responses {
+		if response.Err != nil {
+			return response.Err
+		}
+	}
+
+	preview := ""
+	studiomode := obsBool(responses[0].Data, "studioModeEnabled")
+
+	if studiomode {
+		resp, err := obs.client.Request("GetCurrentPreviewScene", nil)
+
+		if err != nil {
+			return err
+		}
+
+		preview = obsString(resp, "currentPreviewSceneName")
+	}
+
+	obs.mutex.Lock()
+	defer obs.mutex.Unlock()
+
+	obs.studioMode = studiomode
+	obs.previewScene = preview
+	obs.transitions = obsStrings(responses[1].Data, "transitions", "transitionName")
+	obs.transition = obsTransition{
+		name:     obsString(responses[2].Data, "transitionName"),
+		duration: int(obsNumber(responses[2].Data, "transitionDuration")),
+	}
+	return nil
+}
+
+// onStudioModeEvent updates studio mode and transitions state
+func (obs *obsCommandTarget) onStudioModeEvent(eventType string, data map[string]interface{}) {
+	switch eventType {
+	case "StudioModeStateChanged":
+		obs.mutex.Lock()
+		obs.studioMode = obsBool(data, "studioModeEnabled")
+
+		if !obs.studioMode {
+			obs.previewScene = ""
+		}
+		obs.mutex.Unlock()
+	case "CurrentPreviewSceneChanged":
+		obs.mutex.Lock()
+		obs.previewScene = obsString(data, "sceneName")
+		obs.mutex.Unlock()
+	case "CurrentSceneTransitionChanged":
+		obs.mutex.Lock()
+		obs.transition.name = obsString(data, "transitionName")
+		obs.mutex.Unlock()
+	case "CurrentSceneTransitionDurationChanged":
+		obs.mutex.Lock()
+		obs.transition.duration = int(obsNumber(data, "transitionDuration"))
+		obs.mutex.Unlock()
+	case "SceneTransitionCreated", "SceneTransitionRemoved", "SceneTransitionNameChanged":
+		resp, err := obs.client.Request("GetSceneTransitionList", nil)
+
+		if err != nil {
+			log.Printf("Error %v reading OBS transitions", err)
+			return
+		}
+
+		obs.mutex.Lock()
+		obs.transitions = obsStrings(resp, "transitions", "transitionName")
+		obs.mutex.Unlock()
+	case "SceneTransitionEnded":
+		obs.restoreCurrentTransition()
+	}
+}
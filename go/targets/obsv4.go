@@ -0,0 +1,483 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"context"
+	"fmt"
+	"keypad/obsws5"
+	"math"
+	"sync"
+	"time"
+
+	obsws "github.com/christopher-dG/go-obs-websocket"
+)
+
+// obsV4Client implements obsClient using obs-websocket protocol v4 (OBS 27 and older),
+// it supports only the requests and events required by basic scenes and outputs control
+type obsV4Client struct {
+	client  obsws.Client
+	handler func(eventType string, data map[string]interface{})
+	timeout time.Duration
+	slot    chan struct{} // held by the request being sent
+}
+
+// the receive timeout of the library is global, it's set to the longest timeout of all the
+// clients, so a request is never waited for forever, while each client enforces its own timeout
+var obsV4ReceiveTimeout time.Duration
+var obsV4TimeoutMutex sync.Mutex
+
+func newOBSV4Client(host string, port int, password string, timeout time.Duration) *obsV4Client {
+	obsV4TimeoutMutex.Lock()
+	defer obsV4TimeoutMutex.Unlock()
+
+	if timeout > obsV4ReceiveTimeout {
+		obsV4ReceiveTimeout = timeout
+		obsws.SetReceiveTimeout(timeout)
+	}
+
+	return &obsV4Client{client: obsws.Client{Host: host, Port: port, Password: password}, timeout: timeout, slot: make(chan struct{}, 1)}
+}
+
+func (c *obsV4Client) Connect() error {
+	err := c.client.Connect()
+
+	if err != nil {
+		return err
+	}
+
+	// handlers are reset on each connection
+	c.client.AddEventHandler("SwitchScenes", func(e obsws.Event) {
+		c.emit("CurrentProgramSceneChanged", map[string]interface{}{"sceneName": e.(obsws.SwitchScenesEvent).SceneName})
+	})
+	// events reporting scenes and collections don't include them in all the versions of the library
+	c.client.AddEventHandler("ScenesChanged", func(e obsws.Event) {
+		data, err := c.Request("GetSceneList", nil)
+
+		if err == nil {
+			c.emit("SceneListChanged", map[string]interface{}{"scenes": data["scenes"]})
+		}
+	})
+	c.client.AddEventHandler("SceneCollectionChanged", func(e obsws.Event) {
+		data, err := c.Request("GetSceneCollectionList", nil)
+
+		if err == nil {
+			c.emit("CurrentSceneCollectionChanged", map[string]interface{}{"sceneCollectionName": data["currentSceneCollectionName"]})
+		}
+	})
+	c.client.AddEventHandler("SceneCollectionListChanged", func(e obsws.Event) {
+		data, err := c.Request("GetSceneCollectionList", nil)
+
+		if err == nil {
+			c.emit("SceneCollectionListChanged", data)
+		}
+	})
+	c.client.AddEventHandler("SourceMuteStateChanged", func(e obsws.Event) {
+		event := e.(obsws.SourceMuteStateChangedEvent)
+		c.emit("InputMuteStateChanged", map[string]interface{}{"inputName": event.SourceName, "inputMuted": event.Muted})
+	})
+	c.client.AddEventHandler("SourceVolumeChanged", func(e obsws.Event) {
+		event := e.(obsws.SourceVolumeChangedEvent)
+		c.emit("InputVolumeChanged", map[string]interface{}{"inputName": event.SourceName, "inputVolumeMul": event.Volume, "inputVolumeDb": v4Decibels(event.Volume)})
+	})
+	c.client.AddEventHandler("StudioModeSwitched", func(e obsws.Event) {
+		c.emit("StudioModeStateChanged", map[string]interface{}{"studioModeEnabled": e.(obsws.StudioModeSwitchedEvent).NewState})
+	})
+	c.client.AddEventHandler("PreviewSceneChanged", func(e obsws.Event) {
+		c.emit("CurrentPreviewSceneChanged", map[string]interface{}{"sceneName": e.(obsws.PreviewSceneChangedEvent).SceneName})
+	})
+	c.client.AddEventHandler("SwitchTransition", func(e obsws.Event) {
+		c.emit("CurrentSceneTransitionChanged", map[string]interface{}{"transitionName": e.(obsws.SwitchTransitionEvent).TransitionName})
+	})
+	c.client.AddEventHandler("TransitionDurationChanged", func(e obsws.Event) {
+		c.emit("CurrentSceneTransitionDurationChanged", map[string]interface{}{"transitionDuration": float64(e.(obsws.TransitionDurationChangedEvent).NewDuration)})
+	})
+	c.client.AddEventHandler("TransitionListChanged", func(e obsws.Event) {
+		c.emit("SceneTransitionCreated", map[string]interface{}{})
+	})
+	// protocol v4 does not report the end of transitions
+	c.client.AddEventHandler("TransitionBegin", func(e obsws.Event) {
+		event := e.(obsws.TransitionBeginEvent)
+
+		time.AfterFunc(time.Duration(event.Duration)*time.Millisecond, func() {
+			c.emit("SceneTransitionEnded", map[string]interface{}{"transitionName": event.Name})
+		})
+	})
+	c.client.AddEventHandler("ReplayStarting", func(e obsws.Event) {
+		c.emit("ReplayBufferStateChanged", map[string]interface{}{"outputActive": true, "outputState": "OBS_WEBSOCKET_OUTPUT_STARTED"})
+	})
+	c.client.AddEventHandler("ReplayStopping", func(e obsws.Event) {
+		c.emit("ReplayBufferStateChanged", map[string]interface{}{"outputActive": false, "outputState": "OBS_WEBSOCKET_OUTPUT_STOPPED"})
+	})
+	c.client.AddEventHandler("RecordingStarting", func(e obsws.Event) {
+		c.emit("RecordStateChanged", map[string]interface{}{"outputActive": true, "outputState": "OBS_WEBSOCKET_OUTPUT_STARTED"})
+	})
+	c.client.AddEventHandler("RecordingStopping", func(e obsws.Event) {
+		c.emit("RecordStateChanged", map[string]interface{}{"outputActive": false, "outputState": "OBS_WEBSOCKET_OUTPUT_STOPPED"})
+	})
+	c.client.AddEventHandler("RecordingPaused", func(e obsws.Event) {
+		c.emit("RecordStateChanged", map[string]interface{}{"outputActive": true, "outputState": "OBS_WEBSOCKET_OUTPUT_PAUSED"})
+	})
+	c.client.AddEventHandler("RecordingResumed", func(e obsws.Event) {
+		c.emit("RecordStateChanged", map[string]interface{}{"outputActive": true, "outputState": "OBS_WEBSOCKET_OUTPUT_RESUMED"})
+	})
+	c.client.AddEventHandler("StreamStarting", func(e obsws.Event) {
+		c.emit("StreamStateChanged", map[string]interface{}{"outputActive": true, "outputState": "OBS_WEBSOCKET_OUTPUT_STARTED"})
+	})
+	c.client.AddEventHandler("StreamStopping", func(e obsws.Event) {
+		c.emit("StreamStateChanged", map[string]interface{}{"outputActive": false, "outputState": "OBS_WEBSOCKET_OUTPUT_STOPPED"})
+	})
+	return nil
+}
+
+func (c *obsV4Client) emit(eventType string, data map[string]interface{}) {
+	if c.handler != nil {
+		c.handler(eventType, data)
+	}
+}
+
+func (c *obsV4Client) Disconnect() {
+	if c.client.Connected() {
+		c.client.Disconnect()
+	}
+}
+
+func (c *obsV4Client) Connected() bool {
+	return c.client.Connected()
+}
+
+// SetEventHandler must be called before Connect
+func (c *obsV4Client) SetEventHandler(handler func(eventType string, data map[string]interface{})) {
+	c.handler = handler
+}
+
+// v4Scenes converts a list of scenes to the format used by protocol v5
+func v4Scenes(scenes []*obsws.Scene) []interface{} {
+	items := make([]interface{}, len(scenes))
+
+	for index, scene := range scenes {
+		items[index] = map[string]interface{}{"sceneName": scene.Name, "sceneIndex": float64(len(scenes) - 1 - index)}
+	}
+	return items
+}
+
+// v4Decibels converts a volume multiplier to dB, using nil for silence as protocol v5 does
+func v4Decibels(volume float64) interface{} {
+	if volume <= 0 {
+		return nil
+	}
+	return 20 * math.Log10(volume)
+}
+
+// v4Duration converts a timecode (HH:MM:SS.mmm) to milliseconds, v4 reports only timecodes
+func v4Duration(timecode string) float64 {
+	var hours, minutes int
+	var seconds float64
+
+	if _, err := fmt.Sscanf(timecode, "%d:%d:%f", &hours, &minutes, &seconds); err != nil {
+		return 0
+	}
+	return float64(hours*3600000+minutes*60000) + seconds*1000
+}
+
+type obsV4Result struct {
+	data map[string]interface{}
+	err  error
+}
+
+// Request translates a protocol v5 request to the corresponding v4 one, waiting for the
+// response at most the timeout of the client
+func (c *obsV4Client) Request(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	return c.RequestContext(context.Background(), requestType, data)
+}
+
+// RequestContext sends a request, waiting for the response until ctx is done or, if ctx has
+// no deadline, until the timeout of the client expires. Requests are sent one at a time, since
+// the library does not support concurrent writes on the connection, a request that times out
+// keeps its slot until the library gives up waiting for the response
+func (c *obsV4Client) RequestContext(ctx context.Context, requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	select {
+	case c.slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Timeout waiting to send OBS request %s", requestType)
+	}
+
+	result := make(chan obsV4Result, 1)
+
+	go func() {
+		defer func() { <-c.slot }()
+
+		data, err := c.request(requestType, data)
+		result <- obsV4Result{data: data, err: err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Timeout waiting for OBS response to request %s", requestType)
+	}
+}
+
+// v4SceneCollections reads the list of scene collections from a raw response, OBS reports names
+// as strings or as objects, depending on its version
+func v4SceneCollections(response map[string]interface{}) ([]interface{}, error) {
+	if response["status"] != obsws.StatusOK {
+		return nil, fmt.Errorf("%v", response["error"])
+	}
+
+	list, _ := response["scene-collections"].([]interface{})
+	collections := make([]interface{}, 0, len(list))
+
+	for _, item := range list {
+		if collection, ok := item.(map[string]interface{}); ok {
+			item = collection["name"]
+		}
+
+		if name, ok := item.(string); ok {
+			collections = append(collections, name)
+		}
+	}
+	return collections, nil
+}
+
+func (c *obsV4Client) request(requestType string, data map[string]interface{}) (map[string]interface{}, error) {
+	var err error
+
+	switch requestType {
+	case "GetVersion":
+		var resp obsws.GetVersionResponse
+
+		resp, err = obsws.NewGetVersionRequest().SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"obsVersion": resp.OBSStudioVersion, "obsWebSocketVersion": resp.OBSWebsocketVersion}, nil
+		}
+	case "GetSceneList":
+		var resp obsws.GetSceneListResponse
+
+		resp, err = obsws.NewGetSceneListRequest().SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"currentProgramSceneName": resp.CurrentScene, "scenes": v4Scenes(resp.Scenes)}, nil
+		}
+	case "GetSceneItemList":
+		var resp obsws.GetSceneListResponse
+
+		resp, err = obsws.NewGetSceneListRequest().SendReceive(c.client)
+
+		if err == nil {
+			for _, scene := range resp.Scenes {
+				if scene.Name == obsString(data, "sceneName") {
+					items := make([]interface{}, len(scene.Sources))
+
+					for index, item := range scene.Sources {
+						items[index] = map[string]interface{}{"sceneItemId": float64(item.ID), "sourceName": item.Name, "sceneItemEnabled": item.Render}
+					}
+					return map[string]interface{}{"sceneItems": items}, nil
+				}
+			}
+			return nil, fmt.Errorf("Invalid scene name %s", obsString(data, "sceneName"))
+		}
+	case "SetCurrentProgramScene":
+		_, err = obsws.NewSetCurrentSceneRequest(obsString(data, "sceneName")).SendReceive(c.client)
+	case "GetSceneCollectionList":
+		var response chan map[string]interface{}
+		var collections []interface{}
+		var current obsws.GetCurrentSceneCollectionResponse
+
+		// the raw response is decoded here, since its format changed in OBS websocket 4.8
+		request := obsws.NewListSceneCollectionsRequest()
+
+		response, err = c.client.SendRequest(&request)
+
+		if err == nil {
+			select {
+			case raw := <-response:
+				collections, err = v4SceneCollections(raw)
+			case <-time.After(c.timeout):
+				err = obsws.ErrReceiveTimeout
+			}
+		}
+
+		if err == nil {
+			current, err = obsws.NewGetCurrentSceneCollectionRequest().SendReceive(c.client)
+		}
+
+		if err == nil {
+			return map[string]interface{}{"currentSceneCollectionName": current.ScName, "sceneCollections": collections}, nil
+		}
+	case "SetCurrentSceneCollection":
+		_, err = obsws.NewSetCurrentSceneCollectionRequest(obsString(data, "sceneCollectionName")).SendReceive(c.client)
+	case "GetRecordStatus", "GetStreamStatus":
+		var resp obsws.GetStreamingStatusResponse
+
+		resp, err = obsws.NewGetStreamingStatusRequest().SendReceive(c.client)
+
+		if err == nil {
+			if requestType == "GetStreamStatus" {
+				return map[string]interface{}{"outputActive": resp.Streaming, "outputTimecode": resp.StreamTimecode, "outputDuration": v4Duration(resp.StreamTimecode)}, nil
+			}
+			return map[string]interface{}{"outputActive": resp.Recording, "outputPaused": false, "outputTimecode": resp.RecTimecode, "outputDuration": v4Duration(resp.RecTimecode)}, nil
+		}
+	case "GetStats":
+		var resp obsws.GetStatsResponse
+
+		resp, err = obsws.NewGetStatsRequest().SendReceive(c.client)
+
+		if err == nil && resp.Stats != nil {
+			return map[string]interface{}{
+				"cpuUsage":            resp.Stats.CPUUsage,
+				"memoryUsage":         resp.Stats.MemoryUsage,
+				"activeFps":           resp.Stats.FPS,
+				"renderSkippedFrames": float64(resp.Stats.RenderMissedFrames),
+				"outputSkippedFrames": float64(resp.Stats.OutputMissedFrames),
+			}, nil
+		}
+	case "GetInputList":
+		var resp obsws.GetSourcesListResponse
+
+		resp, err = obsws.NewGetSourcesListRequest().SendReceive(c.client)
+
+		if err == nil {
+			var inputs []interface{}
+
+			for _, source := range resp.Sources {
+				if source["type"] == "input" {
+					inputs = append(inputs, map[string]interface{}{"inputName": source["name"], "unversionedInputKind": source["typeId"]})
+				}
+			}
+			return map[string]interface{}{"inputs": inputs}, nil
+		}
+	case "GetInputMute":
+		var resp obsws.GetMuteResponse
+
+		resp, err = obsws.NewGetMuteRequest(obsString(data, "inputName")).SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"inputMuted": resp.Muted}, nil
+		}
+	case "SetInputMute":
+		_, err = obsws.NewSetMuteRequest(obsString(data, "inputName"), obsBool(data, "inputMuted")).SendReceive(c.client)
+	case "ToggleInputMute":
+		_, err = obsws.NewToggleMuteRequest(obsString(data, "inputName")).SendReceive(c.client)
+	case "GetInputVolume":
+		var resp obsws.GetVolumeResponse
+
+		resp, err = obsws.NewGetVolumeRequest(obsString(data, "inputName")).SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"inputVolumeMul": resp.Volume, "inputVolumeDb": v4Decibels(resp.Volume)}, nil
+		}
+	case "SetInputVolume":
+		_, err = obsws.NewSetVolumeRequest(obsString(data, "inputName"), math.Pow(10, obsNumber(data, "inputVolumeDb")/20)).SendReceive(c.client)
+	case "GetStudioModeEnabled":
+		var resp obsws.GetStudioModeStatusResponse
+
+		resp, err = obsws.NewGetStudioModeStatusRequest().SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"studioModeEnabled": resp.StudioMode}, nil
+		}
+	case "SetStudioModeEnabled":
+		if obsBool(data, "studioModeEnabled") {
+			_, err = obsws.NewEnableStudioModeRequest().SendReceive(c.client)
+		} else {
+			_, err = obsws.NewDisableStudioModeRequest().SendReceive(c.client)
+		}
+	case "GetCurrentPreviewScene":
+		var resp obsws.GetPreviewSceneResponse
+
+		resp, err = obsws.NewGetPreviewSceneRequest().SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"currentPreviewSceneName": resp.Name}, nil
+		}
+	case "SetCurrentPreviewScene":
+		_, err = obsws.NewSetPreviewSceneRequest(obsString(data, "sceneName")).SendReceive(c.client)
+	case "TriggerStudioModeTransition":
+		_, err = obsws.NewTransitionToProgramRequest(nil, "", 0).SendReceive(c.client)
+	case "GetSceneTransitionList":
+		var resp obsws.GetTransitionListResponse
+
+		resp, err = obsws.NewGetTransitionListRequest().SendReceive(c.client)
+
+		if err == nil {
+			transitions := make([]interface{}, len(resp.Transitions))
+
+			for index, transition := range resp.Transitions {
+				transitions[index] = map[string]interface{}{"transitionName": transition["name"]}
+			}
+			return map[string]interface{}{"currentSceneTransitionName": resp.CurrentTransition, "transitions": transitions}, nil
+		}
+	case "GetCurrentSceneTransition":
+		var resp obsws.GetCurrentTransitionResponse
+
+		resp, err = obsws.NewGetCurrentTransitionRequest().SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"transitionName": resp.Name, "transitionDuration": float64(resp.Duration)}, nil
+		}
+	case "SetCurrentSceneTransition":
+		_, err = obsws.NewSetCurrentTransitionRequest(obsString(data, "transitionName")).SendReceive(c.client)
+	case "SetCurrentSceneTransitionDuration":
+		_, err = obsws.NewSetTransitionDurationRequest(int(ToFloat(data["transitionDuration"]))).SendReceive(c.client)
+	case "GetSourceFilter":
+		var resp obsws.GetSourceFilterInfoResponse
+
+		resp, err = obsws.NewGetSourceFilterInfoRequest(obsString(data, "sourceName"), obsString(data, "filterName")).SendReceive(c.client)
+
+		if err == nil {
+			return map[string]interface{}{"filterEnabled": resp.Enabled, "filterKind": resp.Type_}, nil
+		}
+	case "SetSourceFilterEnabled":
+		_, err = obsws.NewSetSourceFilterVisibilityRequest(obsString(data, "sourceName"), obsString(data, "filterName"), obsBool(data, "filterEnabled")).SendReceive(c.client)
+	case "StartReplayBuffer":
+		_, err = obsws.NewStartReplayBufferRequest().SendReceive(c.client)
+	case "StopReplayBuffer":
+		_, err = obsws.NewStopReplayBufferRequest().SendReceive(c.client)
+	case "ToggleReplayBuffer":
+		_, err = obsws.NewStartStopReplayBufferRequest().SendReceive(c.client)
+	case "SaveReplayBuffer":
+		_, err = obsws.NewSaveReplayBufferRequest().SendReceive(c.client)
+	case "StartRecord":
+		_, err = obsws.NewStartRecordingRequest().SendReceive(c.client)
+	case "StopRecord":
+		_, err = obsws.NewStopRecordingRequest().SendReceive(c.client)
+	case "PauseRecord":
+		_, err = obsws.NewPauseRecordingRequest().SendReceive(c.client)
+	case "ResumeRecord":
+		_, err = obsws.NewResumeRecordingRequest().SendReceive(c.client)
+	case "StartStream":
+		_, err = obsws.NewStartStreamingRequest(nil, "", nil, nil, "", "", false, "", "").SendReceive(c.client)
+	case "StopStream":
+		_, err = obsws.NewStopStreamingRequest().SendReceive(c.client)
+	default:
+		return nil, fmt.Errorf("Request %s is not supported by OBS websocket protocol v4", requestType)
+	}
+	return nil, err
+}
+
+// RequestBatch executes requests one at a time, since batches are not supported by protocol v4
+func (c *obsV4Client) RequestBatch(requests []obsws5.Request, haltOnFailure bool) ([]obsws5.Response, error) {
+	var responses []obsws5.Response
+
+	for _, request := range requests {
+		data, err := c.Request(request.Type, request.Data)
+
+		responses = append(responses, obsws5.Response{Type: request.Type, Data: data, Err: err})
+
+		if err != nil && haltOnFailure {
+			break
+		}
+	}
+	return responses, nil
+}
@@ -0,0 +1,29 @@
+//go:build !noobs
+// +build !noobs
+
+package targets
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestV4SceneCollections(t *testing.T) {
+	// OBS websocket 4.7 reports names as strings, newer versions as objects
+	for _, list := range [][]interface{}{
+		{"first", "second"},
+		{map[string]interface{}{"name": "first"}, map[string]interface{}{"name": "second"}},
+	} {
+		collections, err := v4SceneCollections(map[string]interface{}{"status": "ok", "scene-collections": list})
+
+		if err != nil || fmt.Sprint(collections) != "[first second]" {
+			t.Errorf("Scene collections %v decoded as %v, %v", list, collections, err)
+		}
+	}
+
+	_, err := v4SceneCollections(map[string]interface{}{"status": "error", "error": "Failed"})
+
+	if err == nil || err.Error() != "Failed" {
+		t.Errorf("Error response returned %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package targets
+
+import (
+	"fmt"
+	"keypad/osc"
+	"log"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type oscCommandTarget struct {
+	commandsMap *Map
+	conn        net.Conn
+}
+
+type oscCommandTargetConfig struct {
+	Host string
+	Port int
+}
+
+var oscCommands = map[string]CommandDefinition{
+	"send": {
+		CheckFunc:   oscSendCheck,
+		ExecuteFunc: oscSendExec,
+		Description: "Sends an OSC message",
+		Parameters: []ParameterDefinition{
+			{Name: "address", Type: "string", Description: "OSC address (ex: /mixer/channel/1/mute)"},
+			{Name: "arguments", Type: "any", Description: "Arguments: integers, floats, strings, booleans or {type: <type>, value: <value>} objects, templates are sent as strings unless their type is declared", Optional: true, Variadic: true}}},
+}
+
+func init() {
+	Register("osc", func() CommandTarget { return new(oscCommandTarget) }, oscCommands)
+}
+
+// oscArgument converts a parameter to an OSC argument, a specific OSC type can
+// be selected using an object with type and value
+func oscArgument(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case int:
+		return int32(v), nil
+	case float64:
+		return float32(v), nil
+	case string, bool, nil:
+		return v, nil
+	case map[string]interface{}:
+		argtype, _ := v["type"].(string)
+		argvalue := v["value"]
+
+		switch argtype {
+		case "int", "long", "float", "double":
+			number, ok := ToNumber(argvalue)
+
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s OSC argument %v", argtype, argvalue)
+			}
+
+			switch argtype {
+			case "int":
+				return int32(ToFloat(number)), nil
+			case "long":
+				return int64(ToFloat(number)), nil
+			case "float":
+				return float32(ToFloat(number)), nil
+			}
+			return ToFloat(number), nil
+		case "string":
+			return fmt.Sprint(argvalue), nil
+		case "bool":
+			b, ok := ToBool(argvalue)
+
+			if !ok {
+				return nil, fmt.Errorf("Invalid bool OSC argument %v", argvalue)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("Invalid OSC argument type %v", v["type"])
+	}
+	return nil, fmt.Errorf("Invalid OSC argument %v", value)
+}
+
+func oscMessage(parameters []interface{}) (osc.Message, error) {
+	message := osc.Message{Address: parameters[0].(string)}
+
+	if !strings.HasPrefix(message.Address, "/") {
+		return message, fmt.Errorf("Invalid OSC address %s", message.Address)
+	}
+
+	for _, parameter := range parameters[1:] {
+		argument, err := oscArgument(parameter)
+
+		if err != nil {
+			return message, err
+		}
+
+		message.Arguments = append(message.Arguments, argument)
+	}
+	return message, nil
+}
+
+func oscSendCheck(target interface{}, parameters []interface{}) error {
+	_, err := oscMessage(parameters)
+	return err
+}
+
+func oscSendExec(target interface{}, parameters []interface{}) error {
+	o := target.(*oscCommandTarget)
+
+	message, _ := oscMessage(parameters)
+
+	packet, err := osc.Encode(message)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = o.conn.Write(packet)
+	return err
+}
+
+func (o *oscCommandTarget) Init(configyaml []byte) error {
+	cfg := oscCommandTargetConfig{
+		Host: "localhost",
+	}
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err != nil {
+		log.Printf("error %v parsing osc target configuration", err)
+		return err
+	}
+
+	if cfg.Port == 0 {
+		return fmt.Errorf("no port has been configured for osc target")
+	}
+
+	o.commandsMap = new(Map)
+	o.commandsMap.Init(o, oscCommands)
+
+	o.conn, err = net.Dial("udp", net.JoinHostPort(cfg.Host, fmt.Sprint(cfg.Port)))
+
+	if err != nil {
+		log.Printf("error %v connecting to %s:%d", err, cfg.Host, cfg.Port)
+		return err
+	}
+	return nil
+}
+
+func (o *oscCommandTarget) CheckCommand(command string, parameters []interface{}) error {
+	return o.commandsMap.CheckCommand(command, parameters)
+}
+
+func (o *oscCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return o.commandsMap.ExecuteCommand(command, parameters)
+}
@@ -0,0 +1,117 @@
+package targets
+
+import (
+	"fmt"
+	"keypad/osc"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newOSCTestTarget returns a target sending messages to a local UDP socket
+func newOSCTestTarget(t *testing.T) (*oscCommandTarget, net.PacketConn) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	o := new(oscCommandTarget)
+
+	err = o.Init([]byte(fmt.Sprintf("host: 127.0.0.1\nport: %d", conn.LocalAddr().(*net.UDPAddr).Port)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, conn
+}
+
+func TestOSCSend(t *testing.T) {
+	o, conn := newOSCTestTarget(t)
+
+	tests := []struct {
+		parameters []interface{}
+		expected   osc.Message
+	}{
+		{[]interface{}{"/go"}, osc.Message{Address: "/go"}},
+		{[]interface{}{"/mixer", 1, 0.5, "text", true, nil}, osc.Message{Address: "/mixer", Arguments: []interface{}{int32(1), float32(0.5), "text", true, nil}}},
+		{[]interface{}{"/typed",
+			map[string]interface{}{"type": "int", "value": 2.7},
+			map[string]interface{}{"type": "long", "value": 3},
+			map[string]interface{}{"type": "float", "value": 1},
+			map[string]interface{}{"type": "double", "value": 0.25},
+			map[string]interface{}{"type": "string", "value": 4},
+			map[string]interface{}{"type": "bool", "value": false}},
+			osc.Message{Address: "/typed", Arguments: []interface{}{int32(2), int64(3), float32(1), 0.25, "4", false}}},
+		// values generated by templates are strings, they are converted to the declared type
+		{[]interface{}{"/template",
+			"0.5",
+			map[string]interface{}{"type": "float", "value": "0.5"},
+			map[string]interface{}{"type": "int", "value": "12"},
+			map[string]interface{}{"type": "bool", "value": "true"}},
+			osc.Message{Address: "/template", Arguments: []interface{}{"0.5", float32(0.5), int32(12), true}}},
+	}
+
+	buffer := make([]byte, 65536)
+
+	for _, test := range tests {
+		err := o.CheckCommand("send", test.parameters)
+
+		if err == nil {
+			err = o.ExecuteCommand("send", test.parameters)
+		}
+
+		if err != nil {
+			t.Errorf("Send %v failed: %v", test.parameters, err)
+			continue
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		size, _, err := conn.ReadFrom(buffer)
+
+		if err != nil {
+			t.Fatalf("Message %v not received: %v", test.expected, err)
+		}
+
+		messages, err := osc.Decode(buffer[:size])
+
+		if err != nil || len(messages) != 1 || !reflect.DeepEqual(messages[0], test.expected) {
+			t.Errorf("Send %v sent %v, %v instead of %v", test.parameters, messages, err, test.expected)
+		}
+	}
+}
+
+func TestOSCSendErrors(t *testing.T) {
+	o, _ := newOSCTestTarget(t)
+
+	tests := []struct {
+		parameters []interface{}
+		error      string
+	}{
+		{[]interface{}{"mixer"}, "Invalid OSC address mixer"},
+		{[]interface{}{"/mixer", []interface{}{1}}, "Invalid OSC argument [1]"},
+		{[]interface{}{"/mixer", map[string]interface{}{"type": "blob", "value": "a"}}, "Invalid OSC argument type blob"},
+		{[]interface{}{"/mixer", map[string]interface{}{"value": 1}}, "Invalid OSC argument type <nil>"},
+		{[]interface{}{"/mixer", map[string]interface{}{"type": "float", "value": "loud"}}, "Invalid float OSC argument loud"},
+		{[]interface{}{"/mixer", map[string]interface{}{"type": "bool", "value": "maybe"}}, "Invalid bool OSC argument maybe"},
+	}
+
+	for _, test := range tests {
+		err := o.CheckCommand("send", test.parameters)
+
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Send %v returned %v, expected %q", test.parameters, err, test.error)
+		}
+	}
+
+	err := new(oscCommandTarget).Init([]byte("host: localhost"))
+
+	if err == nil || !strings.Contains(err.Error(), "no port") {
+		t.Errorf("Init without port returned %v", err)
+	}
+}
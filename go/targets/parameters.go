@@ -0,0 +1,209 @@
+package targets
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Limit returns a pointer to a value, used to set Min and Max of a parameter definition
+func Limit(value float64) *float64 {
+	return &value
+}
+
+// Template is used in place of a parameter whose value is known only after template expansion,
+// it allows checking a command when it's loaded, its value is validated at execution time
+type Template struct{}
+
+// ValidateParameters checks parameters against their definitions: count, type, allowed values and range.
+// Strings containing a number or a boolean are accepted for numeric and boolean parameters,
+// since that's the result of template expansion
+func ValidateParameters(command string, definitions []ParameterDefinition, parameters []interface{}) error {
+	required := 0
+	variadic := len(definitions) != 0 && definitions[len(definitions)-1].Variadic
+
+	for _, definition := range definitions {
+		if !definition.Optional {
+			required++
+		}
+	}
+
+	if len(parameters) < required || (!variadic && len(parameters) > len(definitions)) {
+		return fmt.Errorf("Invalid parameters count for %s command", command)
+	}
+
+	for index, value := range parameters {
+		definition := definitions[len(definitions)-1]
+
+		if index < len(definitions) {
+			definition = definitions[index]
+		}
+
+		if _, ok := value.(Template); ok {
+			continue
+		}
+
+		err := validateParameter(definition, value)
+
+		if err != nil {
+			return fmt.Errorf("Invalid %s parameter for %s command: %v", definition.Name, command, err)
+		}
+	}
+	return nil
+}
+
+func validateParameter(definition ParameterDefinition, value interface{}) error {
+	switch definition.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%v is not a string", value)
+		}
+	case "number", "integer":
+		number, ok := ToNumber(value)
+
+		if !ok {
+			return fmt.Errorf("%v is not a number", value)
+		}
+
+		f := ToFloat(number)
+
+		// whole values are accepted as integers, some sources report all numbers as floats (ex: JSON)
+		if definition.Type == "integer" && f != math.Trunc(f) {
+			return fmt.Errorf("%v is not an integer", value)
+		}
+
+		if definition.Min != nil && f < *definition.Min {
+			return fmt.Errorf("%v is lower than %v", value, *definition.Min)
+		}
+
+		if definition.Max != nil && f > *definition.Max {
+			return fmt.Errorf("%v is greater than %v", value, *definition.Max)
+		}
+	case "boolean":
+		if _, ok := ToBool(value); !ok {
+			return fmt.Errorf("%v is not a boolean", value)
+		}
+	case "duration":
+		duration, err := ParseDuration(value)
+
+		if err != nil {
+			return err
+		}
+
+		if duration < 0 {
+			return fmt.Errorf("%v is negative", value)
+		}
+	case "array":
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Errorf("%v is not an array", value)
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("%v is not an object", value)
+		}
+	}
+
+	if len(definition.Enum) != 0 {
+		text := fmt.Sprint(value)
+
+		for _, allowed := range definition.Enum {
+			if text == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of the allowed values", value)
+	}
+	return nil
+}
+
+// hasTemplates returns true if a value is, or contains, a Template
+func hasTemplates(value interface{}) bool {
+	switch v := value.(type) {
+	case Template:
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if hasTemplates(item) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasTemplates(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ToNumber converts a value of any integer or floating point type (or a string containing a
+// number) to int or float64
+func ToNumber(value interface{}) (interface{}, bool) {
+	if text, ok := value.(string); ok {
+		if i, err := strconv.Atoi(text); err == nil {
+			return i, true
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, true
+		}
+		return nil, false
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return nil, false
+}
+
+// ToFloat converts a value returned by ToNumber to float64
+func ToFloat(value interface{}) float64 {
+	if i, ok := value.(int); ok {
+		return float64(i)
+	}
+	return value.(float64)
+}
+
+// FloatParameter converts a parameter that has been validated as number or integer to float64
+func FloatParameter(value interface{}) float64 {
+	number, _ := ToNumber(value)
+	return ToFloat(number)
+}
+
+// ToBool converts a value (or a string containing a boolean) to bool
+func ToBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, true
+		}
+	}
+	return false, false
+}
+
+// ParseDuration accepts a string in go format (ex: 500ms, 1m30s) or a number of milliseconds
+func ParseDuration(value interface{}) (time.Duration, error) {
+	if text, ok := value.(string); ok {
+		if duration, err := time.ParseDuration(text); err == nil {
+			return duration, nil
+		}
+	}
+
+	number, ok := ToNumber(value)
+
+	if !ok {
+		return 0, fmt.Errorf("Invalid duration %v", value)
+	}
+	return time.Duration(ToFloat(number) * float64(time.Millisecond)), nil
+}
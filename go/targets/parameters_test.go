@@ -0,0 +1,57 @@
+package targets
+
+import (
+	"testing"
+)
+
+func TestToNumber(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{3, 3},
+		{int8(-3), -3},
+		{int32(3), 3},
+		{int64(3), 3},
+		{uint16(3), 3},
+		{uint64(3), 3},
+		{float32(0.5), 0.5},
+		{2.5, 2.5},
+		{"3", 3},
+		{"2.5", 2.5},
+	}
+
+	for _, test := range tests {
+		number, ok := ToNumber(test.value)
+
+		if !ok || number != test.expected {
+			t.Errorf("ToNumber(%T %v) returned %v, %v, expected %v", test.value, test.value, number, ok, test.expected)
+		}
+	}
+
+	for _, value := range []interface{}{"three", true, nil, []interface{}{1}} {
+		if _, ok := ToNumber(value); ok {
+			t.Errorf("ToNumber(%v) accepted", value)
+		}
+	}
+}
+
+func TestValidateIntegerParameters(t *testing.T) {
+	definitions := []ParameterDefinition{{Name: "count", Type: "integer", Min: Limit(0), Max: Limit(100)}}
+
+	for _, value := range []interface{}{3, int64(3), uint8(3), 3.0, float32(3), "3", "3.0"} {
+		err := ValidateParameters("test", definitions, []interface{}{value})
+
+		if err != nil {
+			t.Errorf("Integer %T %v not accepted: %v", value, value, err)
+		}
+	}
+
+	for _, value := range []interface{}{3.5, "3.5", int64(-1), 101.0, "three"} {
+		err := ValidateParameters("test", definitions, []interface{}{value})
+
+		if err == nil {
+			t.Errorf("Invalid integer %T %v accepted", value, value)
+		}
+	}
+}
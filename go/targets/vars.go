@@ -0,0 +1,295 @@
+package targets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+type varsCommandTarget struct {
+	commandsMap *Map
+	file        string
+	values      map[string]interface{}
+	types       map[string]interface{} // sample values for variables referenced by commands
+	mutex       sync.Mutex
+}
+
+type varsCommandTargetConfig struct {
+	File   string
+	Values map[string]interface{}
+}
+
+var varsCommands = map[string]CommandDefinition{
+	"set": {
+		CheckFunc:   setVarCheck,
+		ExecuteFunc: setVarExec,
+		Description: "Sets the value of a variable",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Variable name"},
+			{Name: "value", Type: "any", Description: "Value"}}},
+	"increment": {
+		CheckFunc:   stepVarCheck,
+		ExecuteFunc: incrementVarExec,
+		Description: "Adds a value to a numeric variable",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Variable name"},
+			{Name: "step", Type: "number", Description: "Value added (default is 1)", Optional: true}}},
+	"decrement": {
+		CheckFunc:   stepVarCheck,
+		ExecuteFunc: decrementVarExec,
+		Description: "Subtracts a value from a numeric variable",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Variable name"},
+			{Name: "step", Type: "number", Description: "Value subtracted (default is 1)", Optional: true}}},
+	"toggle": {
+		CheckFunc:   toggleVarCheck,
+		ExecuteFunc: toggleVarExec,
+		Description: "Inverts a boolean variable",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Variable name"}}},
+	"cycle": {
+		CheckFunc:   cycleVarCheck,
+		ExecuteFunc: cycleVarExec,
+		Description: "Sets a variable to the value that follows the current one in a list",
+		Parameters: []ParameterDefinition{
+			{Name: "name", Type: "string", Description: "Variable name"},
+			{Name: "values", Type: "any", Description: "Values", Variadic: true}}},
+}
+
+// declare records the type of a variable, so it can be used in conditions
+func (vars *varsCommandTarget) declare(name string, sample interface{}) {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	if _, ok := vars.types[name]; !ok {
+		vars.types[name] = sample
+	}
+}
+
+func setVarCheck(target interface{}, parameters []interface{}) error {
+	target.(*varsCommandTarget).declare(parameters[0].(string), parameters[1])
+	return nil
+}
+
+func setVarExec(target interface{}, parameters []interface{}) error {
+	vars := target.(*varsCommandTarget)
+
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	vars.values[parameters[0].(string)] = parameters[1]
+	return vars.save()
+}
+
+func stepVarCheck(target interface{}, parameters []interface{}) error {
+	target.(*varsCommandTarget).declare(parameters[0].(string), 0)
+	return nil
+}
+
+func incrementVarExec(target interface{}, parameters []interface{}) error {
+	vars := target.(*varsCommandTarget)
+	return vars.step(parameters, 1)
+}
+
+func decrementVarExec(target interface{}, parameters []interface{}) error {
+	vars := target.(*varsCommandTarget)
+	return vars.step(parameters, -1)
+}
+
+func toggleVarCheck(target interface{}, parameters []interface{}) error {
+	target.(*varsCommandTarget).declare(parameters[0].(string), false)
+	return nil
+}
+
+func toggleVarExec(target interface{}, parameters []interface{}) error {
+	vars := target.(*varsCommandTarget)
+	name := parameters[0].(string)
+
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	value, _ := vars.values[name].(bool)
+
+	vars.values[name] = !value
+	return vars.save()
+}
+
+func cycleVarCheck(target interface{}, parameters []interface{}) error {
+	target.(*varsCommandTarget).declare(parameters[0].(string), parameters[1])
+	return nil
+}
+
+func cycleVarExec(target interface{}, parameters []interface{}) error {
+	vars := target.(*varsCommandTarget)
+	name := parameters[0].(string)
+	values := parameters[1:]
+
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	current, ok := vars.values[name]
+	index := 0
+
+	if ok {
+		for i, v := range values {
+			if fmt.Sprint(v) == fmt.Sprint(current) {
+				index = (i + 1) % len(values)
+				break
+			}
+		}
+	}
+
+	vars.values[name] = values[index]
+	return vars.save()
+}
+
+func (vars *varsCommandTarget) step(parameters []interface{}, direction int) error {
+	name := parameters[0].(string)
+
+	var step interface{} = 1
+
+	if len(parameters) == 2 {
+		step, _ = ToNumber(parameters[1])
+	}
+
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	var value interface{} = 0
+
+	if current, ok := vars.values[name]; ok {
+		value, ok = ToNumber(current)
+
+		if !ok {
+			return fmt.Errorf("Variable %s is not a number", name)
+		}
+	}
+
+	istep, stepisint := step.(int)
+	ivalue, valueisint := value.(int)
+
+	if stepisint && valueisint {
+		vars.values[name] = ivalue + istep*direction
+	} else {
+		vars.values[name] = ToFloat(value) + ToFloat(step)*float64(direction)
+	}
+	return vars.save()
+}
+
+// save writes values to the configured file, must be called with mutex locked
+func (vars *varsCommandTarget) save() error {
+	if vars.file == "" {
+		return nil
+	}
+
+	data, err := yaml.Marshal(vars.values)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(vars.file, data, 0644)
+}
+
+func (vars *varsCommandTarget) load() error {
+	data, err := ioutil.ReadFile(vars.file)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+
+	err = yaml.Unmarshal(data, &values)
+
+	if err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		vars.values[name] = value
+	}
+	return nil
+}
+
+func init() {
+	Register("vars", func() CommandTarget { return new(varsCommandTarget) }, varsCommands)
+}
+
+func (vars *varsCommandTarget) Init(configyaml []byte) error {
+	var cfg varsCommandTargetConfig
+
+	err := yaml.Unmarshal(configyaml, &cfg)
+
+	if err != nil {
+		log.Printf("error %v parsing vars target configuration", err)
+		return err
+	}
+
+	vars.commandsMap = new(Map)
+	vars.commandsMap.Init(vars, varsCommands)
+
+	vars.file = cfg.File
+	vars.values = make(map[string]interface{})
+	vars.types = make(map[string]interface{})
+
+	for name, value := range cfg.Values {
+		vars.values[name] = value
+		vars.types[name] = value
+	}
+
+	if vars.file != "" {
+		err = vars.load()
+
+		if err != nil {
+			log.Printf("error %v loading variables from %s", err, vars.file)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vars *varsCommandTarget) CheckCommand(command string, parameters []interface{}) error {
+	return vars.commandsMap.CheckCommand(command, parameters)
+}
+
+func (vars *varsCommandTarget) ExecuteCommand(command string, parameters []interface{}) error {
+	return vars.commandsMap.ExecuteCommand(command, parameters)
+}
+
+func (vars *varsCommandTarget) GetState() map[string]interface{} {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	state := make(map[string]interface{}, len(vars.values))
+
+	for name, value := range vars.values {
+		state[name] = value
+	}
+	return state
+}
+
+func (vars *varsCommandTarget) GetStateTypes() map[string]interface{} {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+
+	types := make(map[string]interface{}, len(vars.types)+len(vars.values))
+
+	for name, value := range vars.values {
+		types[name] = value
+	}
+
+	for name, value := range vars.types {
+		types[name] = value
+	}
+	return types
+}